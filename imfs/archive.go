@@ -0,0 +1,270 @@
+package imfs
+
+import (
+	"bytes"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// archiveEntry is a file, directory or symbolic link on its way into or
+// out of an archive. Its name is slash-separated and relative, with no
+// trailing slash. The data of a link is its target. The owner and group
+// are -1 for formats that do not record them. A regular file that is a
+// hard link to an earlier entry has that entry's name in hardlink; it
+// keeps its data for formats without hard links.
+type archiveEntry struct {
+	name     string
+	mode     fs.FileMode
+	uid      int
+	gid      int
+	mtime    time.Time
+	data     []byte
+	xattrs   map[string][]byte
+	hardlink string
+}
+
+// archiveName cleans the name of an archive entry, which may not lead
+// outside the directory the archive is extracted into.
+func archiveName(name string) (string, error) {
+	components := split(name)
+	if slices.Contains(components, "..") {
+		return "", fs.ErrInvalid
+	}
+	return strings.Join(components, "/"), nil
+}
+
+// exportEntries returns src and everything below it in depth-first order,
+// named relative to the directory holding src. When src is the root only
+// its contents are returned. A regular file whose inode was already
+// returned under another name is marked as a hard link to that name. The
+// tree is read in a single consistent pass.
+func (fsys *FS) exportEntries(op, src string) ([]archiveEntry, error) {
+	fsys.mu.RLock()
+	defer fsys.mu.RUnlock()
+
+	f, err := fsys.lookup(op, src)
+	if err != nil {
+		return nil, err
+	}
+	if err := fsys.permitRead(op, f); err != nil {
+		return nil, err
+	}
+	var entries []archiveEntry
+	names := make(map[*Inode]string)
+	var add func(f *File, name string)
+	add = func(f *File, name string) {
+		if name != "" {
+			e := archiveEntry{
+				name:  name,
+				mode:  f.info().mode,
+				uid:   f.UID,
+				gid:   f.GID,
+				mtime: f.ModifiedAt,
+				data:  bytes.Clone(f.Content),
+			}
+			if f.IsSymlink {
+				e.data = []byte(f.Target)
+			} else if len(f.Xattrs) > 0 {
+				e.xattrs = fsys.visibleXattrs(f)
+			}
+			if !f.IsDirectory && !f.IsSymlink {
+				if first, ok := names[f.Inode]; ok {
+					e.hardlink = first
+				} else {
+					names[f.Inode] = name
+				}
+			}
+			entries = append(entries, e)
+			name += "/"
+		}
+		children := slices.SortedFunc(slices.Values(f.Children), func(a, b *File) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+		for _, child := range children {
+			add(child, name+child.Name)
+		}
+	}
+	name := f.Name
+	if f == fsys.Root {
+		name = ""
+	}
+	add(f, name)
+	return entries, nil
+}
+
+// importEntries creates entries below the directory dest, which is created
+// if it does not exist. Existing directories are merged into, and any
+// other existing entry is removed and made afresh, as tar(1) does, so that
+// neither its other hard links nor what a link there points to are
+// touched. A hard link must be to a regular file earlier in entries. As
+// with tar, the superuser gets the modes and owners recorded in the
+// archive, while anyone else owns what they extract and has their umask
+// applied.
+func (fsys *FS) importEntries(op, dest string, entries []archiveEntry) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	root, err := fsys.mkdirAll(op, dest)
+	if err != nil {
+		return err
+	}
+	base := root.path()
+	type dirEntry struct {
+		dir *File
+		e   archiveEntry
+	}
+	var dirs []dirEntry
+	var links []archiveEntry
+	files := make(map[string]*File)
+	for _, e := range entries {
+		name := path.Join(base, e.name)
+		if e.mode&fs.ModeSymlink != 0 {
+			links = append(links, e)
+			continue
+		}
+		if e.hardlink != "" {
+			// The file linked to is the one just extracted, never one
+			// reached by a path the archive could have planted.
+			target, ok := files[e.hardlink]
+			if e.hardlink == e.name {
+				continue
+			}
+			if !ok {
+				return &fs.PathError{Op: op, Path: e.name, Err: fs.ErrNotExist}
+			}
+			if err := fsys.importHardlink(op, name, target); err != nil {
+				return err
+			}
+			files[e.name] = target
+			continue
+		}
+		if e.mode.IsDir() {
+			dir, err := fsys.mkdirAll(op, name)
+			if err != nil {
+				return err
+			}
+			dirs = append(dirs, dirEntry{dir, e})
+			continue
+		}
+		if _, _, err := fsys.importSlot(op, name); err != nil {
+			return err
+		}
+		f, err := fsys.openFile(op, name, true, e.mode.Perm())
+		if err != nil {
+			return err
+		}
+		if err := fsys.writeFile(f, e.data); err != nil {
+			return err
+		}
+		if err := fsys.restore(f, e); err != nil {
+			return err
+		}
+		files[e.name] = f
+	}
+	// Links are made once everything else is in place, so that no entry
+	// can be written through a link the archive itself has planted.
+	for _, e := range links {
+		if err := fsys.importLink(op, path.Join(base, e.name), e); err != nil {
+			return err
+		}
+	}
+	// Directories are finished last, since filling a directory changes its
+	// time and may need the permissions the archive takes away.
+	for _, d := range slices.Backward(dirs) {
+		if err := fsys.restore(d.dir, d.e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importLink makes the symbolic link described by e at name, replacing an
+// existing file or link there.
+func (fsys *FS) importLink(op, name string, e archiveEntry) error {
+	if len(e.data) == 0 {
+		return &fs.PathError{Op: op, Path: e.name, Err: fs.ErrInvalid}
+	}
+	dir, base, err := fsys.importSlot(op, name)
+	if err != nil {
+		return err
+	}
+	if err := fsys.symlink(dir, base, string(e.data)); err != nil {
+		return err
+	}
+	link := dir.child(base)
+	if fsys.cred.UID == 0 && e.uid >= 0 && e.gid >= 0 {
+		if err := fsys.chown(link, e.uid, e.gid); err != nil {
+			return err
+		}
+	}
+	return fsys.chtimes(link, e.mtime)
+}
+
+// importHardlink makes name a hard link to target, replacing an existing
+// file or link there.
+func (fsys *FS) importHardlink(op, name string, target *File) error {
+	dir, base, err := fsys.importSlot(op, name)
+	if err != nil {
+		return err
+	}
+	return fsys.linkAt(op, target, dir, base)
+}
+
+// importSlot returns the directory that name is to be made in, created if
+// need be since archives need not list the directories their files are
+// in, and its base name, once any file or link already there has been
+// removed.
+func (fsys *FS) importSlot(op, name string) (*File, string, error) {
+	dir, err := fsys.mkdirAll(op, path.Dir(name))
+	if err != nil {
+		return nil, "", err
+	}
+	base := path.Base(name)
+	if existing := dir.child(base); existing != nil {
+		if existing.IsDirectory {
+			return nil, "", &fs.PathError{Op: op, Path: name, Err: ErrIsDir}
+		}
+		if err := fsys.permitUnlink(op, existing); err != nil {
+			return nil, "", err
+		}
+		if err := fsys.remove(existing); err != nil {
+			return nil, "", err
+		}
+	}
+	return dir, base, nil
+}
+
+// restore gives f the mode, extended attributes and modification time
+// recorded in e and, for the superuser, its owner. Attributes the user of
+// fsys may not set are left out, as permission bits are.
+func (fsys *FS) restore(f *File, e archiveEntry) error {
+	// Attributes go first, while f is sure to be writable.
+	for _, name := range slices.Sorted(maps.Keys(e.xattrs)) {
+		if fsys.checkXattr("setxattr", f, name, true) != nil {
+			continue
+		}
+		if err := fsys.setXattr(f, name, e.xattrs[name]); err != nil {
+			return err
+		}
+	}
+	switch {
+	case fsys.cred.UID == 0:
+		if err := fsys.chmod(f, e.mode&modeBits); err != nil {
+			return err
+		}
+		if e.uid >= 0 && e.gid >= 0 {
+			if err := fsys.chown(f, e.uid, e.gid); err != nil {
+				return err
+			}
+		}
+	case fsys.owns(f):
+		if err := fsys.chmod(f, e.mode.Perm()&^fsys.umask); err != nil {
+			return err
+		}
+	}
+	return fsys.chtimes(f, e.mtime)
+}
@@ -0,0 +1,723 @@
+package imfs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// builtins are the commands every shell starts with.
+var builtins = []*builtin{
+	{"ls", "ls [-i] [<path>...]", (*Shell).runLs},
+	{"cd", "cd [<path>]", (*Shell).runCd},
+	{"pwd", "pwd [-P]", (*Shell).runPwd},
+	{"mkdir", "mkdir [-p] <path>...", (*Shell).runMkdir},
+	{"touch", "touch <file>...", (*Shell).runTouch},
+	{"cat", "cat [<file>...]", (*Shell).runCat},
+	{"echo", "echo [-n] [<word>...]", (*Shell).runEcho},
+	{"grep", "grep [-cinv] <pattern> [<file>...]", (*Shell).runGrep},
+	{"mv", "mv <source>... <destination>", (*Shell).runMv},
+	{"cp", "cp <source>... <destination>", (*Shell).runCp},
+	{"find", "find <pattern>", (*Shell).runFind},
+	{"rm", "rm [-r] <path>...", (*Shell).runRm},
+	{"save", "save <host_path>", (*Shell).runSave},
+	{"load", "load <host_path>", (*Shell).runLoad},
+	{"tar", "tar -cf <archive> <path> | tar -xf <archive> [-C <dir>]", (*Shell).runTar},
+	{"zip", "zip <archive> <path>", (*Shell).runZip},
+	{"unzip", "unzip <archive> [-d <dir>]", (*Shell).runUnzip},
+	{"mount", "mount <zip_file>", (*Shell).runMount},
+	{"umount", "umount <zip_file>", (*Shell).runUmount},
+	{"chmod", "chmod <mode> <path>...", (*Shell).runChmod},
+	{"chown", "chown <owner[:group]> <path>...", (*Shell).runChown},
+	{"chgrp", "chgrp <group> <path>...", (*Shell).runChown},
+	{"umask", "umask [<mask>]", (*Shell).runUmask},
+	{"id", "id", (*Shell).runID},
+	{"whoami", "whoami", (*Shell).runWhoami},
+	{"su", "su [<user>]", (*Shell).runSu},
+	{"sudo", "sudo <command>", (*Shell).runSudo},
+	{"flock", "flock [-s|-x] [-n] <file> <command>", (*Shell).runFlock},
+	{"useradd", "useradd [-u <uid>] [-g <group>] <name>", (*Shell).runUseradd},
+	{"groupadd", "groupadd [-g <gid>] <name>", (*Shell).runUseradd},
+	{"ln", "ln [-s] <target> <link>", (*Shell).runLn},
+	{"stat", "stat <path>...", (*Shell).runStat},
+	{"getfattr", "getfattr [-d] [-n <name>] <path>", (*Shell).runGetfattr},
+	{"setfattr", "setfattr -n <name> [-v <value>] <path> | setfattr -x <name> <path>", (*Shell).runSetfattr},
+	{"readlink", "readlink <link>", (*Shell).runReadlink},
+	{"write", "write <file> <content>", (*Shell).runWrite},
+	{"append", "append <file> <content>", (*Shell).runWrite},
+	{"exit", "exit [<status>]", (*Shell).runExit},
+	{"true", "true", (*Shell).runTrue},
+	{":", ":", (*Shell).runTrue},
+	{"false", "false", (*Shell).runFalse},
+	{"clear", "clear", (*Shell).runClear},
+	{"history", "history [-c] [<n>]", (*Shell).runHistory},
+	{"set", "set [-e|+e]", (*Shell).runSet},
+	{"export", "export [<name>[=<value>]...]", (*Shell).runExport},
+	{"unset", "unset <name>...", (*Shell).runUnset},
+	{"env", "env [<name>=<value>...] [<command>]", (*Shell).runEnv},
+	{"source", "source <file>", (*Shell).runSource},
+	{".", ". <file>", (*Shell).runSource},
+	{"alias", "alias [<name>[=<value>]...]", (*Shell).runAlias},
+	{"unalias", "unalias -a | unalias <name>...", (*Shell).runUnalias},
+	{"test", "test <expression>", (*Shell).runTest},
+	{"[", "[ <expression> ]", (*Shell).runTest},
+	{"break", "break [<n>]", (*Shell).runBreak},
+	{"continue", "continue [<n>]", (*Shell).runBreak},
+	{"return", "return [<status>]", (*Shell).runReturn},
+	{"help", "help [<command>...]", (*Shell).runHelp},
+}
+
+func (s *Shell) runLs(argv []string, stdio Stdio) (int, error) {
+	opts, args, err := getopt(argv, "i", 0, -1)
+	if err != nil {
+		return 0, err
+	}
+	if len(args) == 0 {
+		_, err = s.list(stdio.Out, opts.has('i'))
+		return 0, err
+	}
+	return 0, s.listPaths(stdio.Out, args, opts.has('i'))
+}
+
+func (s *Shell) runCd(argv []string, stdio Stdio) (int, error) {
+	_, args, err := getopt(argv, "", 0, 1)
+	if err != nil {
+		return 0, err
+	}
+	// As in other shells, cd alone goes home and cd - goes back.
+	name, ok := strings.Join(args, ""), true
+	back := name == "-"
+	switch name {
+	case "":
+		if name, ok = s.vars["HOME"]; !ok {
+			return 0, errors.New("HOME not set")
+		}
+	case "-":
+		if name, ok = s.vars["OLDPWD"]; !ok {
+			return 0, errors.New("OLDPWD not set")
+		}
+	}
+	if err = s.Cd(name); err == nil && back {
+		fmt.Fprintln(stdio.Out, s.Pwd())
+	}
+	return 0, err
+}
+
+func (s *Shell) runPwd(argv []string, stdio Stdio) (int, error) {
+	opts, _, err := getopt(argv, "LP", 0, 0)
+	if err != nil {
+		return 0, err
+	}
+	if opts.has('P') {
+		fmt.Fprintln(stdio.Out, s.PwdPhysical())
+	} else {
+		fmt.Fprintln(stdio.Out, s.Pwd())
+	}
+	return 0, nil
+}
+
+func (s *Shell) runMkdir(argv []string, stdio Stdio) (int, error) {
+	opts, args, err := getopt(argv, "p", 1, -1)
+	if err != nil {
+		return 0, err
+	}
+	for _, name := range args {
+		err = errors.Join(err, s.Mkdir(name, opts.has('p')))
+	}
+	return 0, err
+}
+
+func (s *Shell) runTouch(argv []string, stdio Stdio) (int, error) {
+	_, args, err := getopt(argv, "", 1, -1)
+	if err != nil {
+		return 0, err
+	}
+	for _, name := range args {
+		err = errors.Join(err, s.Touch(name))
+	}
+	return 0, err
+}
+
+func (s *Shell) runCat(argv []string, stdio Stdio) (int, error) {
+	_, args, err := getopt(argv, "", 0, -1)
+	if err != nil {
+		return 0, err
+	}
+	if len(args) == 0 {
+		args = []string{"-"}
+	}
+	for _, name := range args {
+		err = errors.Join(err, s.cat(name, stdio))
+	}
+	return 0, err
+}
+
+func (s *Shell) runEcho(argv []string, stdio Stdio) (int, error) {
+	// As in other shells, echo takes no options but a leading -n.
+	args, end := argv[1:], "\n"
+	if len(args) > 0 && args[0] == "-n" {
+		args, end = args[1:], ""
+	}
+	fmt.Fprint(stdio.Out, strings.Join(args, " ")+end)
+	return 0, nil
+}
+
+func (s *Shell) runGrep(argv []string, stdio Stdio) (int, error) {
+	opts, args, err := getopt(argv, "cinv", 1, -1)
+	if err != nil {
+		return 0, err
+	}
+	found, err := s.grep(args[0], args[1:], opts, stdio)
+	if err != nil {
+		// As with grep(1), an error is worse than selecting no lines.
+		return 2, err
+	}
+	return boolStatus(found), nil
+}
+
+func (s *Shell) runMv(argv []string, stdio Stdio) (int, error) {
+	_, args, err := getopt(argv, "", 2, -1)
+	if err != nil {
+		return 0, err
+	}
+	return 0, s.eachSource(args, s.Move)
+}
+
+func (s *Shell) runCp(argv []string, stdio Stdio) (int, error) {
+	_, args, err := getopt(argv, "", 2, -1)
+	if err != nil {
+		return 0, err
+	}
+	return 0, s.eachSource(args, s.Copy)
+}
+
+// eachSource runs move, which is Move or Copy, for each of the sources
+// in args with the last of them, the destination. As with mv(1) and
+// cp(1), several sources need a directory to go into.
+func (s *Shell) eachSource(args []string, move func(source, dest string) error) error {
+	sources, dest := args[:len(args)-1], args[len(args)-1]
+	if len(sources) > 1 {
+		f, err := s.Resolve(dest)
+		if err != nil {
+			return err
+		}
+		if !f.IsDirectory {
+			return &fs.PathError{Op: "stat", Path: dest, Err: ErrNotDir}
+		}
+	}
+	var err error
+	for _, source := range sources {
+		err = errors.Join(err, move(source, dest))
+	}
+	return err
+}
+
+func (s *Shell) runFind(argv []string, stdio Stdio) (int, error) {
+	_, args, err := getopt(argv, "", 1, 1)
+	if err != nil {
+		return 0, err
+	}
+	if result := s.Find(args[0]); result != "" {
+		fmt.Fprintln(stdio.Out, result)
+	}
+	return 0, nil
+}
+
+func (s *Shell) runRm(argv []string, stdio Stdio) (int, error) {
+	opts, args, err := getopt(argv, "rR", 1, -1)
+	if err != nil {
+		return 0, err
+	}
+	for _, name := range args {
+		err = errors.Join(err, s.Remove(name, opts.has('r') || opts.has('R')))
+	}
+	return 0, err
+}
+
+func (s *Shell) runSave(argv []string, stdio Stdio) (int, error) {
+	_, args, err := getopt(argv, "", 1, 1)
+	if err != nil {
+		return 0, err
+	}
+	return 0, s.FS.SaveFile(args[0])
+}
+
+func (s *Shell) runLoad(argv []string, stdio Stdio) (int, error) {
+	_, args, err := getopt(argv, "", 1, 1)
+	if err != nil {
+		return 0, err
+	}
+	if err = s.FS.LoadFile(args[0]); err == nil {
+		s.Cwd = s.Root
+		s.vars["PWD"] = s.Pwd()
+	}
+	return 0, err
+}
+
+func (s *Shell) runTar(argv []string, stdio Stdio) (int, error) {
+	opts, args, err := getopt(argv, "cxf:C:", 0, 1)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case !opts.has('f') || opts.has('c') == opts.has('x'):
+		return 0, &usageError{}
+	case opts.has('c') && len(args) == 1 && !opts.has('C'):
+		return 0, s.TarCreate(opts['f'], args[0])
+	case opts.has('x') && len(args) == 0:
+		dir := "."
+		if opts.has('C') {
+			dir = opts['C']
+		}
+		return 0, s.TarExtract(opts['f'], dir)
+	}
+	return 0, &usageError{}
+}
+
+func (s *Shell) runZip(argv []string, stdio Stdio) (int, error) {
+	_, args, err := getopt(argv, "", 2, 2)
+	if err != nil {
+		return 0, err
+	}
+	return 0, s.Zip(args[0], args[1])
+}
+
+func (s *Shell) runUnzip(argv []string, stdio Stdio) (int, error) {
+	opts, args, err := getopt(argv, "d:", 1, 1)
+	if err != nil {
+		return 0, err
+	}
+	dir := "."
+	if opts.has('d') {
+		dir = opts['d']
+	}
+	return 0, s.Unzip(args[0], dir)
+}
+
+func (s *Shell) runMount(argv []string, stdio Stdio) (int, error) {
+	_, args, err := getopt(argv, "", 1, 1)
+	if err != nil {
+		return 0, err
+	}
+	return 0, s.Mount(args[0])
+}
+
+func (s *Shell) runUmount(argv []string, stdio Stdio) (int, error) {
+	_, args, err := getopt(argv, "", 1, 1)
+	if err != nil {
+		return 0, err
+	}
+	return 0, s.Unmount(args[0])
+}
+
+func (s *Shell) runChmod(argv []string, stdio Stdio) (int, error) {
+	// Modes such as -w look like options, so chmod takes none.
+	if len(argv) < 3 {
+		return 0, &usageError{}
+	}
+	var err error
+	for _, name := range argv[2:] {
+		err = errors.Join(err, s.Chmod(argv[1], name))
+	}
+	return 0, err
+}
+
+// runChown runs chown and chgrp.
+func (s *Shell) runChown(argv []string, stdio Stdio) (int, error) {
+	_, args, err := getopt(argv, "", 2, -1)
+	if err != nil {
+		return 0, err
+	}
+	for _, name := range args[1:] {
+		if argv[0] == "chown" {
+			err = errors.Join(err, s.Chown(args[0], name))
+		} else {
+			err = errors.Join(err, s.Chgrp(args[0], name))
+		}
+	}
+	return 0, err
+}
+
+func (s *Shell) runUmask(argv []string, stdio Stdio) (int, error) {
+	// As with chmod, a mask is never taken for an option.
+	if len(argv) > 2 {
+		return 0, &usageError{}
+	}
+	mask, err := s.Umask(strings.Join(argv[1:], ""))
+	if err == nil && len(argv) == 1 {
+		fmt.Fprintf(stdio.Out, "%04o\n", mask)
+	}
+	return 0, err
+}
+
+func (s *Shell) runID(argv []string, stdio Stdio) (int, error) {
+	if _, _, err := getopt(argv, "", 0, 0); err != nil {
+		return 0, err
+	}
+	fmt.Fprintln(stdio.Out, s.ID())
+	return 0, nil
+}
+
+func (s *Shell) runWhoami(argv []string, stdio Stdio) (int, error) {
+	if _, _, err := getopt(argv, "", 0, 0); err != nil {
+		return 0, err
+	}
+	name, err := s.Whoami()
+	if err == nil {
+		fmt.Fprintln(stdio.Out, name)
+	}
+	return 0, err
+}
+
+func (s *Shell) runSu(argv []string, stdio Stdio) (int, error) {
+	_, args, err := getopt(argv, "", 0, 1)
+	if err != nil {
+		return 0, err
+	}
+	return 0, s.Su(strings.Join(args, ""))
+}
+
+func (s *Shell) runSudo(argv []string, stdio Stdio) (int, error) {
+	_, args, err := getopt(argv, "+", 1, -1)
+	if err != nil {
+		return 0, err
+	}
+	var status int
+	err = s.Sudo(func() { status = s.run(args, stdio) })
+	return status, err
+}
+
+func (s *Shell) runFlock(argv []string, stdio Stdio) (int, error) {
+	opts, args, err := getopt(argv, "+sxn", 2, -1)
+	if err != nil {
+		return 0, err
+	}
+	typ := LockExclusive
+	if opts.has('s') {
+		typ = LockShared
+	}
+	var status int
+	err = s.Flock(args[0], typ, !opts.has('n'), func() { status = s.run(args[1:], stdio) })
+	return status, err
+}
+
+// runUseradd runs useradd and groupadd.
+func (s *Shell) runUseradd(argv []string, stdio Stdio) (int, error) {
+	spec := "g:"
+	if argv[0] == "useradd" {
+		spec = "u:g:"
+	}
+	opts, args, err := getopt(argv, spec, 1, 1)
+	if err != nil {
+		return 0, err
+	}
+	id := -1
+	if value, ok := opts['u']; ok {
+		if id, err = parseID(value); err != nil {
+			return 0, err
+		}
+	}
+	switch {
+	case argv[0] == "useradd":
+		return 0, s.Useradd(args[0], id, opts['g'])
+	case opts.has('g'):
+		if id, err = parseID(opts['g']); err != nil {
+			return 0, err
+		}
+	}
+	return 0, s.Groupadd(args[0], id)
+}
+
+func (s *Shell) runLn(argv []string, stdio Stdio) (int, error) {
+	opts, args, err := getopt(argv, "s", 2, 2)
+	if err != nil {
+		return 0, err
+	}
+	if opts.has('s') {
+		return 0, s.Symlink(args[0], args[1])
+	}
+	return 0, s.Link(args[0], args[1])
+}
+
+func (s *Shell) runStat(argv []string, stdio Stdio) (int, error) {
+	_, args, err := getopt(argv, "", 1, -1)
+	if err != nil {
+		return 0, err
+	}
+	for _, name := range args {
+		info, statErr := s.Describe(name)
+		if statErr == nil {
+			fmt.Fprint(stdio.Out, info)
+		}
+		err = errors.Join(err, statErr)
+	}
+	return 0, err
+}
+
+func (s *Shell) runGetfattr(argv []string, stdio Stdio) (int, error) {
+	opts, args, err := getopt(argv, "dn:", 1, 1)
+	if err != nil {
+		return 0, err
+	}
+	out, err := s.Getfattr(args[0], opts['n'], opts.has('d'))
+	if err == nil {
+		fmt.Fprint(stdio.Out, out)
+	}
+	return 0, err
+}
+
+func (s *Shell) runSetfattr(argv []string, stdio Stdio) (int, error) {
+	opts, args, err := getopt(argv, "n:v:x:", 1, 1)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case opts.has('x') && !opts.has('n') && !opts.has('v'):
+		return 0, s.Removefattr(args[0], opts['x'])
+	case opts.has('n') && !opts.has('x'):
+		return 0, s.Setfattr(args[0], opts['n'], opts['v'])
+	}
+	return 0, &usageError{}
+}
+
+func (s *Shell) runReadlink(argv []string, stdio Stdio) (int, error) {
+	_, args, err := getopt(argv, "", 1, 1)
+	if err != nil {
+		return 0, err
+	}
+	target, err := s.Readlink(args[0])
+	if err == nil {
+		fmt.Fprintln(stdio.Out, target)
+	}
+	return 0, err
+}
+
+// runWrite runs write and append.
+func (s *Shell) runWrite(argv []string, stdio Stdio) (int, error) {
+	// The content is taken as it is, even if it looks like an option.
+	_, args, err := getopt(argv, "+", 2, -1)
+	if err != nil {
+		return 0, err
+	}
+	return 0, s.RedirectWrite(args[0], strings.Join(args[1:], " "), argv[0] == "append")
+}
+
+func (s *Shell) runExit(argv []string, stdio Stdio) (int, error) {
+	_, args, err := getopt(argv, "", 0, 1)
+	if err != nil {
+		return 0, err
+	}
+	status := s.status
+	if len(args) > 0 {
+		if status, err = strconv.Atoi(args[0]); err != nil {
+			return 0, &usageError{args[0] + ": numeric argument required"}
+		}
+	}
+	if !s.Exit() {
+		s.exited = true
+	}
+	return status, nil
+}
+
+// runTrue runs true and :.
+func (s *Shell) runTrue(argv []string, stdio Stdio) (int, error) {
+	return 0, nil
+}
+
+func (s *Shell) runFalse(argv []string, stdio Stdio) (int, error) {
+	return 1, nil
+}
+
+func (s *Shell) runClear(argv []string, stdio Stdio) (int, error) {
+	fmt.Fprint(stdio.Out, clearScreen)
+	return 0, nil
+}
+
+func (s *Shell) runHistory(argv []string, stdio Stdio) (int, error) {
+	opts, args, err := getopt(argv, "c", 0, 1)
+	if err != nil {
+		return 0, err
+	}
+	n := -1
+	if len(args) > 0 {
+		if n, err = strconv.Atoi(args[0]); err != nil || n < 0 {
+			return 0, &usageError{args[0] + ": numeric argument required"}
+		}
+	}
+	if opts.has('c') {
+		s.clearHistory()
+	} else {
+		s.printHistory(stdio.Out, n)
+	}
+	return 0, nil
+}
+
+func (s *Shell) runSet(argv []string, stdio Stdio) (int, error) {
+	if len(argv) == 1 {
+		for _, name := range slices.Sorted(maps.Keys(s.vars)) {
+			fmt.Fprintf(stdio.Out, "%s=%s\n", name, quote(s.vars[name]))
+		}
+	}
+	var err error
+	for _, arg := range argv[1:] {
+		switch arg {
+		case "-e":
+			s.errexit = true
+		case "+e":
+			s.errexit = false
+		default:
+			err = &usageError{arg + ": invalid option"}
+		}
+	}
+	return 0, err
+}
+
+func (s *Shell) runExport(argv []string, stdio Stdio) (int, error) {
+	if len(argv) == 1 {
+		for _, assignment := range s.Environ() {
+			name, value, _ := strings.Cut(assignment, "=")
+			fmt.Fprintf(stdio.Out, "export %s=%s\n", name, quote(value))
+		}
+	}
+	var err error
+	for _, arg := range argv[1:] {
+		name, value, set := strings.Cut(arg, "=")
+		exportErr := s.Export(name)
+		if exportErr == nil && set {
+			s.vars[name] = value
+		}
+		err = errors.Join(err, exportErr)
+	}
+	return 0, err
+}
+
+func (s *Shell) runUnset(argv []string, stdio Stdio) (int, error) {
+	var err error
+	for _, name := range argv[1:] {
+		err = errors.Join(err, s.Unset(name))
+	}
+	return 0, err
+}
+
+func (s *Shell) runEnv(argv []string, stdio Stdio) (int, error) {
+	// The assignments before the command, if any, are made for it only.
+	args := argv[1:]
+	n := 0
+	for n < len(args) && isAssignment(args[n]) {
+		n++
+	}
+	status := 0
+	s.withEnv(args[:n], func() {
+		if n < len(args) {
+			status = s.run(args[n:], stdio)
+			return
+		}
+		for _, assignment := range s.Environ() {
+			fmt.Fprintln(stdio.Out, assignment)
+		}
+	})
+	return status, nil
+}
+
+// runSource runs source and its other name, ".".
+func (s *Shell) runSource(argv []string, stdio Stdio) (int, error) {
+	_, args, err := getopt(argv, "", 1, 1)
+	if err != nil {
+		return 0, err
+	}
+	return s.Source(args[0], stdio)
+}
+
+func (s *Shell) runAlias(argv []string, stdio Stdio) (int, error) {
+	if len(argv) == 1 {
+		for _, name := range slices.Sorted(maps.Keys(s.aliases)) {
+			fmt.Fprintf(stdio.Out, "alias %s=%s\n", name, quote(s.aliases[name]))
+		}
+	}
+	var err error
+	for _, arg := range argv[1:] {
+		name, value, set := strings.Cut(arg, "=")
+		current, ok := s.aliases[name]
+		switch {
+		case set && !validAliasName(name):
+			err = errors.Join(err, errors.New(name+": invalid alias name"))
+		case set:
+			s.aliases[name] = value
+		case ok:
+			fmt.Fprintf(stdio.Out, "alias %s=%s\n", name, quote(current))
+		default:
+			err = errors.Join(err, errors.New(name+": not found"))
+		}
+	}
+	return 0, err
+}
+
+func (s *Shell) runUnalias(argv []string, stdio Stdio) (int, error) {
+	opts, args, err := getopt(argv, "a", 0, -1)
+	if err != nil {
+		return 0, err
+	}
+	if opts.has('a') {
+		clear(s.aliases)
+	} else if len(args) == 0 {
+		return 0, &usageError{}
+	}
+	for _, name := range args {
+		if _, ok := s.aliases[name]; !ok {
+			err = errors.Join(err, errors.New(name+": not found"))
+		}
+		delete(s.aliases, name)
+	}
+	return 0, err
+}
+
+// runTest runs test and its other name, [, which needs a closing ].
+func (s *Shell) runTest(argv []string, stdio Stdio) (int, error) {
+	args := argv[1:]
+	if argv[0] == "[" {
+		if len(args) == 0 || args[len(args)-1] != "]" {
+			return 2, errors.New("missing `]'")
+		}
+		args = args[:len(args)-1]
+	}
+	ok, err := s.test(args)
+	if err != nil {
+		return 2, err
+	}
+	return boolStatus(ok), nil
+}
+
+// runBreak runs break and continue.
+func (s *Shell) runBreak(argv []string, stdio Stdio) (int, error) {
+	_, args, err := getopt(argv, "", 0, 1)
+	if err != nil {
+		return 0, err
+	}
+	count := &s.breaking
+	if argv[0] == "continue" {
+		count = &s.continuing
+	}
+	return 0, s.loopControl(args, count)
+}
+
+func (s *Shell) runReturn(argv []string, stdio Stdio) (int, error) {
+	_, args, err := getopt(argv, "", 0, 1)
+	if err != nil {
+		return 0, err
+	}
+	if s.calls == 0 && s.sourcing == 0 {
+		return 0, errors.New("can only `return' from a function or sourced script")
+	}
+	status := s.status
+	if len(args) > 0 {
+		if status, err = strconv.Atoi(args[0]); err != nil {
+			return 0, &usageError{args[0] + ": numeric argument required"}
+		}
+	}
+	s.returning = true
+	return status, nil
+}
@@ -0,0 +1,133 @@
+package imfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"slices"
+)
+
+// A Command is a command the shell runs by name. The built-ins are
+// commands like any other, and Register adds more or replaces them.
+type Command interface {
+	// Name returns the name the command is run by.
+	Name() string
+
+	// Usage returns the synopsis of the command, such as
+	// "grep [-cinv] <pattern> [<file>...]", which help prints.
+	Usage() string
+
+	// Run runs the command with its name and arguments in argv and the
+	// given standard streams, and returns its status: zero if it
+	// succeeded and non-zero if it failed. ctx carries the shell running
+	// the command, which ShellFromContext returns.
+	Run(ctx context.Context, argv []string, stdio Stdio) int
+}
+
+// shellKey is the context key for the shell running a command.
+type shellKey struct{}
+
+// ShellFromContext returns the shell running the command that ctx was
+// passed to, or nil if there is none.
+func ShellFromContext(ctx context.Context) *Shell {
+	s, _ := ctx.Value(shellKey{}).(*Shell)
+	return s
+}
+
+// Register adds cmd to the commands s runs, in place of any command of
+// the same name, built-in or not. A function of that name still comes
+// first. Subshells started after it is registered run it too.
+func (s *Shell) Register(cmd Command) {
+	name := cmd.Name()
+	if name == "" {
+		panic("imfs: Register of a command without a name")
+	}
+	s.commands[name] = cmd
+}
+
+// runCommand runs the registered command named by argv[0], and returns its
+// status, which is 127 if there is no such command.
+func (s *Shell) runCommand(argv []string, stdio Stdio) int {
+	cmd, ok := s.commands[argv[0]]
+	if !ok {
+		fmt.Fprintf(stdio.Err, "%s: %s: command not found\n", shellName, argv[0])
+		return 127
+	}
+	ctx := context.WithValue(context.Background(), shellKey{}, s)
+	return cmd.Run(ctx, argv, stdio)
+}
+
+// A builtin is a command built into the shell. Its run function returns
+// the status, or a usageError to have the usage printed, which makes the
+// status 2, or some other error to have it printed, which makes it 1
+// unless run returned another.
+type builtin struct {
+	name  string
+	usage string
+	run   func(s *Shell, argv []string, stdio Stdio) (int, error)
+}
+
+func (b *builtin) Name() string  { return b.name }
+func (b *builtin) Usage() string { return b.usage }
+
+func (b *builtin) Run(ctx context.Context, argv []string, stdio Stdio) int {
+	status, err := b.run(ShellFromContext(ctx), argv, stdio)
+	var usage *usageError
+	switch {
+	case errors.As(err, &usage):
+		if usage.msg != "" {
+			diagnose(stdio.Err, argv[0], usage)
+		}
+		fmt.Fprintln(stdio.Err, "Usage:", b.usage)
+		return 2
+	case err != nil:
+		diagnose(stdio.Err, argv[0], err)
+		return max(status, 1)
+	}
+	return status
+}
+
+// diagnose writes err to w as a diagnostic of the command name, a line
+// for each of the errors joined in it, in the form "name: path: reason"
+// for an *fs.PathError, whose operation name stands in for, and
+// "name: reason" for any other.
+func diagnose(w io.Writer, name string, err error) {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			diagnose(w, name, err)
+		}
+		return
+	}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		fmt.Fprintf(w, "%s: %s: %v\n", name, pathErr.Path, pathErr.Err)
+		return
+	}
+	fmt.Fprintf(w, "%s: %v\n", name, err)
+}
+
+// commandNames returns the names of the commands s runs, in order.
+func (s *Shell) commandNames() []string {
+	return slices.Sorted(maps.Keys(s.commands))
+}
+
+// runHelp lists the synopsis of every command, or of those named.
+func (s *Shell) runHelp(argv []string, stdio Stdio) (int, error) {
+	names := argv[1:]
+	if len(names) == 0 {
+		names = s.commandNames()
+	}
+	var err error
+	for _, name := range names {
+		cmd, ok := s.commands[name]
+		if !ok {
+			err = errors.Join(err, errors.New(name+": no such command"))
+			continue
+		}
+		fmt.Fprintln(stdio.Out, cmd.Usage())
+	}
+	return 0, err
+}
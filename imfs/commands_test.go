@@ -0,0 +1,110 @@
+package imfs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// greet is a command for the tests, which greets its arguments or the
+// user the shell acts for.
+type greet struct{}
+
+func (greet) Name() string  { return "greet" }
+func (greet) Usage() string { return "greet [<name>...]" }
+
+func (greet) Run(ctx context.Context, argv []string, stdio Stdio) int {
+	names := argv[1:]
+	if len(names) == 0 {
+		name, err := ShellFromContext(ctx).Whoami()
+		if err != nil {
+			fmt.Fprintln(stdio.Err, err)
+			return 1
+		}
+		names = []string{name}
+	}
+	fmt.Fprintln(stdio.Out, "hello", strings.Join(names, " "))
+	return 0
+}
+
+// shout replaces echo in the tests.
+type shout struct{}
+
+func (shout) Name() string  { return "echo" }
+func (shout) Usage() string { return "echo <word>..." }
+
+func (shout) Run(ctx context.Context, argv []string, stdio Stdio) int {
+	fmt.Fprintln(stdio.Out, strings.ToUpper(strings.Join(argv[1:], " ")))
+	return 0
+}
+
+func TestRegister(t *testing.T) {
+	shell := NewShell()
+	shell.Register(greet{})
+
+	// Test a registered command runs like a built-in
+	out, _ := runLine(shell, "greet; greet a b | grep b && echo ok")
+	assertEqual(t, "hello root\nhello a b\nok\n", out, "Expected the command to run with the shell in its context")
+	out, _ = runLine(shell, "greet > note; cat note; echo $(greet x)")
+	assertEqual(t, "hello root\nhello x\n", out, "Expected the command in redirections and substitutions")
+
+	// Test a command replaces the built-in of its name, but not a function
+	shell.Register(shout{})
+	out, _ = runLine(shell, "echo hi; greet() { echo fn; }; greet")
+	assertEqual(t, "HI\nFN\n", out, "Expected the registered echo, and the function before the command")
+
+	// Test a subshell takes the commands but does not give them back
+	sub := shell.subshell()
+	sub.commands["only"] = greet{}
+	_, errOut := runLine(shell, "only")
+	assertEqual(t, "imfs: only: command not found\n", errOut, "Expected a subshell's commands to be its own")
+	other := NewShell()
+	out, _ = runLine(other, "echo hi")
+	assertEqual(t, "hi\n", out, "Expected other shells to keep the built-in")
+}
+
+func TestHelp(t *testing.T) {
+	shell := NewShell()
+	shell.Register(greet{})
+
+	// Test help lists every command by name
+	out, _ := runLine(shell, "help")
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	assertEqual(t, len(shell.commands), len(lines), "Expected a line for each command")
+	assertEqual(t, ". <file>", lines[0], "Expected the commands in order of name")
+	assertEqual(t, true, strings.Contains(out, "\ngreet [<name>...]\n"), "Expected registered commands to be listed")
+
+	// Test help for named commands
+	out, errOut := runLine(shell, "help ls greet nothing; echo $?")
+	assertEqual(t, "ls [-i] [<path>...]\ngreet [<name>...]\n1\n", out, "Expected the usage of each command")
+	assertEqual(t, "help: nothing: no such command\n", errOut, "Expected an unknown command to fail")
+}
+
+func TestDiagnostics(t *testing.T) {
+	shell := NewShell()
+	shell.Mkdir("a", false)
+
+	// Test errors go to standard error as cmd: path: reason, with status 1
+	out, errOut := runLine(shell, "rm nope; echo $?; mkdir a b a; echo $?")
+	assertEqual(t, "1\n1\n", out, "Expected only the statuses on standard output")
+	assertEqual(t, "rm: nope: file does not exist\nmkdir: a: file already exists\nmkdir: a: file already exists\n", errOut, "Expected a line for each error, named by the command")
+	_, errOut = runLine(shell, "cd; cd nope; cat < nope")
+	assertEqual(t, "cd: nope: file does not exist\nimfs: nope: file does not exist\n", errOut, "Expected errors of the shell itself to be named by it")
+
+	// Test usage errors have status 2, and unknown commands 127
+	out, errOut = runLine(shell, "mv a; echo $?; ls -z; echo $?")
+	assertEqual(t, "2\n2\n", out, "Expected status 2 for a usage error")
+	assertEqual(t, "Usage: mv <source>... <destination>\nls: invalid option -- 'z'\nUsage: ls [-i] [<path>...]\n", errOut, "Expected the usage by the command's own name")
+	out, errOut = runLine(shell, "nope; echo $?; grep '(' a; echo $?")
+	assertEqual(t, "127\n2\n", out, "Expected status 127 for an unknown command and 2 for a grep error")
+	assertEqual(t, "imfs: nope: command not found\n", strings.Split(errOut, "\n")[0]+"\n", "Expected the shell to report an unknown command")
+
+	// Test a script has the status of its last command
+	var errs strings.Builder
+	status := shell.Exec("true\nfalse", Stdio{In: strings.NewReader(""), Out: &errs, Err: &errs})
+	assertEqual(t, 1, status, "Expected the status of the last command")
+	status = shell.Exec("echo 'x", Stdio{In: strings.NewReader(""), Out: &errs, Err: &errs})
+	assertEqual(t, 2, status, "Expected status 2 for a syntax error")
+	assertEqual(t, true, strings.HasPrefix(errs.String(), "imfs: syntax error: "), "Expected the shell to report a syntax error")
+}
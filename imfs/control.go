@@ -0,0 +1,336 @@
+package imfs
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxCallDepth limits how deeply functions may call one another.
+const maxCallDepth = 256
+
+// compound runs the compound command c and returns its status.
+func (s *Shell) compound(c any, stdio Stdio) int {
+	switch c := c.(type) {
+	case *ifClause:
+		for i, cond := range c.conds {
+			if s.condition(cond, stdio) == 0 {
+				return s.runList(c.bodies[i], stdio)
+			}
+			if s.unwinding() {
+				return s.status
+			}
+		}
+		if c.els != nil {
+			return s.runList(c.els, stdio)
+		}
+		return 0
+	case *forClause:
+		words := s.params
+		if c.in {
+			words = s.expand(c.words, stdio)
+		}
+		s.loops++
+		defer func() { s.loops-- }()
+		status := 0
+		for _, word := range words {
+			s.vars[c.name] = word
+			status = s.runList(c.body, stdio)
+			if s.loopDone() {
+				break
+			}
+		}
+		return status
+	case *whileClause:
+		s.loops++
+		defer func() { s.loops-- }()
+		status := 0
+		for {
+			ok := s.condition(c.cond, stdio) == 0
+			if s.unwinding() && s.loopDone() || ok == c.until {
+				break
+			}
+			status = s.runList(c.body, stdio)
+			if s.loopDone() {
+				break
+			}
+		}
+		return status
+	case *group:
+		return s.runList(c.body, stdio)
+	case *funcDef:
+		s.functions[c.name] = c.body
+		return 0
+	}
+	panic(fmt.Sprintf("imfs: unknown compound command %T", c))
+}
+
+// condition runs the condition of an if or while, in which a failure does
+// not end the shell under set -e.
+func (s *Shell) condition(l list, stdio Stdio) int {
+	s.conditions++
+	defer func() { s.conditions-- }()
+	return s.runList(l, stdio)
+}
+
+// unwinding reports whether exit, break, continue or return has been run
+// and not yet reached the end of what it ends.
+func (s *Shell) unwinding() bool {
+	return s.exited || s.returning || s.breaking > 0 || s.continuing > 0
+}
+
+// loopDone is called at the end of each pass through a loop, and reports
+// whether the loop is to end, for break, exit or return, or because
+// continue named an outer loop.
+func (s *Shell) loopDone() bool {
+	switch {
+	case s.breaking > 0:
+		s.breaking--
+		return true
+	case s.continuing > 0:
+		s.continuing--
+		return s.continuing > 0
+	}
+	return s.exited || s.returning
+}
+
+// loopControl runs break or continue with the operands args, setting
+// *count to the number of loops to leave.
+func (s *Shell) loopControl(args []string, count *int) error {
+	n := 1
+	if len(args) > 0 {
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+			return &usageError{args[0] + ": loop count out of range"}
+		}
+	}
+	if s.loops == 0 {
+		return errors.New("only meaningful in a `for', `while', or `until' loop")
+	}
+	*count = min(n, s.loops)
+	return nil
+}
+
+// call runs the function body with argv[1:] as its positional parameters
+// and returns its status.
+func (s *Shell) call(body command, argv []string, stdio Stdio) int {
+	if s.calls == maxCallDepth {
+		fmt.Fprintf(stdio.Err, "%s: %s: maximum function nesting level exceeded\n", shellName, argv[0])
+		return 1
+	}
+	params, loops := s.params, s.loops
+	s.params, s.loops = argv[1:], 0
+	s.calls++
+	defer func() {
+		s.params, s.loops = params, loops
+		s.calls--
+		s.returning = false
+	}()
+	return s.command(body, stdio)
+}
+
+// boolStatus returns the status for a test: 0 if ok and 1 if not.
+func boolStatus(ok bool) int {
+	if ok {
+		return 0
+	}
+	return 1
+}
+
+// test evaluates the expression args of test or [, as test(1) does.
+// Files are looked up in the tree. The primaries are
+//
+//	-e file   file exists
+//	-f file   file exists and is a regular file
+//	-d file   file exists and is a directory
+//	-s file   file exists and is not empty
+//	-L file   file is a symbolic link (or -h)
+//	-n str    str is not empty
+//	-z str    str is empty
+//	s1 = s2   the strings are equal (or ==); != < > compare them too
+//	n1 -eq n2 the integers are equal; -ne -lt -le -gt -ge compare them too
+//	str       str is not empty
+//
+// and they may be negated with !, joined with -a and -o and grouped in
+// parentheses.
+func (s *Shell) test(args []string) (bool, error) {
+	t := &tester{s: s, args: args}
+	if len(args) == 0 {
+		return false, nil
+	}
+	ok, err := t.or()
+	if err == nil && t.pos < len(args) {
+		err = fmt.Errorf("%s: unexpected argument", args[t.pos])
+	}
+	return ok, err
+}
+
+// A tester walks through the arguments of test.
+type tester struct {
+	s    *Shell
+	args []string
+	pos  int
+}
+
+func (t *tester) next() (string, error) {
+	if t.pos == len(t.args) {
+		return "", errors.New("argument expected")
+	}
+	t.pos++
+	return t.args[t.pos-1], nil
+}
+
+// peek reports whether the next argument is arg.
+func (t *tester) peek(arg string) bool {
+	return t.pos < len(t.args) && t.args[t.pos] == arg
+}
+
+func (t *tester) or() (bool, error) {
+	ok, err := t.and()
+	for err == nil && t.peek("-o") {
+		t.pos++
+		var right bool
+		right, err = t.and()
+		ok = ok || right
+	}
+	return ok, err
+}
+
+func (t *tester) and() (bool, error) {
+	ok, err := t.not()
+	for err == nil && t.peek("-a") {
+		t.pos++
+		var right bool
+		right, err = t.not()
+		ok = ok && right
+	}
+	return ok, err
+}
+
+func (t *tester) not() (bool, error) {
+	// A lone ! is a string, not a negation.
+	if t.peek("!") && t.pos+1 < len(t.args) {
+		t.pos++
+		ok, err := t.not()
+		return !ok, err
+	}
+	return t.primary()
+}
+
+func (t *tester) primary() (bool, error) {
+	if t.pos+2 < len(t.args) && isBinaryTest(t.args[t.pos+1]) {
+		left, op, right := t.args[t.pos], t.args[t.pos+1], t.args[t.pos+2]
+		t.pos += 3
+		return t.s.binaryTest(left, op, right)
+	}
+	arg, err := t.next()
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case arg == "(" && t.pos < len(t.args):
+		ok, err := t.or()
+		if err != nil {
+			return false, err
+		}
+		if !t.peek(")") {
+			return false, errors.New("missing `)'")
+		}
+		t.pos++
+		return ok, nil
+	case isUnaryTest(arg) && t.pos < len(t.args):
+		operand, _ := t.next()
+		return t.s.unaryTest(arg, operand), nil
+	}
+	return arg != "", nil
+}
+
+func isUnaryTest(op string) bool {
+	switch op {
+	case "-e", "-f", "-d", "-s", "-L", "-h", "-n", "-z":
+		return true
+	}
+	return false
+}
+
+func isBinaryTest(op string) bool {
+	switch op {
+	case "=", "==", "!=", "<", ">", "-eq", "-ne", "-lt", "-le", "-gt", "-ge":
+		return true
+	}
+	return false
+}
+
+// unaryTest evaluates the unary primary op with its operand.
+func (s *Shell) unaryTest(op, operand string) bool {
+	switch op {
+	case "-n":
+		return operand != ""
+	case "-z":
+		return operand == ""
+	}
+
+	var f *File
+	var err error
+	if op == "-L" || op == "-h" {
+		f, err = s.resolveLink("stat", operand)
+	} else {
+		f, err = s.Resolve(operand)
+	}
+	if err != nil {
+		return false
+	}
+	s.FS.mu.RLock()
+	defer s.FS.mu.RUnlock()
+
+	switch op {
+	case "-f":
+		return !f.IsDirectory && f.mount == nil
+	case "-d":
+		// A mounted archive is taken as a directory, as by cd.
+		return f.IsDirectory || f.mount != nil
+	case "-s":
+		// Directories are never empty, holding at least . and .. as
+		// far as test(1) is concerned.
+		return f.IsDirectory || f.mount != nil || f.Size > 0
+	case "-L", "-h":
+		return f.IsSymlink
+	}
+	return true
+}
+
+// binaryTest evaluates the binary primary op with its operands.
+func (s *Shell) binaryTest(left, op, right string) (bool, error) {
+	switch op {
+	case "=", "==":
+		return left == right, nil
+	case "!=":
+		return left != right, nil
+	case "<":
+		return left < right, nil
+	case ">":
+		return left > right, nil
+	}
+	a, err := strconv.Atoi(strings.TrimSpace(left))
+	if err != nil {
+		return false, fmt.Errorf("%s: integer expression expected", left)
+	}
+	b, err := strconv.Atoi(strings.TrimSpace(right))
+	if err != nil {
+		return false, fmt.Errorf("%s: integer expression expected", right)
+	}
+	switch op {
+	case "-eq":
+		return a == b, nil
+	case "-ne":
+		return a != b, nil
+	case "-lt":
+		return a < b, nil
+	case "-le":
+		return a <= b, nil
+	case "-gt":
+		return a > b, nil
+	}
+	return a >= b, nil
+}
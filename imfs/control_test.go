@@ -0,0 +1,165 @@
+package imfs
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseCompound(t *testing.T) {
+	l, err := parseLine("if a; then b; elif c\nthen d; else e; fi | f > out; ! g", nil)
+	assertEqual(t, nil, err, "Expected the if to parse")
+	c, ok := l[0].cmds[0].compound.(*ifClause)
+	assertEqual(t, true, ok, "Expected an if clause")
+	assertEqual(t, 2, len(c.conds), "Expected a condition for if and elif")
+	assertEqual(t, "e", c.els[0].cmds[0].words[0].text, "Expected the else branch")
+	assertEqual(t, "out", l[0].cmds[1].redirs[0].word.text, "Expected the pipeline to go on after fi")
+	assertEqual(t, true, l[1].negate, "Expected ! to negate the pipeline")
+
+	l, err = parseLine("for x in a 'b c'; do for y do z; done; done; while a; do b; done < in", nil)
+	assertEqual(t, nil, err, "Expected the loops to parse")
+	loop := l[0].cmds[0].compound.(*forClause)
+	assertEqual(t, 2, len(loop.words), "Expected the words after in")
+	inner := loop.body[0].cmds[0].compound.(*forClause)
+	assertEqual(t, false, inner.in, "Expected a for without in to loop over the parameters")
+	assertEqual(t, "in", l[1].cmds[0].redirs[0].word.text, "Expected a redirection after done")
+
+	l, err = parseLine("f() {\n  echo 'if'; }; echo fi", nil)
+	assertEqual(t, nil, err, "Expected the function to parse")
+	def := l[0].cmds[0].compound.(*funcDef)
+	assertEqual(t, "f", def.name, "Expected the name of the function")
+	assertEqual(t, "fi", l[1].cmds[0].words[1].text, "Expected reserved words as arguments to be words")
+
+	// Test misplaced and missing reserved words
+	var syntaxErr *SyntaxError
+	for _, line := range []string{"fi", "if then fi", "if a; fi", "for 1 in a; do b; done", "while a; done", "{ }", "f() echo", "echo )"} {
+		_, err := parseLine(line, nil)
+		assertEqual(t, true, errors.As(err, &syntaxErr) && !incomplete(err), "Expected a SyntaxError for "+line)
+	}
+	for _, line := range []string{"if a; then", "for x in a b", "while a; do b;\n", "f() {", "{ a; b"} {
+		_, err := parseLine(line, nil)
+		assertEqual(t, true, incomplete(err), "Expected "+line+" to be incomplete")
+	}
+
+	// Test aliases are expanded in the first word only
+	aliases := map[string]string{"ll": "ls -i", "a": "b", "b": "a x", "e": ""}
+	l, _ = parseLine("ll ll; 'll'; a; e echo", aliases)
+	var words []string
+	for _, st := range l {
+		for _, w := range st.cmds[0].words {
+			words = append(words, w.text)
+		}
+		words = append(words, ";")
+	}
+	assertEqual(t, "ls -i ll ; ll ; a x ; echo ;", strings.Join(words, " "), "Expected aliases to expand once each")
+}
+
+func TestControlFlow(t *testing.T) {
+	shell := NewShell()
+	shell.Mkdir("d", false)
+	shell.RedirectWrite("d/f", "data", false)
+	shell.Touch("d/empty")
+
+	// Test if, elif and else
+	out, _ := runLine(shell, "for f in d/f d/empty d/none d; do if [ -s $f ]; then echo $f full; elif [ -f $f ]; then echo $f empty; elif ! test -e $f; then echo $f none; else echo $f other; fi; done")
+	assertEqual(t, "d/f full\nd/empty empty\nd/none none\nd full\n", out, "Expected the branch of the first condition to hold")
+	out, _ = runLine(shell, "if false; then echo x; fi; echo $?")
+	assertEqual(t, "0\n", out, "Expected an if without a branch run to succeed")
+
+	// Test while, until, break and continue
+	out, _ = runLine(shell, `i=; while [ "$i" != xxx ]; do i=${i}x; echo $i; done; until [ -z "$i" ]; do i=; echo until; done`)
+	assertEqual(t, "x\nxx\nxxx\nuntil\n", out, "Expected while and until to loop on their conditions")
+	out, _ = runLine(shell, "for x in 1 2 3; do for y in a b c; do [ $y = b ] && continue 2; [ $x = 3 ] && break 2; echo $x$y; done; echo never; done")
+	assertEqual(t, "1a\n2a\n", out, "Expected break and continue to act on outer loops")
+	_, errOut := runLine(shell, "break")
+	assertEqual(t, "break: only meaningful in a `for', `while', or `until' loop\n", errOut, "Expected break outside a loop to fail")
+
+	// Test redirections of compound commands, and set -e in conditions
+	runLine(shell, "{ echo one; echo two; } > both; for x in a b; do echo $x; done >> both")
+	content, _ := shell.Cat("both")
+	assertEqual(t, "one\ntwo\na\nb\n", content, "Expected the output of the whole command to be redirected")
+	out, _ = runLine(shell, "set -e; if false; then :; fi; while false; do :; done; ! true; echo still; false; echo never")
+	assertEqual(t, "still\n", out, "Expected set -e to ignore conditions and negated pipelines")
+}
+
+func TestFunctions(t *testing.T) {
+	shell := NewShell()
+
+	// Test positional parameters and "$@"
+	out, _ := runLine(shell, `args() { echo $# "$1" $2; for a in "$@"; do echo "[$a]"; done; for a; do echo "<$a>"; done; }; args "a b" c; args`)
+	assertEqual(t, "2 a b c\n[a b]\n[c]\n<a b>\n<c>\n0 \n", out, "Expected the arguments of each call")
+
+	// Test return, and functions in pipelines and over built-ins
+	out, _ = runLine(shell, "f() { echo in; return 3; echo after; }; f; echo $?; f | grep -c in; echo() { :; }; echo hidden")
+	assertEqual(t, "in\n3\n1\n", out, "Expected return to end the function with its status")
+	_, errOut := runLine(shell, "unset_echo() { :; }; return")
+	assertEqual(t, "return: can only `return' from a function or sourced script\n", errOut, "Expected return outside a function to fail")
+
+	// Test recursion is limited
+	_, errOut = runLine(shell, "loop() { loop; }; loop")
+	assertEqual(t, "imfs: loop: maximum function nesting level exceeded\n", errOut, "Expected endless recursion to stop")
+}
+
+func TestAliases(t *testing.T) {
+	shell := NewShell()
+	shell.Mkdir("dir", false)
+
+	// Test aliases apply from the next line on
+	out, _ := runLine(shell, "alias l='ls -i' e=echo")
+	assertEqual(t, "", out, "Expected alias to define quietly")
+	out, _ = runLine(shell, "l; e hi; alias e; alias")
+	assertEqual(t, "2 dir/\nhi\nalias e=echo\nalias e=echo\nalias l='ls -i'\n", out, "Expected aliases to expand and list")
+	_, errOut := runLine(shell, "unalias l; alias l 'x y=z'")
+	assertEqual(t, "alias: l: not found\nalias: x y: invalid alias name\n", errOut, "Expected unknown and bad names to be refused")
+	runLine(shell, "unalias -a")
+	out, _ = runLine(shell, "alias")
+	assertEqual(t, "", out, "Expected unalias -a to remove every alias")
+}
+
+func TestTest(t *testing.T) {
+	shell := NewShell()
+	shell.Mkdir("dir", false)
+	shell.RedirectWrite("file", "x", false)
+	shell.Symlink("dir", "link")
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"-e file", true},
+		{"-f dir", false},
+		{"-d link", true},
+		{"-L link", true},
+		{"-L dir", false},
+		{"-s file", true},
+		{"-s dir", true},
+		{"-e nothing", false},
+		{"-n ''", false},
+		{"-z ''", true},
+		{"abc", true},
+		{"''", false},
+		{"!", true},
+		{"a = a", true},
+		{"a != a", false},
+		{"a '<' b", true},
+		{"10 -gt 9", true},
+		{"-3 -le -4", false},
+		{"! -d file", true},
+		{"-f file -a -d dir", true},
+		{`-f dir -o \( a = a -a ! b = b \)`, false},
+	}
+	for _, test := range tests {
+		out, _ := runLine(shell, "test "+test.expr+"; echo $?; [ "+test.expr+" ]; echo $?")
+		want := "1\n1\n"
+		if test.want {
+			want = "0\n0\n"
+		}
+		assertEqual(t, want, out, "Expected the result of test "+test.expr)
+	}
+
+	// Test malformed expressions
+	_, errOut := runLine(shell, "test 1 -eq x; [ a")
+	assertEqual(t, "test: x: integer expression expected\n[: missing `]'\n", errOut, "Expected malformed expressions to be reported")
+	out, _ := runLine(shell, "test a b; echo $?")
+	assertEqual(t, "2\n", out, "Expected a status of 2 for a malformed expression")
+}
@@ -0,0 +1,946 @@
+package imfs
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FS is an in-memory file tree. Its methods take absolute, slash-separated
+// paths that are resolved from Root; a leading slash is optional. Empty and
+// "." components are ignored and ".." moves to the parent directory (the
+// parent of the root is the root itself). The exception is the io/fs
+// methods (Open, Stat, ReadDir and ReadFile), which take unrooted names as
+// described by fs.ValidPath.
+//
+// An FS is a view of a tree on behalf of one user: every operation is
+// checked against the permissions of its Cred, and new files are created
+// with its umask. NewFS returns a view for the superuser; WithCred returns
+// another view of the same tree. The tree may be shared by several views
+// and Shells, each with its own working directory, and is safe for
+// concurrent use. It is guarded by one RWMutex: lookups hold it for reading
+// and mutations for writing, so a cross-directory Rename never has to
+// order locks on two directories.
+type FS struct {
+	*tree
+
+	cred  Cred
+	umask fs.FileMode
+}
+
+// tree is the state shared by every view of an FS.
+type tree struct {
+	Root *File
+
+	mu      sync.RWMutex
+	journal *journal
+	seq     uint64 // sequence number of the last recorded mutation
+	ino     uint64 // last inode number handed out
+	locks   lockTable
+}
+
+func NewFS() *FS {
+	root := newFile("/", true, time.Now())
+	root.Mode = 0o755
+	root.Ino = 1
+	t := &tree{Root: root, ino: root.Ino}
+	t.locks.cond.L = &t.locks.mu
+	// The tree starts out acting for root as the default database
+	// describes it, supplementary groups and all.
+	accounts := NewAccounts()
+	u, _ := accounts.LookupUID(0)
+	return &FS{tree: t, cred: accounts.Cred(u), umask: 0o022}
+}
+
+// nextIno returns an unused inode number. The caller must hold the FS lock
+// for writing.
+func (fsys *FS) nextIno() uint64 {
+	fsys.ino++
+	return fsys.ino
+}
+
+// split breaks name into its non-empty components.
+func split(name string) []string {
+	var components []string
+	for _, component := range strings.Split(name, "/") {
+		if component != "" && component != "." {
+			components = append(components, component)
+		}
+	}
+	return components
+}
+
+// validName reports whether name can be used for a directory entry.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
+
+// child returns the entry called name in dir, or nil.
+func (dir *File) child(name string) *File {
+	for _, child := range dir.Children {
+		if child.Name == name {
+			return child
+		}
+	}
+	return nil
+}
+
+// unlink detaches f from its parent directory.
+func (f *File) unlink(now time.Time) {
+	parent := f.Parent
+	for i, child := range parent.Children {
+		if child == f {
+			parent.Children = append(parent.Children[:i], parent.Children[i+1:]...)
+			break
+		}
+	}
+	parent.ModifiedAt = now
+}
+
+// link attaches f to dir.
+func (dir *File) link(f *File, now time.Time) {
+	f.Parent = dir
+	dir.Children = append(dir.Children, f)
+	dir.ModifiedAt = now
+}
+
+// pathOf returns the canonical absolute path of f, or false if f has been
+// removed from the tree.
+func (fsys *FS) pathOf(f *File) (string, bool) {
+	var components []string
+	for current := f; current != fsys.Root; current = current.Parent {
+		if current.Parent == nil || current.Parent.child(current.Name) != current {
+			return "", false
+		}
+		components = append(components, current.Name)
+	}
+	slices.Reverse(components)
+	return "/" + strings.Join(components, "/"), true
+}
+
+// path returns the absolute path of f found by following its parent
+// links. Unlike pathOf it also names nodes inside mounted archives.
+func (f *File) path() string {
+	if f.Parent == nil {
+		return "/"
+	}
+	path := f.Name
+	for current := f.Parent; current.Parent != nil; current = current.Parent {
+		path = current.Name + "/" + path
+	}
+	return "/" + path
+}
+
+// writable returns ErrReadOnly, reported against op, if f belongs to a
+// mounted archive.
+func (f *File) writable(op string) error {
+	if f.readOnly {
+		return &fs.PathError{Op: op, Path: f.path(), Err: ErrReadOnly}
+	}
+	return nil
+}
+
+// pathIn returns the canonical absolute path of the entry base in dir.
+func (fsys *FS) pathIn(dir *File, base string) string {
+	path, _ := fsys.pathOf(dir)
+	return strings.TrimSuffix(path, "/") + "/" + base
+}
+
+// maxSymlinks is the number of symbolic links a lookup may pass through
+// before failing with ErrLoop, as on Linux.
+const maxSymlinks = 40
+
+// walk follows components from dir and returns the node they name. Every
+// directory passed through must be searchable. Symbolic links met on the
+// way are followed, and so is one named by the final component if follow
+// is set.
+func (fsys *FS) walk(dir *File, components []string, follow bool) (*File, error) {
+	current := dir
+	hops := 0
+	for len(components) > 0 {
+		component := components[0]
+		components = components[1:]
+		if current.mount != nil {
+			current = current.mount
+		}
+		if !current.IsDirectory {
+			return nil, ErrNotDir
+		}
+		if !fsys.access(current, accessSearch) {
+			return nil, fs.ErrPermission
+		}
+		if component == ".." {
+			if current.Parent != nil {
+				current = current.Parent
+			}
+			continue
+		}
+		next := current.child(component)
+		if next == nil {
+			return nil, fs.ErrNotExist
+		}
+		if next.IsSymlink && (follow || len(components) > 0) {
+			if hops++; hops > maxSymlinks {
+				return nil, ErrLoop
+			}
+			// The target takes the place of the link, and is relative to
+			// the directory holding it unless it is absolute.
+			if strings.HasPrefix(next.Target, "/") {
+				current = fsys.Root
+			}
+			components = append(split(next.Target), components...)
+			continue
+		}
+		current = next
+	}
+	return current, nil
+}
+
+// Resolve returns the node named by the absolute path name. A trailing
+// slash requires the node to be a directory.
+func (fsys *FS) Resolve(name string) (*File, error) {
+	fsys.mu.RLock()
+	defer fsys.mu.RUnlock()
+	return fsys.lookup("stat", name)
+}
+
+// ResolveParent returns the directory that holds (or would hold) name
+// together with the final path component, which is never "." or "..".
+func (fsys *FS) ResolveParent(name string) (*File, string, error) {
+	fsys.mu.RLock()
+	defer fsys.mu.RUnlock()
+	return fsys.lookupParent("stat", name)
+}
+
+// lookup implements Resolve, reporting failures against op.
+func (fsys *FS) lookup(op, name string) (*File, error) {
+	return fsys.resolve(op, name, true)
+}
+
+// lookupLink is lookup for operations such as Remove and Rename that act
+// on a symbolic link itself rather than on what it points to.
+func (fsys *FS) lookupLink(op, name string) (*File, error) {
+	// A trailing slash resolves the link all the same.
+	return fsys.resolve(op, name, strings.HasSuffix(name, "/"))
+}
+
+func (fsys *FS) resolve(op, name string, follow bool) (*File, error) {
+	f, err := fsys.walk(fsys.Root, split(name), follow)
+	if err == nil && !f.IsDirectory && strings.HasSuffix(name, "/") {
+		// A trailing slash names the contents of a mounted archive.
+		if f.mount != nil {
+			f = f.mount
+		} else {
+			err = ErrNotDir
+		}
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return f, nil
+}
+
+// lookupParent implements ResolveParent, reporting failures against op.
+func (fsys *FS) lookupParent(op, name string) (*File, string, error) {
+	components := split(name)
+	if len(components) == 0 {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	base := components[len(components)-1]
+	if base == ".." {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	dir, err := fsys.walk(fsys.Root, components[:len(components)-1], true)
+	if err == nil && dir.mount != nil {
+		dir = dir.mount
+	}
+	if err == nil && !dir.IsDirectory {
+		err = ErrNotDir
+	}
+	if err != nil {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return dir, base, nil
+}
+
+func newFile(name string, isDirectory bool, now time.Time) *File {
+	f := &File{
+		Name:        name,
+		IsDirectory: isDirectory,
+		Inode:       &Inode{CreatedAt: now, ModifiedAt: now},
+	}
+	f.links = []*File{f}
+	return f
+}
+
+// Nlink returns the number of links to f. As on Unix, a directory is linked
+// from its parent, from its own "." entry and from the ".." entry of each
+// subdirectory.
+func (f *File) Nlink() int {
+	if !f.IsDirectory {
+		return len(f.links)
+	}
+	n := 2
+	for _, child := range f.Children {
+		if child.IsDirectory {
+			n++
+		}
+	}
+	return n
+}
+
+// hardlink returns a new entry called name for the inode of f.
+func (f *File) hardlink(name string) *File {
+	link := &File{Name: name, IsSymlink: f.IsSymlink, Inode: f.Inode}
+	f.links = append(f.links, link)
+	return link
+}
+
+// release drops the links that f and everything below it hold on their
+// inodes, once f has been removed from the tree.
+func (f *File) release() {
+	for _, child := range f.Children {
+		child.release()
+	}
+	f.links = slices.DeleteFunc(f.links, func(link *File) bool { return link == f })
+	f.free()
+}
+
+// free discards the contents of an inode that nothing refers to any more.
+// The caller must hold the FS lock for writing.
+func (n *Inode) free() {
+	if len(n.links) == 0 && n.opens.Load() == 0 {
+		n.Content = nil
+	}
+}
+
+// inodePath returns the canonical path of one of the entries for the inode
+// of f, or false if none of them is in the tree.
+func (fsys *FS) inodePath(f *File) (string, bool) {
+	if path, ok := fsys.pathOf(f); ok {
+		return path, true
+	}
+	for _, link := range f.links {
+		if path, ok := fsys.pathOf(link); ok {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// Mkdir creates a new directory. The parent must already exist.
+func (fsys *FS) Mkdir(name string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	dir, base, err := fsys.lookupParent("mkdir", name)
+	if err != nil {
+		return err
+	}
+	if dir.child(base) != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	return fsys.create(dir, base, fs.ModeDir|0o777)
+}
+
+// create records and makes a new, empty entry called base in dir, owned
+// by the user of fsys. The entry is a directory if mode has fs.ModeDir
+// set, and its permissions are those of mode less the umask.
+func (fsys *FS) create(dir *File, base string, mode fs.FileMode) error {
+	rec := record{
+		op:   opCreate,
+		time: time.Now(),
+		path: fsys.pathIn(dir, base),
+		mode: mode & modeBits &^ fsys.umask,
+		uid:  fsys.cred.UID,
+		gid:  fsys.cred.GID,
+	}
+	op := "open"
+	if mode.IsDir() {
+		rec.op, op = opMkdir, "mkdir"
+	}
+	return fsys.insert(op, dir, base, rec)
+}
+
+// Symlink creates newname as a symbolic link to oldname. The target is
+// stored as given and need not exist; a relative target is resolved from
+// the directory holding the link.
+func (fsys *FS) Symlink(oldname, newname string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	if oldname == "" {
+		return &fs.PathError{Op: "symlink", Path: newname, Err: fs.ErrInvalid}
+	}
+	dir, base, err := fsys.lookupParent("symlink", newname)
+	if err != nil {
+		return err
+	}
+	if dir.child(base) != nil {
+		return &fs.PathError{Op: "symlink", Path: newname, Err: fs.ErrExist}
+	}
+	if strings.HasSuffix(newname, "/") {
+		return &fs.PathError{Op: "symlink", Path: newname, Err: fs.ErrNotExist}
+	}
+	return fsys.symlink(dir, base, oldname)
+}
+
+// symlink records and makes a symbolic link to target called base in dir.
+func (fsys *FS) symlink(dir *File, base, target string) error {
+	return fsys.insert("symlink", dir, base, record{
+		op:   opSymlink,
+		time: time.Now(),
+		path: fsys.pathIn(dir, base),
+		dest: target,
+		mode: fs.ModePerm,
+		uid:  fsys.cred.UID,
+		gid:  fsys.cred.GID,
+	})
+}
+
+// readLink returns the target of the symbolic link at the absolute path
+// name.
+func (fsys *FS) readLink(op, name string) (string, error) {
+	fsys.mu.RLock()
+	defer fsys.mu.RUnlock()
+
+	f, err := fsys.lookupLink(op, name)
+	if err != nil {
+		return "", err
+	}
+	if !f.IsSymlink {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return f.Target, nil
+}
+
+// insert checks that the user of fsys may add base to dir, then records
+// rec and links the node it creates.
+func (fsys *FS) insert(op string, dir *File, base string, rec record) error {
+	if err := dir.writable(op); err != nil {
+		return err
+	}
+	if err := fsys.permit(op, dir, accessWrite|accessSearch); err != nil {
+		return err
+	}
+	rec.ino = fsys.ino + 1
+	if err := fsys.log(rec); err != nil {
+		return err
+	}
+	fsys.ino = rec.ino
+	dir.link(rec.newFile(base), rec.time)
+	return nil
+}
+
+// Link creates newname as a hard link to oldname: a second entry for the
+// same contents and metadata. Directories cannot be linked, and a symbolic
+// link is linked itself rather than followed.
+func (fsys *FS) Link(oldname, newname string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	f, err := fsys.lookupLink("link", oldname)
+	if err != nil {
+		return err
+	}
+	if f.IsDirectory {
+		return &fs.PathError{Op: "link", Path: oldname, Err: ErrIsDir}
+	}
+	dir, base, err := fsys.lookupParent("link", newname)
+	if err != nil {
+		return err
+	}
+	if dir.child(base) != nil {
+		return &fs.PathError{Op: "link", Path: newname, Err: fs.ErrExist}
+	}
+	if strings.HasSuffix(newname, "/") {
+		return &fs.PathError{Op: "link", Path: newname, Err: ErrNotDir}
+	}
+	return fsys.linkAt("link", f, dir, base)
+}
+
+// linkAt makes base in dir a hard link to f, reporting failures against
+// op. The caller must hold the FS lock and have checked that base is free.
+func (fsys *FS) linkAt(op string, f, dir *File, base string) error {
+	// Mounted archives are separate file systems.
+	if err := f.writable(op); err != nil {
+		return err
+	}
+	if err := dir.writable(op); err != nil {
+		return err
+	}
+	if err := fsys.permit(op, dir, accessWrite|accessSearch); err != nil {
+		return err
+	}
+	path, _ := fsys.pathOf(f)
+	rec := record{op: opLink, time: time.Now(), path: path, dest: fsys.pathIn(dir, base)}
+	if err := fsys.log(rec); err != nil {
+		return err
+	}
+	dir.link(f.hardlink(base), rec.time)
+	return nil
+}
+
+// MkdirAll creates a directory along with any missing parents. It is not
+// an error for the directory to exist already.
+func (fsys *FS) MkdirAll(name string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	_, err := fsys.mkdirAll("mkdir", name)
+	return err
+}
+
+// mkdirAll implements MkdirAll, reporting failures against op, and
+// returns the directory name refers to.
+func (fsys *FS) mkdirAll(op, name string) (*File, error) {
+	current := fsys.Root
+	for _, component := range split(name) {
+		if !fsys.access(current, accessSearch) {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+		}
+		if component == ".." {
+			if current.Parent != nil {
+				current = current.Parent
+			}
+			continue
+		}
+		if current.child(component) == nil {
+			if err := fsys.create(current, component, fs.ModeDir|0o777); err != nil {
+				return nil, err
+			}
+		}
+		next, err := fsys.walk(current, []string{component}, true)
+		if err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		if next.mount != nil {
+			next = next.mount
+		}
+		if !next.IsDirectory {
+			return nil, &fs.PathError{Op: op, Path: name, Err: ErrNotDir}
+		}
+		current = next
+	}
+	return current, nil
+}
+
+// touch creates an empty file, or updates the modification time of an
+// existing one, in a single step.
+func (fsys *FS) touch(name string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	if f, err := fsys.lookup("open", name); err == nil {
+		// Setting the time to now only needs write permission.
+		if !fsys.owns(f) {
+			if err := fsys.permit("open", f, accessWrite); err != nil {
+				return err
+			}
+		}
+		return fsys.chtimes(f, time.Now())
+	}
+	_, err := fsys.openFile("open", name, true, 0o666)
+	return err
+}
+
+// openFile returns the regular file called name for writing, creating it
+// with permissions perm (less the umask) if create is set and it does not
+// exist yet. An existing file must be writable.
+func (fsys *FS) openFile(op, name string, create bool, perm fs.FileMode) (*File, error) {
+	dir, base, err := fsys.lookupParent(op, name)
+	if err != nil {
+		return nil, err
+	}
+	f := dir.child(base)
+	// A symbolic link is followed to the file it names, which is created
+	// if the link dangles.
+	for hops := 0; f != nil && f.IsSymlink; hops++ {
+		if hops == maxSymlinks {
+			return nil, &fs.PathError{Op: op, Path: name, Err: ErrLoop}
+		}
+		target := f.Target
+		if !strings.HasPrefix(target, "/") {
+			target = strings.TrimSuffix(dir.path(), "/") + "/" + target
+		}
+		if dir, base, err = fsys.lookupParent(op, target); err != nil {
+			return nil, pathError(op, name, err)
+		}
+		f = dir.child(base)
+	}
+	if strings.HasSuffix(name, "/") {
+		// A trailing slash can only name a directory.
+		if f != nil && !f.IsDirectory {
+			return nil, &fs.PathError{Op: op, Path: name, Err: ErrNotDir}
+		}
+		return nil, &fs.PathError{Op: op, Path: name, Err: ErrIsDir}
+	}
+	if f == nil {
+		if !create {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		if err := fsys.create(dir, base, perm); err != nil {
+			return nil, err
+		}
+		return dir.child(base), nil
+	}
+	if f.IsDirectory {
+		return nil, &fs.PathError{Op: op, Path: name, Err: ErrIsDir}
+	}
+	if !fsys.access(f, accessWrite) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+	}
+	return f, nil
+}
+
+func (f *File) setContent(content []byte, now time.Time) {
+	f.Content = content
+	f.Size = int64(len(content))
+	f.ModifiedAt = now
+}
+
+// WriteFile replaces the contents of the named file, creating it if
+// necessary.
+func (fsys *FS) WriteFile(name string, data []byte) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	f, err := fsys.openFile("open", name, true, 0o666)
+	if err != nil {
+		return err
+	}
+	return fsys.writeFile(f, data)
+}
+
+// writeFile records and performs the replacement of the contents of f.
+func (fsys *FS) writeFile(f *File, data []byte) error {
+	if err := f.writable("write"); err != nil {
+		return err
+	}
+	path, _ := fsys.pathOf(f)
+	rec := record{op: opWriteFile, time: time.Now(), path: path, data: data}
+	if err := fsys.log(rec); err != nil {
+		return err
+	}
+	f.setContent(bytes.Clone(data), rec.time)
+	return nil
+}
+
+// AppendFile appends data to the named file, creating it if necessary.
+func (fsys *FS) AppendFile(name string, data []byte) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	f, err := fsys.openFile("open", name, true, 0o666)
+	if err != nil {
+		return err
+	}
+	return fsys.writeAt(f, data, int64(len(f.Content)))
+}
+
+// writeAt records and performs a write of p to f at off, through any of
+// its links. Writes to a file with no links left in the tree are not
+// recorded, since nothing can reach it after a restart.
+func (fsys *FS) writeAt(f *File, p []byte, off int64) error {
+	if err := f.writable("write"); err != nil {
+		return err
+	}
+	now := time.Now()
+	if path, ok := fsys.inodePath(f); ok {
+		if err := fsys.log(record{op: opWrite, time: now, path: path, off: off, data: p}); err != nil {
+			return err
+		}
+	}
+	f.writeAt(p, off, now)
+	return nil
+}
+
+// truncate records and performs a resize of f to size bytes.
+func (fsys *FS) truncate(f *File, size int64) error {
+	if err := f.writable("truncate"); err != nil {
+		return err
+	}
+	now := time.Now()
+	if path, ok := fsys.inodePath(f); ok {
+		if err := fsys.log(record{op: opTruncate, time: now, path: path, off: size}); err != nil {
+			return err
+		}
+	}
+	f.truncate(size, now)
+	return nil
+}
+
+// readFile returns a copy of the contents of the file at the absolute path
+// name.
+func (fsys *FS) readFile(op, name string) ([]byte, error) {
+	fsys.mu.RLock()
+	defer fsys.mu.RUnlock()
+
+	f, err := fsys.lookup(op, name)
+	if err != nil {
+		return nil, err
+	}
+	if f.IsDirectory {
+		return nil, &fs.PathError{Op: op, Path: name, Err: ErrIsDir}
+	}
+	if !fsys.access(f, accessRead) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+	}
+	return bytes.Clone(f.Content), nil
+}
+
+// Chtimes changes the modification time of the named file. Only the owner
+// and the superuser may set it.
+func (fsys *FS) Chtimes(name string, mtime time.Time) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	f, err := fsys.lookup("chtimes", name)
+	if err != nil {
+		return err
+	}
+	if !fsys.owns(f) {
+		return &fs.PathError{Op: "chtimes", Path: name, Err: fs.ErrPermission}
+	}
+	return fsys.chtimes(f, mtime)
+}
+
+func (fsys *FS) chtimes(f *File, mtime time.Time) error {
+	if err := f.writable("chtimes"); err != nil {
+		return err
+	}
+	path, _ := fsys.pathOf(f)
+	if err := fsys.log(record{op: opChtimes, time: mtime, path: path}); err != nil {
+		return err
+	}
+	f.ModifiedAt = mtime
+	return nil
+}
+
+// Remove removes a file or an empty directory.
+func (fsys *FS) Remove(name string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	f, err := fsys.lookupLink("remove", name)
+	if err != nil {
+		return err
+	}
+	if f == fsys.Root {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	if f.IsDirectory && len(f.Children) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: ErrNotEmpty}
+	}
+	if err := fsys.permitUnlink("remove", f); err != nil {
+		return err
+	}
+	return fsys.remove(f)
+}
+
+// RemoveAll removes name and everything it contains. It returns nil if
+// name does not exist.
+func (fsys *FS) RemoveAll(name string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	f, err := fsys.lookupLink("remove", name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if f == fsys.Root {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	if err := fsys.permitRemoveAll("remove", f); err != nil {
+		return err
+	}
+	return fsys.remove(f)
+}
+
+func (fsys *FS) remove(f *File) error {
+	if err := f.writable("remove"); err != nil {
+		return err
+	}
+	path, _ := fsys.pathOf(f)
+	rec := record{op: opRemove, time: time.Now(), path: path}
+	if err := fsys.log(rec); err != nil {
+		return err
+	}
+	f.unlink(rec.time)
+	f.release()
+	return nil
+}
+
+// Rename moves oldname to newname. Unlike os.Rename it never replaces an
+// existing entry, and a directory cannot be moved inside itself.
+func (fsys *FS) Rename(oldname, newname string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	f, err := fsys.lookupLink("rename", oldname)
+	if err != nil {
+		return err
+	}
+	dir, base, err := fsys.lookupParent("rename", newname)
+	if err != nil {
+		return err
+	}
+	if !f.IsDirectory && strings.HasSuffix(newname, "/") {
+		return &fs.PathError{Op: "rename", Path: newname, Err: ErrNotDir}
+	}
+	if existing := dir.child(base); existing != nil {
+		if existing == f {
+			return nil
+		}
+		return &fs.PathError{Op: "rename", Path: newname, Err: fs.ErrExist}
+	}
+	for p := dir; p != nil; p = p.Parent {
+		if p == f {
+			return &fs.PathError{Op: "rename", Path: newname, Err: fs.ErrInvalid}
+		}
+	}
+	if err := f.writable("rename"); err != nil {
+		return err
+	}
+	if err := dir.writable("rename"); err != nil {
+		return err
+	}
+	if err := fsys.permitUnlink("rename", f); err != nil {
+		return err
+	}
+	if err := fsys.permit("rename", dir, accessWrite|accessSearch); err != nil {
+		return err
+	}
+	if f.IsDirectory && dir != f.Parent {
+		// Moving a directory rewrites its ".." entry.
+		if err := fsys.permit("rename", f, accessWrite); err != nil {
+			return err
+		}
+	}
+	path, _ := fsys.pathOf(f)
+	rec := record{op: opRename, time: time.Now(), path: path, dest: fsys.pathIn(dir, base)}
+	if err := fsys.log(rec); err != nil {
+		return err
+	}
+	f.unlink(rec.time)
+	f.Name = base
+	dir.link(f, rec.time)
+	if f.mount != nil {
+		f.mount.Name, f.mount.Parent = base, dir
+	}
+	return nil
+}
+
+// Copy creates newname as a deep copy of oldname. As with cp(1), extended
+// attributes are not copied.
+func (fsys *FS) Copy(oldname, newname string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	f, err := fsys.lookup("copy", oldname)
+	if err != nil {
+		return err
+	}
+	dir, base, err := fsys.lookupParent("copy", newname)
+	if err != nil {
+		return err
+	}
+	if !f.IsDirectory && strings.HasSuffix(newname, "/") {
+		return &fs.PathError{Op: "copy", Path: newname, Err: ErrNotDir}
+	}
+	if dir.child(base) != nil {
+		return &fs.PathError{Op: "copy", Path: newname, Err: fs.ErrExist}
+	}
+	if err := dir.writable("copy"); err != nil {
+		return err
+	}
+	if err := fsys.permitRead("copy", f); err != nil {
+		return err
+	}
+	if err := fsys.permit("copy", dir, accessWrite|accessSearch); err != nil {
+		return err
+	}
+	rec := record{
+		op:   opCopy,
+		time: time.Now(),
+		dest: fsys.pathIn(dir, base),
+		mode: fsys.umask,
+		uid:  fsys.cred.UID,
+		gid:  fsys.cred.GID,
+		ino:  fsys.ino + 1,
+	}
+	now := rec.time
+	next := rec.ino
+	dup := f.clone(now, rec.uid, rec.gid, rec.mode, &next)
+	dup.Name = base
+	var ok bool
+	if rec.path, ok = fsys.pathOf(f); ok {
+		err = fsys.log(rec)
+	} else {
+		// Copies out of a mounted archive cannot be replayed from the
+		// source, so they are recorded as the files they create.
+		err = fsys.logTree(dup, fsys.pathIn(dir, base), now)
+	}
+	if err != nil {
+		return err
+	}
+	fsys.ino = next - 1
+	dir.link(dup, now)
+	return nil
+}
+
+// logTree records the creation of f and everything below it at path.
+func (fsys *FS) logTree(f *File, path string, now time.Time) error {
+	rec := record{op: opCreate, time: now, path: path, mode: f.Mode, uid: f.UID, gid: f.GID, ino: f.Ino}
+	switch {
+	case f.IsDirectory:
+		rec.op = opMkdir
+	case f.IsSymlink:
+		rec.op, rec.dest = opSymlink, f.Target
+	}
+	if err := fsys.log(rec); err != nil {
+		return err
+	}
+	if len(f.Content) > 0 {
+		if err := fsys.log(record{op: opWriteFile, time: now, path: path, data: f.Content}); err != nil {
+			return err
+		}
+	}
+	for _, child := range f.Children {
+		if err := fsys.logTree(child, path+"/"+child.Name, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clone returns a deep copy of f and everything below it, created at now
+// and owned by uid and gid, with umask cleared from every mode. The copies
+// get new inodes, numbered in order from *ino, which is left one past the
+// last. Hard links within f are copied as separate files.
+func (f *File) clone(now time.Time, uid, gid int, umask fs.FileMode, ino *uint64) *File {
+	dup := newFile(f.Name, f.IsDirectory, now)
+	dup.Ino = *ino
+	*ino++
+	dup.Mode = f.Mode &^ umask
+	dup.UID, dup.GID = uid, gid
+	dup.Size = f.Size
+	dup.Content = bytes.Clone(f.Content)
+	if f.IsSymlink {
+		dup.IsSymlink, dup.Target = true, f.Target
+		dup.Mode = f.Mode
+	}
+	for _, child := range f.Children {
+		c := child.clone(now, uid, gid, umask, ino)
+		c.Parent = dup
+		dup.Children = append(dup.Children, c)
+	}
+	return dup
+}
@@ -0,0 +1,157 @@
+package imfs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+	"testing"
+)
+
+func TestFSOperations(t *testing.T) {
+	fsys := NewFS()
+
+	// Test creating directories and files by absolute path
+	assertEqual(t, nil, fsys.MkdirAll("/a/b/c"), "Expected MkdirAll to succeed")
+	assertEqual(t, nil, fsys.MkdirAll("/a/b/c"), "Expected MkdirAll on existing directory to succeed")
+	assertEqual(t, nil, fsys.Mkdir("/a/d"), "Expected Mkdir to succeed")
+	assertEqual(t, nil, fsys.WriteFile("/a/b/file.txt", []byte("hello")), "Expected WriteFile to succeed")
+	assertEqual(t, nil, fsys.AppendFile("/a/b/file.txt", []byte(" world")), "Expected AppendFile to succeed")
+
+	content, err := fsys.ReadFile("a/b/file.txt")
+	assertEqual(t, nil, err, "Expected ReadFile to succeed")
+	assertEqual(t, "hello world", string(content), "Expected appended content")
+
+	// Test Stat
+	info, err := fsys.Stat("a/b/file.txt")
+	assertEqual(t, nil, err, "Expected Stat to succeed")
+	assertEqual(t, "file.txt", info.Name(), "Expected file name")
+	assertEqual(t, int64(11), info.Size(), "Expected file size")
+	assertEqual(t, false, info.IsDir(), "Expected a regular file")
+
+	// Test ReadDir returns entries sorted by name
+	f, err := fsys.Create("/a/b/0.txt")
+	assertEqual(t, nil, err, "Expected Create to succeed")
+	f.Close()
+	entries, err := fsys.ReadDir("a/b")
+	assertEqual(t, nil, err, "Expected ReadDir to succeed")
+	assertEqual(t, 3, len(entries), "Expected three entries")
+	assertEqual(t, "0.txt", entries[0].Name(), "Expected entries sorted by name")
+	assertEqual(t, "c", entries[1].Name(), "Expected entries sorted by name")
+	assertEqual(t, "file.txt", entries[2].Name(), "Expected entries sorted by name")
+
+	// Test Rename across directories
+	assertEqual(t, nil, fsys.Rename("/a/b/file.txt", "/a/d/moved.txt"), "Expected Rename to succeed")
+	_, err = fsys.Stat("a/b/file.txt")
+	assertEqual(t, true, errors.Is(err, fs.ErrNotExist), "Expected old name to be gone")
+	content, _ = fsys.ReadFile("a/d/moved.txt")
+	assertEqual(t, "hello world", string(content), "Expected content to follow the rename")
+
+	// Test a directory cannot be moved inside itself
+	err = fsys.Rename("/a", "/a/b/a")
+	assertEqual(t, true, errors.Is(err, fs.ErrInvalid), "Expected fs.ErrInvalid moving directory into itself")
+
+	// Test Copy is deep
+	assertEqual(t, nil, fsys.Copy("/a/d", "/copy"), "Expected Copy to succeed")
+	assertEqual(t, nil, fsys.WriteFile("/copy/moved.txt", []byte("changed")), "Expected write to copy to succeed")
+	content, _ = fsys.ReadFile("a/d/moved.txt")
+	assertEqual(t, "hello world", string(content), "Expected original to be unaffected by changes to the copy")
+
+	// Test Remove and RemoveAll
+	err = fsys.Remove("/a")
+	assertEqual(t, true, errors.Is(err, ErrNotEmpty), "Expected ErrNotEmpty removing non-empty directory")
+	assertEqual(t, nil, fsys.RemoveAll("/a"), "Expected RemoveAll to succeed")
+	assertEqual(t, nil, fsys.RemoveAll("/a"), "Expected RemoveAll of missing path to succeed")
+	err = fsys.Remove("/")
+	assertEqual(t, true, errors.Is(err, fs.ErrInvalid), "Expected fs.ErrInvalid removing root")
+}
+
+func TestSharedFS(t *testing.T) {
+	fsys := NewFS()
+	first := NewShellFS(fsys)
+	second := NewShellFS(fsys)
+
+	// Test shells share the tree but not the working directory
+	first.Mkdir("/shared/dir", true)
+	first.Cd("/shared")
+	assertEqual(t, "/shared", first.Pwd(), "Expected first shell to move")
+	assertEqual(t, "/", second.Pwd(), "Expected second shell to stay in root")
+
+	second.RedirectWrite("shared/note.txt", "from second", false)
+	content, err := first.Cat("note.txt")
+	assertEqual(t, nil, err, "Expected first shell to see file written by second")
+	assertEqual(t, "from second", content, "Expected content written by second shell")
+
+	// Test absolute paths do not change the working directory
+	first.Mkdir("/elsewhere", false)
+	first.Touch("/elsewhere/file.txt")
+	assertEqual(t, "/shared", first.Pwd(), "Expected working directory to be unchanged by absolute paths")
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	fsys := NewFS()
+	fsys.MkdirAll("/shared/a")
+	fsys.MkdirAll("/shared/b")
+
+	const workers = 8
+	const iterations = 200
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			shell := NewShellFS(fsys)
+			own := fmt.Sprintf("/worker%d", w)
+			fsys.Mkdir(own)
+			for i := 0; i < iterations; i++ {
+				name := fmt.Sprintf("/shared/a/file%d", i%10)
+				// Racing writers and renamers all target the same names, so
+				// most of these calls are expected to fail.
+				fsys.WriteFile(name, []byte("data"))
+				fsys.AppendFile(name, []byte("more"))
+				fsys.Rename(name, fmt.Sprintf("/shared/b/file%d", i%10))
+				fsys.Rename(fmt.Sprintf("/shared/b/file%d", i%10), name)
+				fsys.Copy(name, fmt.Sprintf("%s/copy%d", own, i))
+				fsys.AppendFile(own+"/log", []byte("."))
+				fsys.Remove(fmt.Sprintf("/shared/b/file%d", i%10))
+				fsys.ReadFile(fmt.Sprintf("shared/a/file%d", i%10))
+				fs.WalkDir(fsys, "shared", func(string, fs.DirEntry, error) error { return nil })
+
+				shell.Cd("/shared/a")
+				shell.Ls()
+				shell.Touch("touched")
+				shell.Cat(fmt.Sprintf("file%d", i%10))
+				shell.Pwd()
+				shell.Cd("..")
+				shell.Find("file")
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// Test the tree is still consistent: parent links match and no
+	// directory holds two entries with the same name.
+	var check func(dir *File)
+	check = func(dir *File) {
+		seen := make(map[string]bool)
+		for _, child := range dir.Children {
+			if child.Parent != dir {
+				t.Errorf("%s: parent link does not match containing directory", child.Name)
+			}
+			if seen[child.Name] {
+				t.Errorf("%s: duplicate entry in %s", child.Name, dir.Name)
+			}
+			seen[child.Name] = true
+			check(child)
+		}
+	}
+	check(fsys.Root)
+
+	// Test no append to an uncontended file was lost
+	for w := 0; w < workers; w++ {
+		content, err := fsys.ReadFile(fmt.Sprintf("worker%d/log", w))
+		assertEqual(t, nil, err, "Expected worker log to exist")
+		assertEqual(t, iterations, len(content), "Expected every append to be recorded")
+	}
+}
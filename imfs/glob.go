@@ -0,0 +1,216 @@
+package imfs
+
+import (
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Glob returns the names of all files matching pattern, in lexical order,
+// as fs.Glob does: each component of the pattern is matched against the
+// names in one directory as by path.Match, and names are unrooted.
+// Directories that cannot be read are skipped, and the only error is
+// path.ErrBadPattern.
+func (fsys *FS) Glob(pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	if pattern == "." {
+		return []string{"."}, nil
+	}
+	if !fs.ValidPath(pattern) {
+		return nil, nil
+	}
+
+	fsys.mu.RLock()
+	defer fsys.mu.RUnlock()
+
+	return fsys.globFrom(fsys.Root, "", pattern, false), nil
+}
+
+// globFrom returns the matches for pattern in dir, each joined to prefix.
+// A final empty component, left by a trailing slash, matches directories
+// only, which keep the slash. If globstar is set, a component of just "**"
+// matches any number of directories, as Shell.Glob describes; otherwise it
+// is the same as "*". The caller must hold the FS lock.
+func (fsys *FS) globFrom(dir *File, prefix, pattern string, globstar bool) []string {
+	var components []string
+	parts := strings.Split(pattern, "/")
+	for i, part := range parts {
+		if part != "" || i == len(parts)-1 && i > 0 {
+			components = append(components, part)
+		}
+	}
+
+	var matches []string
+	fsys.glob(dir, prefix, components, globstar, &matches)
+	slices.Sort(matches)
+	return slices.Compact(matches)
+}
+
+// glob appends to matches the paths below dir, each joined to prefix,
+// that match components.
+func (fsys *FS) glob(dir *File, prefix string, components []string, globstar bool, matches *[]string) {
+	if len(components) == 0 {
+		*matches = append(*matches, prefix)
+		return
+	}
+	pattern, rest := components[0], components[1:]
+	if dir.mount != nil {
+		dir = dir.mount
+	}
+
+	switch {
+	case pattern == "":
+		if dir.IsDirectory {
+			*matches = append(*matches, strings.TrimSuffix(prefix, "/")+"/")
+		}
+	case pattern == ".":
+		// As in the resolver, "." is dir itself, but it stays in the path.
+		if dir.IsDirectory {
+			fsys.glob(dir, joinGlob(prefix, pattern), rest, globstar, matches)
+		}
+	case pattern == "**" && globstar:
+		if len(rest) == 0 {
+			rest = []string{"*"}
+		}
+		fsys.glob(dir, prefix, rest, globstar, matches)
+		if !dir.IsDirectory || !fsys.access(dir, accessRead|accessSearch) {
+			return
+		}
+		for _, child := range dir.Children {
+			if child.IsDirectory {
+				fsys.glob(child, joinGlob(prefix, child.Name), components, globstar, matches)
+			}
+		}
+	case !hasMeta(pattern):
+		// A literal name need not be listed, only looked up.
+		f, err := fsys.walk(dir, []string{pattern}, len(rest) > 0)
+		if err == nil {
+			fsys.glob(f, joinGlob(prefix, pattern), rest, globstar, matches)
+		}
+	default:
+		if !dir.IsDirectory || !fsys.access(dir, accessRead) {
+			return
+		}
+		for _, child := range dir.Children {
+			if ok, _ := path.Match(pattern, child.Name); !ok {
+				continue
+			}
+			name := joinGlob(prefix, child.Name)
+			if len(rest) == 0 {
+				*matches = append(*matches, name)
+			} else if f, err := fsys.walk(dir, []string{child.Name}, true); err == nil {
+				fsys.glob(f, name, rest, globstar, matches)
+			}
+		}
+	}
+}
+
+// joinGlob joins name to the path prefix, which may be empty.
+func joinGlob(prefix, name string) string {
+	switch {
+	case prefix == "":
+		return name
+	case strings.HasSuffix(prefix, "/"):
+		return prefix + name
+	}
+	return prefix + "/" + name
+}
+
+// hasMeta reports whether pattern contains any of the characters that
+// path.Match treats specially.
+func hasMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
+
+// Glob returns the paths matching pattern, which is relative to the
+// working directory unless it starts with a slash, as FS.Glob does. The
+// paths are relative or absolute as the pattern is, and "." components
+// are kept in them. In addition, a component of just "**" matches any
+// number of directories, including none, so "docs/**/*.txt" finds text
+// files anywhere under docs; as the last component it matches everything
+// below. Symbolic links to directories are not followed by "**", so a
+// link cannot lead it round in circles.
+func (s *Shell) Glob(pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	s.FS.mu.RLock()
+	defer s.FS.mu.RUnlock()
+
+	if strings.HasPrefix(pattern, "/") {
+		return s.FS.globFrom(s.Root, "/", pattern, true), nil
+	}
+	return s.FS.globFrom(s.Cwd, "", pattern, true), nil
+}
+
+// expandBraces returns the words a brace expression in pattern stands
+// for: a{b,c}d becomes abd and acd, and nested braces are expanded in
+// turn. Braces without a comma between them are kept as they are, as are
+// those escaped with a backslash.
+func expandBraces(pattern string) []string {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '{':
+			alternatives, end := braceAlternatives(pattern, i)
+			if alternatives == nil {
+				continue
+			}
+			var words []string
+			for _, alt := range alternatives {
+				words = append(words, expandBraces(pattern[:i]+alt+pattern[end+1:])...)
+			}
+			return words
+		}
+	}
+	return []string{pattern}
+}
+
+// braceAlternatives splits the brace expression opening at pattern[start]
+// at its top-level commas, returning the alternatives and the index of the
+// closing brace, or nil if the braces do not close or hold no comma.
+func braceAlternatives(pattern string, start int) ([]string, int) {
+	var alternatives []string
+	depth := 0
+	from := start + 1
+	for i := from; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case ',':
+			if depth == 0 {
+				alternatives = append(alternatives, pattern[from:i])
+				from = i + 1
+			}
+		case '}':
+			if depth > 0 {
+				depth--
+				continue
+			}
+			if alternatives == nil {
+				return nil, 0
+			}
+			return append(alternatives, pattern[from:i]), i
+		}
+	}
+	return nil, 0
+}
+
+// unescape removes the backslashes that quote the characters of pattern.
+func unescape(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '\\' && i+1 < len(pattern) {
+			i++
+		}
+		b.WriteByte(pattern[i])
+	}
+	return b.String()
+}
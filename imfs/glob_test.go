@@ -0,0 +1,125 @@
+package imfs
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestGlob(t *testing.T) {
+	fsys := NewFS()
+	fsys.MkdirAll("/docs/a/b")
+	for _, name := range []string{"/docs/x.txt", "/docs/y.md", "/docs/a/z.txt", "/docs/a/b/w.txt", "/top.txt"} {
+		fsys.WriteFile(name, nil)
+	}
+	fsys.Symlink("/docs", "/docs/a/b/loop")
+
+	// Test the patterns of path.Match
+	matches, err := fsys.Glob("docs/?.*")
+	assertEqual(t, nil, err, "Expected Glob to succeed")
+	assertEqual(t, "docs/x.txt,docs/y.md", strings.Join(matches, ","), "Expected ? and * to match")
+	matches, _ = fsys.Glob("docs/[xz].txt")
+	assertEqual(t, "docs/x.txt", strings.Join(matches, ","), "Expected a character class to match")
+	matches, _ = fsys.Glob("*/a/*.txt")
+	assertEqual(t, "docs/a/z.txt", strings.Join(matches, ","), "Expected wildcards in every component")
+
+	// Test ** matches any number of directories in a shell, without
+	// following links
+	shell := NewShellFS(fsys)
+	matches, _ = shell.Glob("docs/**/*.txt")
+	assertEqual(t, "docs/a/b/w.txt,docs/a/z.txt,docs/x.txt", strings.Join(matches, ","), "Expected ** to match at every depth")
+	matches, _ = shell.Glob("docs/a/**")
+	assertEqual(t, "docs/a/b,docs/a/b/loop,docs/a/b/w.txt,docs/a/z.txt", strings.Join(matches, ","), "Expected a final ** to match everything below")
+
+	// Test Glob agrees with fs.Glob, where ** is the same as *
+	for _, pattern := range []string{"docs/*", "docs/**", "docs/**/*.txt"} {
+		want, _ := fs.Glob(fs.FS(struct{ fs.ReadDirFS }{fsys}), pattern)
+		matches, _ = fsys.Glob(pattern)
+		assertEqual(t, strings.Join(want, ","), strings.Join(matches, ","), "Expected the matches of fs.Glob for "+pattern)
+	}
+	matches, _ = fsys.Glob("docs/nothing*")
+	assertEqual(t, 0, len(matches), "Expected no matches")
+	_, err = fsys.Glob("docs/[")
+	assertEqual(t, path.ErrBadPattern, err, "Expected path.ErrBadPattern for a malformed pattern")
+
+	// Test unreadable directories are skipped
+	fsys.Chmod("/docs/a", 0o311)
+	alice := fsys.WithCred(Cred{UID: 1000, GID: 1000})
+	matches, _ = alice.Glob("docs/*/*.txt")
+	assertEqual(t, 0, len(matches), "Expected an unreadable directory to be skipped")
+	matches, _ = alice.Glob("docs/a/z.txt")
+	assertEqual(t, "docs/a/z.txt", strings.Join(matches, ","), "Expected a literal name to need only search permission")
+}
+
+func TestExpandBraces(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{`a{b,c}d`, `abd acd`},
+		{`{a,b}{1,2}`, `a1 a2 b1 b2`},
+		{`x{a,{b,c}}`, `xa xb xc`},
+		{`{a}`, `{a}`},
+		{`{,s}`, ` s`},
+		{`\{a,b}`, `\{a,b}`},
+		{`{a,b`, `{a,b`},
+	}
+	for _, test := range tests {
+		assertEqual(t, test.want, strings.Join(expandBraces(test.pattern), " "), "Expected the expansion of "+test.pattern)
+	}
+}
+
+func TestShellGlob(t *testing.T) {
+	shell := NewShell()
+	shell.Mkdir("src", false)
+	shell.Touch("src/a.go")
+	shell.Touch("src/b.go")
+	shell.Touch("src/notes.md")
+	shell.Touch("*")
+	shell.Cd("src")
+
+	// Test arguments are expanded relative to the working directory
+	out, _ := runLine(shell, "echo *.go")
+	assertEqual(t, "a.go b.go\n", out, "Expected * to expand to the matching names")
+	out, _ = runLine(shell, "echo ./*.go ./*.md")
+	assertEqual(t, "./a.go ./b.go ./notes.md\n", out, "Expected . components to match and be kept")
+	out, _ = runLine(shell, "echo ../src/[a-b].go /src/*.md")
+	assertEqual(t, "../src/a.go ../src/b.go /src/notes.md\n", out, "Expected relative and absolute patterns")
+	out, _ = runLine(shell, "echo {a,notes}.{go,md}")
+	assertEqual(t, "a.go a.md notes.go notes.md\n", out, "Expected braces to expand whether or not the files exist")
+	out, _ = runLine(shell, "echo ../**/*.go")
+	assertEqual(t, "../src/a.go ../src/b.go\n", out, "Expected ** to search below")
+	out, _ = runLine(shell, "echo ../*/")
+	assertEqual(t, "../src/\n", out, "Expected a trailing slash to match directories")
+
+	// Test quoted and unmatched patterns are kept
+	out, _ = runLine(shell, `echo '*.go' \*.go *.c "{a,b}"`)
+	assertEqual(t, "*.go *.go *.c {a,b}\n", out, "Expected quoted and unmatched patterns to be words")
+
+	// Test ls, cp and mv take every match
+	shell.Mkdir("dir", false)
+	out, _ = runLine(shell, "ls *.go")
+	assertEqual(t, "a.go\nb.go\n", out, "Expected ls to list the matching files")
+	out, _ = runLine(shell, "ls dir")
+	assertEqual(t, "", out, "Expected ls to list the empty directory")
+	_, errOut := runLine(shell, "cp *.go dir")
+	assertEqual(t, "", errOut, "Expected cp to copy several files into a directory")
+	out, _ = runLine(shell, "ls *.go dir")
+	assertEqual(t, "a.go\nb.go\n\ndir:\na.go\nb.go\n", out, "Expected ls to list files, then directories under their names")
+	runLine(shell, "mv dir/*.go ..")
+	out, _ = runLine(shell, "ls ..")
+	assertEqual(t, "*\na.go\nb.go\nsrc/\n", out, "Expected mv to move several files into a directory")
+	out, _ = runLine(shell, "ls dir")
+	assertEqual(t, "", out, "Expected mv to empty the directory")
+	_, errOut = runLine(shell, "cp *.go notes.md; ls nothing")
+	assertEqual(t, "cp: notes.md: not a directory\nls: nothing: file does not exist\n", errOut, "Expected several sources to need a directory")
+
+	// Test commands act on every match
+	runLine(shell, "rm *.go")
+	out, _ = runLine(shell, "echo *")
+	assertEqual(t, "dir notes.md\n", out, "Expected rm to remove every match")
+	runLine(shell, `rm '/*'`)
+	_, err := shell.Resolve("/src")
+	assertEqual(t, nil, err, "Expected a quoted * to name only the file called *")
+}
@@ -0,0 +1,379 @@
+package imfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	_ fs.ReadDirFile     = (*Handle)(nil)
+	_ io.ReadWriteSeeker = (*Handle)(nil)
+	_ io.ReaderAt        = (*Handle)(nil)
+	_ io.WriterAt        = (*Handle)(nil)
+	_ io.StringWriter    = (*Handle)(nil)
+)
+
+// ErrBadHandle is returned when a handle is used in a way its open flags do
+// not allow, such as writing to a handle opened with O_RDONLY.
+var ErrBadHandle = errors.New("bad file handle")
+
+// ErrTooLarge is returned when a write or truncation would make a file
+// larger than maxFileSize.
+var ErrTooLarge = errors.New("file too large")
+
+// maxFileSize is the largest a file may grow through a handle. Contents
+// are held in memory, so this is well short of what an offset can name.
+const maxFileSize = 1 << 32
+
+// sizeCheck returns ErrTooLarge, reported against op, if writing n bytes
+// at off would take the file past maxFileSize.
+func (h *Handle) sizeCheck(op string, off int64, n int) error {
+	if off > maxFileSize-int64(n) {
+		return &fs.PathError{Op: op, Path: h.name, Err: ErrTooLarge}
+	}
+	return nil
+}
+
+// Handle is an open file, the in-memory counterpart of *os.File. Reads and
+// writes go straight to the underlying File, so every handle (and every
+// other reader of the tree) sees them immediately. A Handle is safe for
+// concurrent use.
+type Handle struct {
+	fsys *FS
+	f    *File
+	name string
+	flag int
+
+	mu      sync.Mutex
+	offset  int64
+	entries []fs.DirEntry
+	listed  bool
+	closed  bool
+
+	unlocked bool // set on Close, under the lock table mutex
+}
+
+// OpenFile opens the named file with the given flags, which are the os.O_*
+// constants. O_CREATE creates a missing file, O_EXCL makes it an error for
+// the file to exist, O_TRUNC empties it and O_APPEND sends every write to
+// the end. A file created by OpenFile gets the permissions in perm less
+// the umask; opening an existing file needs read or write permission on it
+// according to flag.
+func (fsys *FS) OpenFile(name string, flag int, perm fs.FileMode) (*Handle, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	f, err := fsys.lookup("open", name)
+	created := false
+	switch {
+	case err == nil && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case errors.Is(err, fs.ErrNotExist) && flag&os.O_CREATE != 0:
+		f, err = fsys.openFile("open", name, true, perm)
+		created = true
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	if f.IsDirectory && (writable || flag&os.O_TRUNC != 0) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: ErrIsDir}
+	}
+	if writable && f.readOnly {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: ErrReadOnly}
+	}
+	var want fs.FileMode
+	if flag&os.O_WRONLY == 0 {
+		want |= accessRead
+	}
+	if writable {
+		want |= accessWrite
+	}
+	if !created && !fsys.access(f, want) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	if flag&os.O_TRUNC != 0 {
+		if !writable {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: ErrBadHandle}
+		}
+		if err := fsys.truncate(f, 0); err != nil {
+			return nil, err
+		}
+	}
+	f.opens.Add(1)
+	return &Handle{fsys: fsys, f: f, name: name, flag: flag}, nil
+}
+
+// Create creates or truncates the named file and opens it for reading and
+// writing.
+func (fsys *FS) Create(name string) (*Handle, error) {
+	return fsys.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o666)
+}
+
+// Name returns the name the handle was opened with.
+func (h *Handle) Name() string {
+	return h.name
+}
+
+// check reports whether op is allowed on h. The caller must hold h.mu.
+func (h *Handle) check(op string, write bool) error {
+	if h.closed {
+		return &fs.PathError{Op: op, Path: h.name, Err: fs.ErrClosed}
+	}
+	if h.f.IsDirectory {
+		return &fs.PathError{Op: op, Path: h.name, Err: ErrIsDir}
+	}
+	access := h.flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR)
+	if (write && access == os.O_RDONLY) || (!write && access == os.O_WRONLY) {
+		return &fs.PathError{Op: op, Path: h.name, Err: ErrBadHandle}
+	}
+	return nil
+}
+
+func (h *Handle) Read(p []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.check("read", false); err != nil {
+		return 0, err
+	}
+	n, err := h.readAt(p, h.offset)
+	h.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (h *Handle) ReadAt(p []byte, off int64) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.check("read", false); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: h.name, Err: fs.ErrInvalid}
+	}
+	return h.readAt(p, off)
+}
+
+func (h *Handle) readAt(p []byte, off int64) (int, error) {
+	h.fsys.mu.RLock()
+	defer h.fsys.mu.RUnlock()
+
+	if off >= int64(len(h.f.Content)) {
+		return 0, io.EOF
+	}
+	n := copy(p, h.f.Content[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (h *Handle) Write(p []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.check("write", true); err != nil {
+		return 0, err
+	}
+
+	h.fsys.mu.Lock()
+	defer h.fsys.mu.Unlock()
+
+	if h.flag&os.O_APPEND != 0 {
+		h.offset = int64(len(h.f.Content))
+	}
+	if err := h.sizeCheck("write", h.offset, len(p)); err != nil {
+		return 0, err
+	}
+	if err := h.fsys.writeAt(h.f, p, h.offset); err != nil {
+		return 0, err
+	}
+	h.offset += int64(len(p))
+	return len(p), nil
+}
+
+func (h *Handle) WriteString(s string) (int, error) {
+	return h.Write([]byte(s))
+}
+
+// WriteAt writes p at offset off. Like *os.File it refuses handles opened
+// with O_APPEND, where the offset would be meaningless.
+func (h *Handle) WriteAt(p []byte, off int64) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.check("write", true); err != nil {
+		return 0, err
+	}
+	if off < 0 || h.flag&os.O_APPEND != 0 {
+		return 0, &fs.PathError{Op: "write", Path: h.name, Err: fs.ErrInvalid}
+	}
+	if err := h.sizeCheck("write", off, len(p)); err != nil {
+		return 0, err
+	}
+
+	h.fsys.mu.Lock()
+	defer h.fsys.mu.Unlock()
+
+	if err := h.fsys.writeAt(h.f, p, off); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (h *Handle) Seek(offset int64, whence int) (int64, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return 0, &fs.PathError{Op: "seek", Path: h.name, Err: fs.ErrClosed}
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += h.offset
+	case io.SeekEnd:
+		h.fsys.mu.RLock()
+		offset += int64(len(h.f.Content))
+		h.fsys.mu.RUnlock()
+	case io.SeekStart:
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: h.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: h.name, Err: fs.ErrInvalid}
+	}
+	h.offset = offset
+	return offset, nil
+}
+
+// Truncate changes the size of the file. It does not move the offset.
+func (h *Handle) Truncate(size int64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.check("truncate", true); err != nil {
+		return err
+	}
+	if size < 0 {
+		return &fs.PathError{Op: "truncate", Path: h.name, Err: fs.ErrInvalid}
+	}
+	if err := h.sizeCheck("truncate", size, 0); err != nil {
+		return err
+	}
+
+	h.fsys.mu.Lock()
+	defer h.fsys.mu.Unlock()
+
+	return h.fsys.truncate(h.f, size)
+}
+
+// Sync is a no-op: writes are visible as soon as they are made.
+func (h *Handle) Sync() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return &fs.PathError{Op: "sync", Path: h.name, Err: fs.ErrClosed}
+	}
+	return nil
+}
+
+func (h *Handle) Stat() (fs.FileInfo, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, &fs.PathError{Op: "stat", Path: h.name, Err: fs.ErrClosed}
+	}
+
+	h.fsys.mu.RLock()
+	defer h.fsys.mu.RUnlock()
+	return h.f.info(), nil
+}
+
+// ReadDir returns the next n entries of an open directory, following the
+// fs.ReadDirFile contract. The listing is taken on the first call.
+func (h *Handle) ReadDir(n int) ([]fs.DirEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: h.name, Err: fs.ErrClosed}
+	}
+	if !h.f.IsDirectory {
+		return nil, &fs.PathError{Op: "readdir", Path: h.name, Err: ErrNotDir}
+	}
+	if !h.listed {
+		h.fsys.mu.RLock()
+		h.entries = h.f.entries()
+		h.fsys.mu.RUnlock()
+		h.listed = true
+	}
+
+	if n > 0 && len(h.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n <= 0 || n > len(h.entries) {
+		n = len(h.entries)
+	}
+	entries := h.entries[:n]
+	h.entries = h.entries[n:]
+	return entries, nil
+}
+
+// Close closes the handle, releasing any locks it holds.
+func (h *Handle) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return &fs.PathError{Op: "close", Path: h.name, Err: fs.ErrClosed}
+	}
+	h.closed = true
+	h.fsys.locks.releaseAll(h)
+	if h.f.opens.Add(-1) == 0 {
+		h.fsys.mu.Lock()
+		h.f.free()
+		h.fsys.mu.Unlock()
+	}
+	return nil
+}
+
+// writeAt writes p into f at off, zero-filling any gap past the old end.
+// The caller must hold the FS lock for writing.
+func (f *File) writeAt(p []byte, off int64, now time.Time) {
+	if end := off + int64(len(p)); end > int64(len(f.Content)) {
+		f.truncate(end, now)
+	}
+	copy(f.Content[off:], p)
+	f.ModifiedAt = now
+}
+
+// truncate resizes the content of f to size bytes. The caller must hold
+// the FS lock for writing.
+func (f *File) truncate(size int64, now time.Time) {
+	switch {
+	case size <= int64(len(f.Content)):
+		f.Content = f.Content[:size]
+	case size <= int64(cap(f.Content)):
+		old := len(f.Content)
+		f.Content = f.Content[:size]
+		clear(f.Content[old:])
+	default:
+		content := make([]byte, size, min(max(size, 2*int64(cap(f.Content))), maxFileSize))
+		copy(content, f.Content)
+		f.Content = content
+	}
+	f.Size = size
+	f.ModifiedAt = now
+}
@@ -0,0 +1,135 @@
+package imfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+func TestOpenFileFlags(t *testing.T) {
+	fsys := NewFS()
+
+	// Test opening a missing file without O_CREATE
+	_, err := fsys.OpenFile("/missing", os.O_RDONLY, 0)
+	assertEqual(t, true, errors.Is(err, fs.ErrNotExist), "Expected fs.ErrNotExist without O_CREATE")
+
+	// Test O_CREATE and O_EXCL
+	f, err := fsys.OpenFile("/file", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	assertEqual(t, nil, err, "Expected O_CREATE|O_EXCL to create the file")
+	f.WriteString("hello world")
+	f.Close()
+	_, err = fsys.OpenFile("/file", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	assertEqual(t, true, errors.Is(err, fs.ErrExist), "Expected fs.ErrExist with O_EXCL on existing file")
+
+	// Test access modes
+	f, _ = fsys.OpenFile("/file", os.O_RDONLY, 0)
+	_, err = f.Write([]byte("x"))
+	assertEqual(t, true, errors.Is(err, ErrBadHandle), "Expected ErrBadHandle writing to read-only handle")
+	f.Close()
+	f, _ = fsys.OpenFile("/file", os.O_WRONLY, 0)
+	_, err = f.Read(make([]byte, 1))
+	assertEqual(t, true, errors.Is(err, ErrBadHandle), "Expected ErrBadHandle reading from write-only handle")
+	f.Close()
+
+	// Test O_APPEND sends writes to the end regardless of the offset
+	f, _ = fsys.OpenFile("/file", os.O_WRONLY|os.O_APPEND, 0)
+	f.Seek(0, io.SeekStart)
+	f.WriteString("!")
+	_, err = f.WriteAt([]byte("x"), 0)
+	assertEqual(t, true, errors.Is(err, fs.ErrInvalid), "Expected WriteAt to be refused with O_APPEND")
+	f.Close()
+	content, _ := fsys.ReadFile("file")
+	assertEqual(t, "hello world!", string(content), "Expected append to go to the end")
+
+	// Test O_TRUNC
+	f, _ = fsys.OpenFile("/file", os.O_RDWR|os.O_TRUNC, 0)
+	info, _ := f.Stat()
+	assertEqual(t, int64(0), info.Size(), "Expected O_TRUNC to empty the file")
+	f.Close()
+
+	// Test directories cannot be opened for writing
+	fsys.Mkdir("/dir")
+	_, err = fsys.OpenFile("/dir", os.O_RDWR, 0)
+	assertEqual(t, true, errors.Is(err, ErrIsDir), "Expected ErrIsDir opening directory for writing")
+}
+
+func TestHandleReadWriteSeek(t *testing.T) {
+	fsys := NewFS()
+	f, err := fsys.Create("/data")
+	assertEqual(t, nil, err, "Expected Create to succeed")
+
+	// Test sequential writes then reading back after seeking
+	f.Write([]byte("hello"))
+	f.Write([]byte(" world"))
+	pos, _ := f.Seek(0, io.SeekStart)
+	assertEqual(t, int64(0), pos, "Expected seek to start")
+	all, _ := io.ReadAll(f)
+	assertEqual(t, "hello world", string(all), "Expected to read back what was written")
+
+	// Test ReadAt and WriteAt do not move the offset
+	f.WriteAt([]byte("W"), 6)
+	buf := make([]byte, 5)
+	n, _ := f.ReadAt(buf, 6)
+	assertEqual(t, 5, n, "Expected ReadAt to fill the buffer")
+	assertEqual(t, "World", string(buf), "Expected WriteAt to overwrite in place")
+	pos, _ = f.Seek(0, io.SeekCurrent)
+	assertEqual(t, int64(11), pos, "Expected offset to be unchanged by ReadAt/WriteAt")
+
+	// Test writing past the end zero-fills the gap
+	f.WriteAt([]byte("!"), 13)
+	content, _ := fsys.ReadFile("data")
+	assertEqual(t, "hello World\x00\x00!", string(content), "Expected gap to be zero-filled")
+
+	// Test Truncate shrinks and regrows with zeros
+	f.Truncate(5)
+	f.Truncate(7)
+	content, _ = fsys.ReadFile("data")
+	assertEqual(t, "hello\x00\x00", string(content), "Expected truncated bytes not to reappear")
+	info, _ := f.Stat()
+	assertEqual(t, int64(7), info.Size(), "Expected Stat to report the new size")
+
+	// Test sizes past maxFileSize are refused without allocating
+	err = f.Truncate(1 << 62)
+	assertEqual(t, true, errors.Is(err, ErrTooLarge), "Expected ErrTooLarge truncating to a huge size")
+	_, err = f.WriteAt([]byte("x"), 1<<63-1)
+	assertEqual(t, true, errors.Is(err, ErrTooLarge), "Expected ErrTooLarge writing at a huge offset")
+	f.Seek(1<<62, io.SeekStart)
+	_, err = f.Write([]byte("x"))
+	assertEqual(t, true, errors.Is(err, ErrTooLarge), "Expected ErrTooLarge writing past the limit")
+	info, _ = f.Stat()
+	assertEqual(t, int64(7), info.Size(), "Expected the size to be unchanged")
+
+	// Test seeking relative to the end and negative offsets
+	pos, _ = f.Seek(-2, io.SeekEnd)
+	assertEqual(t, int64(5), pos, "Expected seek relative to end")
+	_, err = f.Seek(-10, io.SeekCurrent)
+	assertEqual(t, true, errors.Is(err, fs.ErrInvalid), "Expected negative offset to be rejected")
+
+	// Test Sync and operations after Close
+	assertEqual(t, nil, f.Sync(), "Expected Sync to succeed")
+	assertEqual(t, nil, f.Close(), "Expected Close to succeed")
+	_, err = f.Write([]byte("x"))
+	assertEqual(t, true, errors.Is(err, fs.ErrClosed), "Expected fs.ErrClosed after Close")
+	assertEqual(t, true, errors.Is(f.Close(), fs.ErrClosed), "Expected double Close to fail")
+}
+
+func TestHandleSharesContent(t *testing.T) {
+	fsys := NewFS()
+	writer, _ := fsys.Create("/shared")
+	reader, _ := fsys.OpenFile("/shared", os.O_RDONLY, 0)
+
+	// Test a write through one handle is visible through another
+	writer.WriteString("visible")
+	buf := make([]byte, 7)
+	n, _ := reader.Read(buf)
+	assertEqual(t, "visible", string(buf[:n]), "Expected reader to see writer's data")
+
+	// Test an open handle keeps working after the file is removed
+	fsys.Remove("/shared")
+	writer.WriteString(" still")
+	reader.Seek(0, io.SeekStart)
+	all, _ := io.ReadAll(reader)
+	assertEqual(t, "visible still", string(all), "Expected removed file to remain usable through handles")
+}
@@ -0,0 +1,135 @@
+package imfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHardLink(t *testing.T) {
+	fsys := NewFS()
+	fsys.MkdirAll("/a/b")
+	fsys.WriteFile("/a/file", []byte("shared"))
+
+	// Test both names share contents and metadata
+	assertEqual(t, nil, fsys.Link("/a/file", "/a/b/other"), "Expected Link to succeed")
+	fsys.WriteFile("/a/b/other", []byte("changed"))
+	content, _ := fsys.ReadFile("a/file")
+	assertEqual(t, "changed", string(content), "Expected a write through one name to show through the other")
+	fsys.Chmod("/a/file", 0o600)
+	f, _ := fsys.Resolve("/a/file")
+	other, _ := fsys.Resolve("/a/b/other")
+	assertEqual(t, fs.FileMode(0o600), other.Mode, "Expected both names to share the mode")
+	assertEqual(t, f.Ino, other.Ino, "Expected both names to share the inode number")
+	assertEqual(t, 2, f.Nlink(), "Expected two links")
+
+	// Test directory link counts
+	dir, _ := fsys.Resolve("/a")
+	assertEqual(t, 3, dir.Nlink(), "Expected a directory with one subdirectory to have three links")
+
+	// Test directories cannot be linked and names cannot be reused
+	err := fsys.Link("/a/b", "/c")
+	assertEqual(t, true, errors.Is(err, ErrIsDir), "Expected ErrIsDir linking a directory")
+	err = fsys.Link("/a/file", "/a/b/other")
+	assertEqual(t, true, errors.Is(err, fs.ErrExist), "Expected fs.ErrExist linking over an existing name")
+
+	// Test removing one name leaves the other
+	assertEqual(t, nil, fsys.Remove("/a/file"), "Expected Remove to succeed")
+	assertEqual(t, 1, other.Nlink(), "Expected one link after removing the other")
+	content, _ = fsys.ReadFile("a/b/other")
+	assertEqual(t, "changed", string(content), "Expected the remaining name to keep the contents")
+}
+
+func TestHardLinkOpenHandle(t *testing.T) {
+	fsys := NewFS()
+	fsys.WriteFile("/file", []byte("still here"))
+	f, _ := fsys.Resolve("/file")
+
+	// Test an open file keeps its contents after its last name is removed
+	h, _ := fsys.OpenFile("/file", os.O_RDONLY, 0)
+	fsys.Remove("/file")
+	buf := make([]byte, 10)
+	n, _ := h.Read(buf)
+	assertEqual(t, "still here", string(buf[:n]), "Expected to read an unlinked file through an open handle")
+	assertEqual(t, 0, f.Nlink(), "Expected no links after Remove")
+
+	// Test the contents are freed on the last Close
+	h.Close()
+	assertEqual(t, true, f.Content == nil, "Expected the contents to be freed on Close")
+}
+
+func TestHardLinkPersistence(t *testing.T) {
+	fsys := NewFS()
+	fsys.WriteFile("/file", []byte("data"))
+	fsys.Mkdir("/dir")
+	fsys.Link("/file", "/dir/link")
+	f, _ := fsys.Resolve("/file")
+
+	// Test a snapshot keeps links and inode numbers
+	var buf bytes.Buffer
+	fsys.Save(&buf)
+	loaded := NewFS()
+	assertEqual(t, nil, loaded.Load(&buf), "Expected Load to succeed")
+	a, _ := loaded.Resolve("/file")
+	b, _ := loaded.Resolve("/dir/link")
+	assertEqual(t, true, a.Inode == b.Inode, "Expected the snapshot to keep the names sharing an inode")
+	assertEqual(t, f.Ino, a.Ino, "Expected the snapshot to keep the inode number")
+	loaded.WriteFile("/new", nil)
+	created, _ := loaded.Resolve("/new")
+	assertEqual(t, true, created.Ino > f.Ino, "Expected new inode numbers not to reuse saved ones")
+
+	// Test the journal replays links with the same inode numbers
+	state := t.TempDir() + "/state"
+	journaled := NewFS()
+	journaled.OpenJournal(state, DefaultJournalLimit)
+	journaled.WriteFile("/file", []byte("data"))
+	journaled.Link("/file", "/kept")
+	journaled.Link("/file", "/gone")
+	journaled.Remove("/gone")
+	journaled.Copy("/file", "/copy")
+	want, _ := journaled.Resolve("/copy")
+	journaled.journal.file.Close()
+
+	recovered := NewFS()
+	assertEqual(t, nil, recovered.OpenJournal(state, DefaultJournalLimit), "Expected recovery to succeed")
+	defer recovered.CloseJournal()
+	a, _ = recovered.Resolve("/file")
+	b, _ = recovered.Resolve("/kept")
+	assertEqual(t, true, a.Inode == b.Inode, "Expected the journal to replay the link")
+	assertEqual(t, 2, a.Nlink(), "Expected the journal to replay removing a link")
+	copied, _ := recovered.Resolve("/copy")
+	assertEqual(t, want.Ino, copied.Ino, "Expected the journal to keep inode numbers")
+}
+
+func TestHardLinkShell(t *testing.T) {
+	shell := NewShell()
+	shell.Mkdir("dir", false)
+	shell.RedirectWrite("file", "hello", false)
+
+	// Test ln without -s makes a hard link, inside a directory if given one
+	assertEqual(t, nil, shell.Link("file", "dir"), "Expected ln to succeed")
+	content, _ := shell.Cat("dir/file")
+	assertEqual(t, "hello", content, "Expected ln into a directory to keep the target's name")
+
+	// Test stat reports the inode and link count
+	f, _ := shell.Resolve("file")
+	info, err := shell.Describe("dir/file")
+	assertEqual(t, nil, err, "Expected stat to succeed")
+	assertEqual(t, true, strings.Contains(info, "Links: 2"), "Expected stat to show two links")
+	assertEqual(t, true, strings.Contains(info, "regular file"), "Expected stat to show the type")
+	assertEqual(t, true, strings.Contains(info, "(0644/-rw-r--r--)"), "Expected stat to show the mode")
+	assertEqual(t, true, strings.Contains(info, "Inode: "+strconv.FormatUint(f.Ino, 10)), "Expected stat to show the inode number")
+
+	// Test ls -i precedes each name with its inode number
+	d, _ := shell.Resolve("dir")
+	var out bytes.Buffer
+	_, err = shell.list(&out, true)
+	assertEqual(t, nil, err, "Expected ls -i to succeed")
+	want := fmt.Sprintf("%d dir/\n%d file\n", d.Ino, f.Ino)
+	assertEqual(t, want, out.String(), "Expected ls -i to list inode numbers")
+}
@@ -0,0 +1,182 @@
+package imfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The commands entered at the prompt make up the history, which is kept
+// in the tree in a file in the home directory, so that it lasts as long
+// as the tree does. The history command lists it, and a reference such as
+// !n in a line entered at the prompt recalls a command from it.
+
+// historyFile is the name of the history file in the home directory.
+const historyFile = ".imfs_history"
+
+// maxHistory is the number of commands the history keeps.
+const maxHistory = 1000
+
+// errNoEvent is returned for a history reference that matches no command.
+var errNoEvent = errors.New("event not found")
+
+// History returns the commands in the history, oldest first.
+func (s *Shell) History() []string {
+	return slices.Clone(s.history)
+}
+
+// historyPath returns the path of the history file, or "" if HOME is not
+// set.
+func (s *Shell) historyPath() string {
+	home, ok := s.vars["HOME"]
+	if !ok || home == "" {
+		return ""
+	}
+	return path.Join(home, historyFile)
+}
+
+// loadHistory reads the history from the history file, if there is one.
+// A file holding more than maxHistory commands is cut down to the latest.
+func (s *Shell) loadHistory() {
+	name := s.historyPath()
+	if name == "" {
+		return
+	}
+	content, err := s.Cat(name)
+	if err != nil || content == "" {
+		return
+	}
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	if len(lines) > maxHistory {
+		lines = lines[len(lines)-maxHistory:]
+		s.RedirectWrite(name, strings.Join(lines, "\n")+"\n", false)
+	}
+	s.history = lines
+}
+
+// addHistory adds command to the history and appends it to the history
+// file. The history is kept for the session even if the file cannot be
+// written.
+func (s *Shell) addHistory(command string) {
+	if strings.TrimSpace(command) == "" {
+		return
+	}
+	s.history = append(s.history, command)
+	if len(s.history) > maxHistory {
+		s.history = s.history[len(s.history)-maxHistory:]
+	}
+	if name := s.historyPath(); name != "" {
+		s.RedirectWrite(name, command+"\n", true)
+	}
+}
+
+// clearHistory empties the history and the history file.
+func (s *Shell) clearHistory() {
+	s.history = nil
+	if name := s.historyPath(); name != "" {
+		if _, err := s.Resolve(name); err == nil {
+			s.RedirectWrite(name, "", false)
+		}
+	}
+}
+
+// printHistory writes the last n commands of the history to w, or all of
+// them if n is negative, each after its number.
+func (s *Shell) printHistory(w io.Writer, n int) {
+	first := 0
+	if n >= 0 && n < len(s.history) {
+		first = len(s.history) - n
+	}
+	for i := first; i < len(s.history); i++ {
+		fmt.Fprintf(w, "%5d  %s\n", i+1, s.history[i])
+	}
+}
+
+// expandHistory replaces the history references in line with the commands
+// they stand for, as in bash: !! is the last command, !n the nth, !-n the
+// nth from last and !prefix the last that starts with prefix. A ! that is
+// in single quotes or escaped with a backslash, or that comes before a
+// blank, = or ( or at the end of the line, stands for itself.
+func (s *Shell) expandHistory(line string) (string, error) {
+	if !strings.Contains(line, "!") {
+		return line, nil
+	}
+	var b strings.Builder
+	single, double := false, false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\'' && !double:
+			single = !single
+		case c == '"' && !single:
+			double = !double
+		case c == '\\' && !single && i+1 < len(line):
+			b.WriteByte(c)
+			i++
+			c = line[i]
+		case c == '!' && !single && i+1 < len(line) && !strings.ContainsRune(" \t=(", rune(line[i+1])):
+			ref := historyRef(line[i:])
+			if ref == "!" {
+				break
+			}
+			command, err := s.event(ref)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(command)
+			i += len(ref) - 1
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), nil
+}
+
+// historyRef returns the history reference that s starts with.
+func historyRef(s string) string {
+	if strings.HasPrefix(s, "!!") {
+		return "!!"
+	}
+	end := 1
+	if s[end] == '-' {
+		end++
+	}
+	if end < len(s) && '0' <= s[end] && s[end] <= '9' {
+		for end < len(s) && '0' <= s[end] && s[end] <= '9' {
+			end++
+		}
+		return s[:end]
+	}
+	end = 1
+	for end < len(s) && !isBlank(s[end]) && !strings.ContainsRune(";&|<>()\"'`", rune(s[end])) {
+		end++
+	}
+	return s[:end]
+}
+
+// event returns the command in the history that ref stands for.
+func (s *Shell) event(ref string) (string, error) {
+	spec := ref[1:]
+	if spec == "!" {
+		spec = "-1"
+	}
+	if n, err := strconv.Atoi(spec); err == nil {
+		if n < 0 {
+			n += len(s.history) + 1
+		}
+		if n >= 1 && n <= len(s.history) {
+			return s.history[n-1], nil
+		}
+		return "", fmt.Errorf("%s: %w", ref, errNoEvent)
+	}
+	for i := len(s.history) - 1; i >= 0; i-- {
+		if strings.HasPrefix(s.history[i], spec) {
+			return s.history[i], nil
+		}
+	}
+	return "", fmt.Errorf("%s: %w", ref, errNoEvent)
+}
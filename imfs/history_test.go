@@ -0,0 +1,65 @@
+package imfs
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// interact runs lines at an interactive shell, as if typed at the prompt.
+func interact(shell *Shell, lines ...string) (string, string) {
+	var out, errOut bytes.Buffer
+	read := func(bool) (string, error) {
+		if len(lines) == 0 {
+			return "", io.EOF
+		}
+		line := lines[0]
+		lines = lines[1:]
+		return line, nil
+	}
+	shell.interpret(read, Stdio{In: strings.NewReader(""), Out: &out, Err: &errOut}, true)
+	return out.String(), errOut.String()
+}
+
+func TestHistory(t *testing.T) {
+	shell := NewShell()
+
+	// Test history references are expanded and shown
+	out, errOut := interact(shell, "echo one", "echo two", "!1", `echo 'a!b' !! != !`, "!nothing", "!ec", "history 3")
+	assertEqual(t, "imfs: !nothing: event not found\n", errOut, "Expected an unknown event to be refused")
+	want := "one\ntwo\necho one\none\necho 'a!b' echo one != !\na!b echo one != !\necho 'a!b' echo one != !\na!b echo one != !\n" +
+		"    4  echo 'a!b' echo one != !\n    5  echo 'a!b' echo one != !\n    6  history 3\n"
+	assertEqual(t, want, out, "Expected !n, !! and !prefix to recall commands")
+
+	// Test commands across lines are one entry, and scripts keep none
+	interact(shell, "echo 'a", "b'")
+	runLine(shell, "echo not kept")
+	shell.Exec("echo not kept", Stdio{In: strings.NewReader(""), Out: io.Discard, Err: io.Discard})
+	history := shell.History()
+	assertEqual(t, 7, len(history), "Expected one entry for each command entered")
+	assertEqual(t, "echo 'a\nb'", history[6], "Expected continued lines to be kept together")
+
+	// Test the history is kept in the home directory
+	content, err := shell.Cat("/.imfs_history")
+	assertEqual(t, nil, err, "Expected a history file")
+	assertEqual(t, strings.Join(history, "\n")+"\n", content, "Expected the history file to hold every command")
+	other := NewShellFS(shell.FS)
+	other.loadHistory()
+	assertEqual(t, "echo two", other.History()[1], "Expected a new shell to read the history file")
+
+	// Test history -c and interrupted lines
+	out, _ = interact(shell, "history -c", "history")
+	assertEqual(t, "    1  history\n", out, "Expected history -c to clear the history")
+	content, _ = shell.Cat("/.imfs_history")
+	assertEqual(t, "history\n", content, "Expected history -c to clear the history file")
+	interrupted := false
+	shell.interpret(func(bool) (string, error) {
+		if interrupted {
+			return "", io.EOF
+		}
+		interrupted = true
+		return "", errInterrupted
+	}, Stdio{}, true)
+	assertEqual(t, 130, shell.status, "Expected Ctrl-C to set the status")
+}
@@ -2,747 +2,1518 @@ package imfs
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
+// Errors returned by Shell operations in addition to the io/fs sentinels
+// (fs.ErrNotExist, fs.ErrExist, fs.ErrInvalid). They are always wrapped in
+// an *fs.PathError, so callers should test for them with errors.Is.
+var (
+	ErrNotEmpty = errors.New("directory not empty")
+	ErrIsDir    = errors.New("is a directory")
+	ErrNotDir   = errors.New("not a directory")
+	ErrReadOnly = errors.New("read-only file system")
+	ErrLoop     = errors.New("too many levels of symbolic links")
+)
+
+// A File is a directory entry: a name in a parent directory for an Inode.
+// Several entries may share the inode of a file or symbolic link, which
+// are then hard links to each other; a directory has exactly one.
 type File struct {
 	Name        string
-	Size        int64
-	CreatedAt   time.Time
-	ModifiedAt  time.Time
 	IsDirectory bool
-	Content     []byte
+	IsSymlink   bool
 	Children    []*File
 	Parent      *File
+	*Inode
+
+	mount    *File // contents of the archive mounted on this file
+	readOnly bool  // part of a mounted archive
+}
+
+// An Inode holds the contents and metadata of a file, shared by every
+// entry linked to it.
+type Inode struct {
+	Ino        uint64 // unique within the tree, and kept across restarts
+	Size       int64
+	CreatedAt  time.Time
+	ModifiedAt time.Time
+	Content    []byte
+
+	// Target is the path a symbolic link points to, as given when it was
+	// made.
+	Target string
+
+	// Mode holds the permission bits, along with fs.ModeSetuid,
+	// fs.ModeSetgid and fs.ModeSticky; the type is given by IsDirectory
+	// and IsSymlink.
+	Mode fs.FileMode
+	UID  int
+	GID  int
+
+	// Xattrs holds the extended attributes, by name with namespace
+	// prefix. It is nil until the first one is set.
+	Xattrs map[string][]byte
+
+	links []*File      // entries for the inode, in the tree or not
+	opens atomic.Int32 // open handles
+	locks []fileLock   // advisory locks, guarded by the lock table mutex
 }
 
 // Shell is a simple REPL for interacting with the file system
 // NB: This system should... mostly be provably correct.
 // TODO(nigel): Add unit tests to cover state transitions.
+//
+// A Shell only owns its working directory and identity; the tree itself
+// lives in the embedded FS, which may be shared with other shells.
 type Shell struct {
-	Root *File
-	Cwd  *File
+	*FS
+	Cwd *File
+
+	outer      []*FS  // views replaced by su, innermost last
+	logical    string // path Cd took to logicalDir, through any links
+	logicalDir *File
+
+	status     int  // status of the last pipeline run
+	exited     bool // set by exit, which ends Run and scripts
+	errexit    bool // set by set -e
+	sourcing   int  // depth of nested source commands
+	calls      int  // depth of nested function calls
+	loops      int  // depth of the loops running in the current function
+	conditions int  // depth of the if and while conditions running
+
+	// break n and continue n set breaking or continuing to n, and return
+	// sets returning, to end the loops and function they are in.
+	breaking   int
+	continuing int
+	returning  bool
+
+	vars      map[string]string
+	exported  map[string]bool
+	params    []string // positional parameters, from $1
+	aliases   map[string]string
+	functions map[string]command
+	commands  map[string]Command
+	history   []string // commands entered at the prompt, oldest first
 }
 
 func NewShell() *Shell {
-	root := &File{
-		Name:        "/",
-		IsDirectory: true,
+	return NewShellFS(NewFS())
+}
+
+// NewShellFS returns a shell whose working directory is the root of fsys.
+// The shell acts for the user of fsys, with its own copy of the umask.
+func NewShellFS(fsys *FS) *Shell {
+	view := *fsys
+	s := &Shell{
+		FS:        &view,
+		Cwd:       fsys.Root,
+		aliases:   make(map[string]string),
+		functions: make(map[string]command),
+		commands:  make(map[string]Command),
 	}
-	return &Shell{
-		Root: root,
-		Cwd:  root,
+	for _, b := range builtins {
+		s.Register(b)
 	}
+	s.initVars()
+	return s
 }
 
-func (s *Shell) Ls() []string {
-	if s.Cwd == nil {
-		return nil
+// abs turns name into an absolute path by prefixing relative names with
+// the physical working directory. ".." components are left for the FS to
+// resolve, so they lead to the parent of the directory a link points to.
+func (s *Shell) abs(name string) string {
+	if strings.HasPrefix(name, "/") {
+		return name
 	}
+	return strings.TrimSuffix(s.PwdPhysical(), "/") + "/" + name
+}
 
-	names := make([]string, 0, len(s.Cwd.Children))
+// Resolve returns the node named by path, which is relative to the working
+// directory unless it starts with a slash. Every command resolves its
+// arguments through Resolve or ResolveParent.
+func (s *Shell) Resolve(path string) (*File, error) {
+	f, err := s.FS.Resolve(s.abs(path))
+	return f, pathError("stat", path, err)
+}
 
-	for _, child := range s.Cwd.Children {
-		names = append(names, child.Name)
-		if child.IsDirectory {
-			fmt.Printf("%s/\n", child.Name)
-		} else {
-			fmt.Println(child.Name)
-		}
+// ResolveParent returns the directory that holds (or would hold) path
+// together with its final component.
+func (s *Shell) ResolveParent(path string) (*File, string, error) {
+	dir, base, err := s.FS.ResolveParent(s.abs(path))
+	return dir, base, pathError("stat", path, err)
+}
+
+// pathError reports err against the name the user supplied rather than
+// the absolute path handed to the FS.
+func pathError(op, name string, err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return &fs.PathError{Op: op, Path: name, Err: pathErr.Err}
 	}
+	return err
+}
 
+func (s *Shell) Ls() []string {
+	names, err := s.list(os.Stdout, false)
+	if err != nil {
+		diagnose(os.Stderr, "ls", err)
+	}
 	return names
 }
 
-func (s *Shell) Cd(name string) {
-	if name == "" {
-		return
+// list writes the entries of the working directory to w, one per line
+// and marked by type, and returns their names.
+func (s *Shell) list(w io.Writer, inodes bool) ([]string, error) {
+	if s.Cwd == nil {
+		return nil, nil
 	}
+	return s.listDir(w, s.Cwd, ".", inodes)
+}
 
-	switch name {
-	case "/":
-		s.Cwd = s.Root
-		return
-	case "..":
-		if s.Cwd.Parent != nil {
-			s.Cwd = s.Cwd.Parent
-		}
-		return
+// listDir writes the entries of dir, which was given as name, to w as
+// list does, and returns their names. A mounted archive is listed as the
+// directory it holds.
+func (s *Shell) listDir(w io.Writer, dir *File, name string, inodes bool) ([]string, error) {
+	s.FS.mu.RLock()
+	if dir.mount != nil {
+		dir = dir.mount
 	}
-
-	if strings.HasPrefix(name, "/") {
-		s.Cwd = s.Root
-		name = name[1:]
-		if name == "" {
-			return
-		}
+	if !s.FS.access(dir, accessRead) {
+		s.FS.mu.RUnlock()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
 	}
+	entries := dir.entries()
+	s.FS.mu.RUnlock()
 
-	components := strings.Split(name, "/")
-	currentDir := s.Cwd
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+		writeEntry(w, entry, inodes)
+	}
+	return names, nil
+}
 
-	for _, component := range components {
-		if component == "" {
+// listPaths writes names to w as ls(1) does: the files among them first,
+// as they were given, then the entries of each directory, under its name
+// if there are several names.
+func (s *Shell) listPaths(w io.Writer, names []string, inodes bool) error {
+	var err error
+	var files []fs.DirEntry
+	var dirs []*File
+	var dirNames []string
+	for _, name := range names {
+		f, resolveErr := s.Resolve(name)
+		if resolveErr != nil {
+			err = errors.Join(err, resolveErr)
 			continue
 		}
-
-		// TODO(nigel): Why is this necessary? Can this be removed?
-		if component == ".." {
-			if currentDir.Parent != nil {
-				currentDir = currentDir.Parent
-			}
+		if f.IsDirectory || f.mount != nil {
+			dirs = append(dirs, f)
+			dirNames = append(dirNames, name)
 			continue
 		}
+		s.FS.mu.RLock()
+		info := f.info()
+		s.FS.mu.RUnlock()
+		info.name = name
+		files = append(files, dirEntry{info})
+	}
 
-		found := false
-		for _, child := range currentDir.Children {
-			if child.Name == component && child.IsDirectory {
-				currentDir = child
-				found = true
-				break
+	for _, entry := range files {
+		writeEntry(w, entry, inodes)
+	}
+	for i, dir := range dirs {
+		if len(names) > 1 {
+			if i > 0 || len(files) > 0 {
+				fmt.Fprintln(w)
 			}
+			fmt.Fprintf(w, "%s:\n", dirNames[i])
 		}
-
-		if !found {
-			fmt.Printf("cd: no such directory: %s\n", name)
-			return
-		}
+		_, listErr := s.listDir(w, dir, dirNames[i], inodes)
+		err = errors.Join(err, listErr)
 	}
+	return err
+}
 
-	s.Cwd = currentDir
+// writeEntry writes entry to w for ls: its name, marked with / for a
+// directory and @ for a symbolic link, after its inode number if inodes
+// is set.
+func writeEntry(w io.Writer, entry fs.DirEntry, inodes bool) {
+	if inodes {
+		fmt.Fprintf(w, "%d ", entry.(dirEntry).info.ino)
+	}
+	switch {
+	case entry.IsDir():
+		fmt.Fprintf(w, "%s/\n", entry.Name())
+	case entry.Type()&fs.ModeSymlink != 0:
+		fmt.Fprintf(w, "%s@\n", entry.Name())
+	default:
+		fmt.Fprintln(w, entry.Name())
+	}
 }
 
-func (s *Shell) Pwd() string {
-	if s.Cwd == s.Root {
-		return "/"
+// Cd changes the working directory. As in other shells, ".." in name
+// undoes the component before it in the logical path, so cd link/.. comes
+// back to where it started rather than to the parent of the link's target.
+func (s *Shell) Cd(name string) error {
+	if name == "" {
+		return nil
 	}
 
-	path := s.Cwd.Name
-	current := s.Cwd.Parent
-	for current != nil && current != s.Root {
-		path = current.Name + "/" + path
-		current = current.Parent
+	logical := name
+	if !strings.HasPrefix(name, "/") {
+		logical = s.Pwd() + "/" + name
 	}
-	path = "/" + path
-	return path
+	logical = path.Clean(logical)
+	dir, err := s.FS.Resolve(logical)
+	if err != nil {
+		return pathError("chdir", name, err)
+	}
+	if dir.mount != nil {
+		dir = dir.mount
+	}
+	if !dir.IsDirectory {
+		return &fs.PathError{Op: "chdir", Path: name, Err: ErrNotDir}
+	}
+	s.FS.mu.RLock()
+	searchable := s.FS.access(dir, accessSearch)
+	s.FS.mu.RUnlock()
+	if !searchable {
+		return &fs.PathError{Op: "chdir", Path: name, Err: fs.ErrPermission}
+	}
+
+	s.vars["OLDPWD"] = s.Pwd()
+	s.Cwd = dir
+	s.logical, s.logicalDir = logical, dir
+	s.vars["PWD"] = logical
+	return nil
 }
 
-func (s *Shell) RedirectWrite(filename, content string, shouldAppend bool) {
-	if filename == "" {
-		return
+// Pwd returns the working directory by the path Cd took to it, which may
+// pass through symbolic links.
+func (s *Shell) Pwd() string {
+	if s.logicalDir == s.Cwd && s.logical != "" {
+		return s.logical
 	}
+	return s.PwdPhysical()
+}
 
-	var targetFile *File
-	for _, child := range s.Cwd.Children {
-		if child.Name == filename {
-			if child.IsDirectory {
-				return // Can't write to a directory
-			}
-			targetFile = child
-			break
-		}
-	}
+// PwdPhysical returns the working directory with no symbolic links in it,
+// as pwd -P does.
+func (s *Shell) PwdPhysical() string {
+	s.FS.mu.RLock()
+	defer s.FS.mu.RUnlock()
 
-	if targetFile == nil {
-		for _, child := range s.Cwd.Children {
-			if child.Name == filename && child.IsDirectory {
-				return
-			}
-		}
+	return s.Cwd.path()
+}
 
-		targetFile = &File{
-			Name:        filename,
-			IsDirectory: false,
-			CreatedAt:   time.Now(),
-			ModifiedAt:  time.Now(),
-			Parent:      s.Cwd,
-		}
-		s.Cwd.Children = append(s.Cwd.Children, targetFile)
+func (s *Shell) RedirectWrite(filename, content string, shouldAppend bool) error {
+	if filename == "" {
+		return &fs.PathError{Op: "open", Path: filename, Err: fs.ErrInvalid}
 	}
 
+	var err error
 	if shouldAppend {
-		targetFile.Content = append(targetFile.Content, []byte(content)...)
+		err = s.FS.AppendFile(s.abs(filename), []byte(content))
 	} else {
-		targetFile.Content = []byte(content)
+		err = s.FS.WriteFile(s.abs(filename), []byte(content))
 	}
-
-	targetFile.Size = int64(len(targetFile.Content))
-	targetFile.ModifiedAt = time.Now()
+	return pathError("open", filename, err)
 }
 
-func (s *Shell) Move(source, dest string) {
+func (s *Shell) Move(source, dest string) error {
 	if source == "" || dest == "" {
-		fmt.Println("Usage: mv <source> <destination>")
-		return
+		return &fs.PathError{Op: "rename", Path: source, Err: fs.ErrInvalid}
 	}
 
-	// Save current directory
-	originalCwd := s.Cwd
-
-	// Find source file/directory
-	var sourceFile *File
-	var sourceIndex int
-	for i, child := range s.Cwd.Children {
-		if child.Name == source {
-			sourceFile = child
-			sourceIndex = i
-			break
-		}
+	target, err := s.intoDir(source, dest)
+	if err != nil {
+		return pathError("rename", source, err)
 	}
 
-	if sourceFile == nil {
-		return // Source doesn't exist
+	return s.pairError("rename", source, dest, target, s.FS.Rename(s.abs(source), target))
+}
+
+func (s *Shell) Copy(source, dest string) error {
+	if source == "" || dest == "" {
+		return &fs.PathError{Op: "copy", Path: source, Err: fs.ErrInvalid}
 	}
 
-	// Handle absolute paths
-	if strings.HasPrefix(dest, "/") {
-		s.Cwd = s.Root
-		dest = dest[1:]
-		if dest == "" {
-			return
-		}
+	target, err := s.intoDir(source, dest)
+	if err != nil {
+		return pathError("copy", source, err)
 	}
 
-	// Remove trailing slash if present
-	dest = strings.TrimSuffix(dest, "/")
+	return s.pairError("copy", source, dest, target, s.FS.Copy(s.abs(source), target))
+}
 
-	// Split destination path into components
-	components := strings.Split(dest, "/")
-	currentDir := s.Cwd
+// pairError reports err, from moving or copying source to the absolute
+// path target that dest led to, against the name the user gave for the
+// side it concerns. Errors about any other path, such as a directory on
+// the way, are returned as the FS reported them.
+func (s *Shell) pairError(op, source, dest, target string, err error) error {
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) {
+		return err
+	}
+	switch path.Clean(pathErr.Path) {
+	case path.Clean(s.abs(source)):
+		return pathError(op, source, err)
+	case path.Clean(s.abs(dest)):
+		return pathError(op, dest, err)
+	case path.Clean(target):
+		return pathError(op, strings.TrimSuffix(dest, "/")+"/"+path.Base(target), err)
+	}
+	return err
+}
 
-	// Navigate to the target directory, but DO NOT create directories
-	for i := 0; i < len(components); i++ {
-		component := components[i]
-		if component == "" {
-			continue
-		}
+// intoDir returns the absolute path that source should be moved or copied
+// to: dest itself, or dest/<source name> when dest is an existing directory.
+// A link in source keeps its own name.
+func (s *Shell) intoDir(source, dest string) (string, error) {
+	sourceFile, err := s.resolveLink("stat", source)
+	if err != nil {
+		return "", err
+	}
 
-		if component == ".." {
-			if currentDir.Parent != nil {
-				currentDir = currentDir.Parent
-			}
-			continue
-		}
+	target := s.abs(dest)
+	if destFile, err := s.Resolve(dest); err == nil && destFile.IsDirectory {
+		target = strings.TrimSuffix(target, "/") + "/" + sourceFile.Name
+	}
+	return target, nil
+}
 
-		// If this is the last component and it's not "..", check if it's a directory
-		if i == len(components)-1 {
-			var destDir *File
-			for _, child := range currentDir.Children {
-				if child.Name == component && child.IsDirectory {
-					destDir = child
-					break
-				}
-			}
+func (s *Shell) Mkdir(name string, createParents bool) error {
+	if name == "" {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
 
-			if destDir != nil {
-				// Move into this directory, keep original name
-				for _, c := range destDir.Children {
-					if c.Name == sourceFile.Name {
-						s.Cwd = originalCwd
-						return // Target already exists
-					}
-				}
-				// Remove source from its current location
-				originalCwd.Children = append(originalCwd.Children[:sourceIndex], originalCwd.Children[sourceIndex+1:]...)
-				sourceFile.Parent = destDir
-				destDir.Children = append(destDir.Children, sourceFile)
-				s.Cwd = originalCwd
-				return
-			}
+	if createParents {
+		return pathError("mkdir", name, s.FS.MkdirAll(s.abs(name)))
+	}
+	return pathError("mkdir", name, s.FS.Mkdir(s.abs(name)))
+}
 
-			// Check if target already exists
-			for _, child := range currentDir.Children {
-				if child.Name == component {
-					s.Cwd = originalCwd
-					return // Target already exists
-				}
-			}
+func (s *Shell) Touch(name string) error {
+	if name == "" {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
 
-			// If we're moving to the same location with the same name, do nothing
-			if currentDir == originalCwd && component == sourceFile.Name {
-				s.Cwd = originalCwd
-				return
-			}
+	// Creates the file, or updates its modification time if it exists
+	return pathError("open", name, s.FS.touch(s.abs(name)))
+}
 
-			// Remove source from its current location
-			originalCwd.Children = append(originalCwd.Children[:sourceIndex], originalCwd.Children[sourceIndex+1:]...)
+func (s *Shell) Cat(name string) (string, error) {
+	if name == "" {
+		return "", &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
 
-			// Update source's parent and name
-			sourceFile.Parent = currentDir
-			sourceFile.Name = component
+	content, err := s.FS.readFile("open", s.abs(name))
+	if err != nil {
+		return "", pathError("open", name, err)
+	}
+	return string(content), nil
+}
 
-			// Add source to new location
-			currentDir.Children = append(currentDir.Children, sourceFile)
+// cat writes the content of the file name, or standard input if name is
+// "-", to standard output.
+func (s *Shell) cat(name string, stdio Stdio) error {
+	if name == "-" {
+		_, err := io.Copy(stdio.Out, stdio.In)
+		return err
+	}
+	content, err := s.Cat(name)
+	if err == nil {
+		_, err = io.WriteString(stdio.Out, content)
+	}
+	return err
+}
 
-			// Restore original directory
-			s.Cwd = originalCwd
-			return
+// grep writes the lines of the named files, or of standard input if there
+// are none, that match the regular expression pattern. As with grep(1), -i
+// ignores case, -v selects the lines that do not match, -n numbers them
+// and -c counts them instead. With several files each line is preceded by
+// the name of its file. grep reports whether any line was selected.
+func (s *Shell) grep(pattern string, names []string, opts options, stdio Stdio) (bool, error) {
+	if opts.has('i') {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, err
+	}
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+
+	found := false
+	for _, name := range names {
+		in := stdio.In
+		if name != "-" {
+			content, catErr := s.Cat(name)
+			if catErr != nil {
+				err = errors.Join(err, catErr)
+				continue
+			}
+			in = strings.NewReader(content)
+		}
+		prefix := ""
+		if len(names) > 1 {
+			prefix = name + ":"
 		}
 
-		found := false
-		for _, child := range currentDir.Children {
-			if child.Name == component && child.IsDirectory {
-				currentDir = child
-				found = true
+		r := bufio.NewReader(in)
+		count := 0
+		for n := 1; ; n++ {
+			line, readErr := r.ReadString('\n')
+			if line == "" && readErr != nil {
+				if readErr != io.EOF {
+					err = errors.Join(err, readErr)
+				}
 				break
 			}
+			line = strings.TrimSuffix(line, "\n")
+			if re.MatchString(line) == opts.has('v') {
+				continue
+			}
+			count++
+			switch {
+			case opts.has('c'):
+			case opts.has('n'):
+				fmt.Fprintf(stdio.Out, "%s%d:%s\n", prefix, n, line)
+			default:
+				fmt.Fprintf(stdio.Out, "%s%s\n", prefix, line)
+			}
 		}
-
-		if !found {
-			s.Cwd = originalCwd
-			return // Destination directory doesn't exist, abort move
+		if opts.has('c') {
+			fmt.Fprintf(stdio.Out, "%s%d\n", prefix, count)
 		}
+		found = found || count > 0
 	}
+	return found, err
+}
 
-	// If we get here, we're moving to the current directory
-	// Check if target already exists
-	for _, child := range currentDir.Children {
-		if child.Name == sourceFile.Name {
-			s.Cwd = originalCwd
-			return // Target already exists
+func (s *Shell) Find(name string) string {
+	if name == "" {
+		return ""
+	}
+
+	s.FS.mu.RLock()
+	defer s.FS.mu.RUnlock()
+
+	var searchDir func(dir *File, currentPath string) string
+	searchDir = func(dir *File, currentPath string) string {
+		for _, child := range dir.Children {
+			childPath := currentPath + "/" + child.Name
+			if strings.Contains(child.Name, name) {
+				return childPath
+			}
+			// Directories that cannot be listed are skipped, as find does.
+			if child.IsDirectory && s.FS.access(child, accessRead|accessSearch) {
+				if result := searchDir(child, childPath); result != "" {
+					return result
+				}
+			}
 		}
+		return ""
 	}
 
-	// Remove source from its current location
-	originalCwd.Children = append(originalCwd.Children[:sourceIndex], originalCwd.Children[sourceIndex+1:]...)
+	return searchDir(s.Root, "")
+}
 
-	// Update source's parent
-	sourceFile.Parent = currentDir
+func (s *Shell) Remove(name string, recursive bool) error {
+	if name == "" {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
 
-	// Add source to new location
-	currentDir.Children = append(currentDir.Children, sourceFile)
+	if _, err := s.resolveLink("remove", name); err != nil {
+		return err
+	}
 
-	// Restore original directory
-	s.Cwd = originalCwd
+	if recursive {
+		return pathError("remove", name, s.FS.RemoveAll(s.abs(name)))
+	}
+	return pathError("remove", name, s.FS.Remove(s.abs(name)))
 }
 
-func (s *Shell) Copy(source, dest string) {
-	if source == "" || dest == "" {
-		return
+// resolveLink is Resolve without following a final symbolic link.
+func (s *Shell) resolveLink(op, name string) (*File, error) {
+	s.FS.mu.RLock()
+	defer s.FS.mu.RUnlock()
+
+	f, err := s.FS.lookupLink(op, s.abs(name))
+	return f, pathError(op, name, err)
+}
+
+// Symlink creates name as a symbolic link to target, or a link of the same
+// name as target inside name if that is a directory.
+func (s *Shell) Symlink(target, name string) error {
+	if target == "" || name == "" {
+		return &fs.PathError{Op: "symlink", Path: name, Err: fs.ErrInvalid}
 	}
 
-	// Save current directory
-	originalCwd := s.Cwd
+	link := s.abs(name)
+	if dir, err := s.Resolve(name); err == nil && dir.IsDirectory {
+		link = strings.TrimSuffix(link, "/") + "/" + path.Base(target)
+	}
+	return pathError("symlink", name, s.FS.Symlink(target, link))
+}
 
-	// Find source file/directory
-	var sourceFile *File
-	for _, child := range s.Cwd.Children {
-		if child.Name == source {
-			sourceFile = child
-			break
-		}
+// Link creates name as a hard link to target. If name is an existing
+// directory, the link is made inside it with the target's base name.
+func (s *Shell) Link(target, name string) error {
+	if target == "" || name == "" {
+		return &fs.PathError{Op: "link", Path: name, Err: fs.ErrInvalid}
 	}
 
-	if sourceFile == nil {
-		return // Source doesn't exist
+	link := s.abs(name)
+	if dir, err := s.Resolve(name); err == nil && dir.IsDirectory {
+		link = strings.TrimSuffix(link, "/") + "/" + path.Base(target)
 	}
+	return pathError("link", name, s.FS.Link(s.abs(target), link))
+}
 
-	// Handle absolute paths
-	if strings.HasPrefix(dest, "/") {
-		s.Cwd = s.Root
-		dest = dest[1:]
+// Describe returns what stat(1) prints about name: its size, type, inode,
+// link count, mode, owner and times. Like stat(1), it describes a symbolic
+// link rather than its target.
+func (s *Shell) Describe(name string) (string, error) {
+	accounts, err := s.Accounts()
+	if err != nil {
+		accounts = &Accounts{}
 	}
 
-	// Split destination path into components
-	components := strings.Split(dest, "/")
-	lastComponent := components[len(components)-1]
-	components = components[:len(components)-1]
+	s.FS.mu.RLock()
+	defer s.FS.mu.RUnlock()
 
-	// Navigate to destination directory
-	for _, component := range components {
-		if component == "" {
-			continue
+	f, err := s.FS.lookupLink("stat", s.abs(name))
+	if err != nil {
+		return "", pathError("stat", name, err)
+	}
+
+	var b strings.Builder
+	kind := "regular file"
+	switch {
+	case f.IsDirectory:
+		kind = "directory"
+	case f.IsSymlink:
+		kind = "symbolic link"
+		fmt.Fprintf(&b, "  File: %s -> %s\n", name, f.Target)
+	case f.Size == 0:
+		kind = "regular empty file"
+	}
+	if !f.IsSymlink {
+		fmt.Fprintf(&b, "  File: %s\n", name)
+	}
+	user, group := "UNKNOWN", "UNKNOWN"
+	if u, err := accounts.LookupUID(f.UID); err == nil {
+		user = u.Name
+	}
+	if g, err := accounts.LookupGID(f.GID); err == nil {
+		group = g.Name
+	}
+	fmt.Fprintf(&b, "  Size: %-10d  %s\n", f.Size, kind)
+	fmt.Fprintf(&b, " Inode: %-10d  Links: %d\n", f.Ino, f.Nlink())
+	fmt.Fprintf(&b, "Access: (%04o/%s)  Uid: (%5d/%8s)   Gid: (%5d/%8s)\n",
+		tarMode(f.Mode), f.info().mode, f.UID, user, f.GID, group)
+	fmt.Fprintf(&b, "Modify: %s\n", f.ModifiedAt.Format(statTime))
+	fmt.Fprintf(&b, " Birth: %s\n", f.CreatedAt.Format(statTime))
+	return b.String(), nil
+}
+
+// statTime is the layout stat(1) uses for times.
+const statTime = "2006-01-02 15:04:05.000000000 -0700"
+
+// Getfattr returns what getfattr(1) prints for name: a "# file:" line
+// followed by the attribute attr or, if attr is empty, by every attribute
+// the shell's user may see. Values are shown for a named attribute, or for
+// all of them when dump is set.
+func (s *Shell) Getfattr(name, attr string, dump bool) (string, error) {
+	attrs := []string{attr}
+	if attr == "" {
+		var err error
+		if attrs, err = s.FS.ListXattr(s.abs(name)); err != nil || len(attrs) == 0 {
+			return "", pathError("getfattr", name, err)
 		}
+	}
 
-		if component == ".." {
-			if s.Cwd.Parent != nil {
-				s.Cwd = s.Cwd.Parent
-			}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# file: %s\n", name)
+	for _, a := range attrs {
+		if attr == "" && !dump {
+			fmt.Fprintln(&b, a)
 			continue
 		}
-
-		found := false
-		for _, child := range s.Cwd.Children {
-			if child.Name == component && child.IsDirectory {
-				s.Cwd = child
-				found = true
-				break
-			}
+		value, err := s.FS.GetXattr(s.abs(name), a)
+		if err != nil {
+			return "", pathError("getfattr", name, err)
 		}
+		fmt.Fprintf(&b, "%s=%s\n", a, encodeXattr(value))
+	}
+	b.WriteString("\n")
+	return b.String(), nil
+}
 
-		if !found {
-			// Destination directory doesn't exist
-			s.Cwd = originalCwd
-			return
-		}
+// Setfattr sets the attribute attr of name to value, which as for
+// setfattr(1) is text, optionally quoted, or 0x followed by hex digits, or
+// 0s followed by base64.
+func (s *Shell) Setfattr(name, attr, value string) error {
+	data, err := decodeXattr(value)
+	if err != nil {
+		return &fs.PathError{Op: "setfattr", Path: name, Err: fs.ErrInvalid}
 	}
+	return pathError("setfattr", name, s.FS.SetXattr(s.abs(name), attr, data))
+}
 
-	// Create a deep copy of the source file/directory
-	var copyFile func(*File) *File
-	copyFile = func(f *File) *File {
-		newFile := &File{
-			Name:        f.Name,
-			Size:        f.Size,
-			CreatedAt:   time.Now(),
-			ModifiedAt:  time.Now(),
-			IsDirectory: f.IsDirectory,
-			Content:     make([]byte, len(f.Content)),
-			Parent:      s.Cwd,
-		}
-		copy(newFile.Content, f.Content)
+// Removefattr removes the attribute attr from name, as setfattr -x does.
+func (s *Shell) Removefattr(name, attr string) error {
+	return pathError("setfattr", name, s.FS.RemoveXattr(s.abs(name), attr))
+}
 
-		if f.IsDirectory {
-			newFile.Children = make([]*File, 0, len(f.Children))
-			for _, child := range f.Children {
-				newChild := copyFile(child)
-				newChild.Parent = newFile
-				newFile.Children = append(newFile.Children, newChild)
-			}
-		}
+// encodeXattr formats an attribute value as getfattr(1) does: quoted if it
+// is printable text, and in hex otherwise.
+func encodeXattr(value []byte) string {
+	if utf8.Valid(value) && !strings.ContainsFunc(string(value), func(r rune) bool { return !strconv.IsPrint(r) }) {
+		return strconv.Quote(string(value))
+	}
+	return "0x" + hex.EncodeToString(value)
+}
 
-		return newFile
+// decodeXattr parses an attribute value in any of the forms encodeXattr
+// and setfattr(1) use.
+func decodeXattr(value string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(value, "0x"), strings.HasPrefix(value, "0X"):
+		return hex.DecodeString(value[2:])
+	case strings.HasPrefix(value, "0s"), strings.HasPrefix(value, "0S"):
+		return base64.StdEncoding.DecodeString(value[2:])
+	case strings.HasPrefix(value, `"`):
+		text, err := strconv.Unquote(value)
+		return []byte(text), err
 	}
+	return []byte(value), nil
+}
 
-	// Create the copy with the new name
-	newFile := copyFile(sourceFile)
-	newFile.Name = lastComponent
+// Readlink returns the target of the symbolic link name.
+func (s *Shell) Readlink(name string) (string, error) {
+	target, err := s.FS.readLink("readlink", s.abs(name))
+	return target, pathError("readlink", name, err)
+}
 
-	// Add the copy to the destination directory
-	s.Cwd.Children = append(s.Cwd.Children, newFile)
+// hostPrefix marks an archive name as a path on the host rather than a
+// path in the tree. Host files are out of reach of the permission checks
+// of the tree, so only the superuser may name them.
+const hostPrefix = "host:"
+
+// hostPath returns the host path named by an archive name, and whether
+// there is one. It returns fs.ErrPermission, reported against op, if the
+// user of s may not use host paths.
+func (s *Shell) hostPath(op, name string) (string, bool, error) {
+	host, ok := strings.CutPrefix(name, hostPrefix)
+	if ok && s.FS.cred.UID != 0 {
+		return "", false, &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+	}
+	return host, ok, nil
+}
 
-	// Restore original working directory
-	s.Cwd = originalCwd
+// readArchive returns the contents of the archive called name.
+func (s *Shell) readArchive(name string) ([]byte, error) {
+	host, ok, err := s.hostPath("open", name)
+	switch {
+	case err != nil:
+		return nil, err
+	case ok:
+		return os.ReadFile(host)
+	}
+	content, err := s.FS.readFile("open", s.abs(name))
+	return content, pathError("open", name, err)
 }
 
-func (s *Shell) Mkdir(name string, createParents bool) {
-	if name == "" {
-		fmt.Println("Usage: mkdir [-p] <directory_name>")
-		return
+// writeArchive replaces the contents of the archive called name.
+func (s *Shell) writeArchive(name string, data []byte) error {
+	host, ok, err := s.hostPath("open", name)
+	switch {
+	case err != nil:
+		return err
+	case ok:
+		return os.WriteFile(host, data, 0o644)
 	}
+	return pathError("open", name, s.FS.WriteFile(s.abs(name), data))
+}
 
-	// Handle absolute paths
-	if strings.HasPrefix(name, "/") {
-		s.Cwd = s.Root
-		name = name[1:]
-		if name == "" {
-			return
-		}
+// TarCreate writes path and everything below it to a tar archive. The
+// archive is a path in the tree, or on the host if it starts with "host:".
+func (s *Shell) TarCreate(archive, path string) error {
+	if archive == "" || path == "" {
+		return &fs.PathError{Op: "tar", Path: archive, Err: fs.ErrInvalid}
 	}
 
-	components := strings.Split(name, "/")
-	currentDir := s.Cwd
+	var buf bytes.Buffer
+	if err := s.FS.ExportTar(&buf, s.abs(path)); err != nil {
+		return pathError("tar", path, err)
+	}
+	return s.writeArchive(archive, buf.Bytes())
+}
 
-	// Navigate to the target directory
-	for i := 0; i < len(components)-1; i++ {
-		component := components[i]
-		if component == "" {
-			continue
-		}
+// TarExtract extracts a tar archive, named as for TarCreate, into dir.
+func (s *Shell) TarExtract(archive, dir string) error {
+	if archive == "" || dir == "" {
+		return &fs.PathError{Op: "tar", Path: archive, Err: fs.ErrInvalid}
+	}
 
-		if component == ".." {
-			if currentDir.Parent != nil {
-				currentDir = currentDir.Parent
-			}
-			continue
-		}
+	data, err := s.readArchive(archive)
+	if err != nil {
+		return err
+	}
+	return s.FS.ImportTar(bytes.NewReader(data), s.abs(dir))
+}
 
-		found := false
-		for _, child := range currentDir.Children {
-			if child.Name == component && child.IsDirectory {
-				currentDir = child
-				found = true
-				break
-			}
-		}
+// Zip writes path and everything below it to a zip archive, named as for
+// TarCreate.
+func (s *Shell) Zip(archive, path string) error {
+	if archive == "" || path == "" {
+		return &fs.PathError{Op: "zip", Path: archive, Err: fs.ErrInvalid}
+	}
 
-		if !found {
-			if !createParents {
-				fmt.Printf("mkdir: no such directory: %s\n", name)
-				return
-			}
-			// Create the parent directory
-			newDir := &File{
-				Name:        component,
-				IsDirectory: true,
-				CreatedAt:   time.Now(),
-				ModifiedAt:  time.Now(),
-				Parent:      currentDir,
-			}
-			currentDir.Children = append(currentDir.Children, newDir)
-			currentDir = newDir
-		}
+	var buf bytes.Buffer
+	if err := s.FS.ExportZip(&buf, s.abs(path)); err != nil {
+		return pathError("zip", path, err)
 	}
+	return s.writeArchive(archive, buf.Bytes())
+}
 
-	// Get the final component (directory name)
-	dirname := components[len(components)-1]
-	if dirname == "" {
-		return
+// Unzip extracts a zip archive, named as for TarCreate, into dir.
+func (s *Shell) Unzip(archive, dir string) error {
+	if archive == "" || dir == "" {
+		return &fs.PathError{Op: "unzip", Path: archive, Err: fs.ErrInvalid}
 	}
 
-	// Check if directory already exists
-	for _, child := range currentDir.Children {
-		if child.Name == dirname {
-			return
-		}
+	data, err := s.readArchive(archive)
+	if err != nil {
+		return err
 	}
+	return s.FS.ImportZip(bytes.NewReader(data), int64(len(data)), s.abs(dir))
+}
 
-	// Create new directory
-	newDir := &File{
-		Name:        dirname,
-		IsDirectory: true,
-		CreatedAt:   time.Now(),
-		ModifiedAt:  time.Now(),
-		Parent:      currentDir,
+// Mount makes the zip archive in the file name browsable as a read-only
+// directory.
+func (s *Shell) Mount(name string) error {
+	if name == "" {
+		return &fs.PathError{Op: "mount", Path: name, Err: fs.ErrInvalid}
 	}
-	currentDir.Children = append(currentDir.Children, newDir)
+	return pathError("mount", name, s.FS.Mount(s.abs(name)))
 }
 
-func (s *Shell) Touch(name string) {
+// Unmount detaches the archive mounted on the file name.
+func (s *Shell) Unmount(name string) error {
 	if name == "" {
-		fmt.Println("Usage: touch <file_name>")
-		return
+		return &fs.PathError{Op: "unmount", Path: name, Err: fs.ErrInvalid}
 	}
+	return pathError("unmount", name, s.FS.Unmount(s.abs(name)))
+}
 
-	// Handle absolute paths
-	if strings.HasPrefix(name, "/") {
-		s.Cwd = s.Root
-		name = name[1:]
-		if name == "" {
-			return
-		}
+// Chmod changes the mode of name as described by spec, which is either an
+// octal mode such as 0755 or a comma-separated list of symbolic clauses
+// such as u+x,go-w as understood by chmod(1).
+func (s *Shell) Chmod(spec, name string) error {
+	f, err := s.Resolve(name)
+	if err != nil {
+		return pathError("chmod", name, err)
 	}
+	s.FS.mu.RLock()
+	old, isDir := f.Mode, f.IsDirectory
+	s.FS.mu.RUnlock()
 
-	components := strings.Split(name, "/")
-	currentDir := s.Cwd
+	mode, err := parseMode(spec, old, isDir, s.FS.umask)
+	if err != nil {
+		return &fs.PathError{Op: "chmod", Path: spec, Err: err}
+	}
+	return pathError("chmod", name, s.FS.Chmod(s.abs(name), mode))
+}
 
-	// Navigate to the target directory
-	for i := 0; i < len(components)-1; i++ {
-		component := components[i]
-		if component == "" {
-			continue
+// parseMode returns old changed as described by the chmod(1) mode spec.
+// Symbolic clauses without a "who" are limited by umask.
+func parseMode(spec string, old fs.FileMode, isDir bool, umask fs.FileMode) (fs.FileMode, error) {
+	if n, err := strconv.ParseUint(spec, 8, 32); err == nil {
+		if n > 0o7777 {
+			return 0, fs.ErrInvalid
 		}
+		mode := fs.FileMode(n) & fs.ModePerm
+		if n&0o4000 != 0 {
+			mode |= fs.ModeSetuid
+		}
+		if n&0o2000 != 0 {
+			mode |= fs.ModeSetgid
+		}
+		if n&0o1000 != 0 {
+			mode |= fs.ModeSticky
+		}
+		return mode, nil
+	}
 
-		if component == ".." {
-			if currentDir.Parent != nil {
-				currentDir = currentDir.Parent
+	mode := old
+	for _, clause := range strings.Split(spec, ",") {
+		who := strings.TrimLeft(clause, "ugoa")
+		var mask fs.FileMode
+		for _, c := range clause[:len(clause)-len(who)] {
+			switch c {
+			case 'u':
+				mask |= 0o700 | fs.ModeSetuid
+			case 'g':
+				mask |= 0o070 | fs.ModeSetgid
+			case 'o':
+				mask |= 0o007 | fs.ModeSticky
+			case 'a':
+				mask |= modeBits
 			}
-			continue
 		}
-
-		found := false
-		for _, child := range currentDir.Children {
-			if child.Name == component && child.IsDirectory {
-				currentDir = child
-				found = true
-				break
+		if mask == 0 {
+			mask = modeBits &^ umask
+		}
+		if who == "" {
+			return 0, fs.ErrInvalid
+		}
+		for who != "" {
+			op := who[0]
+			if op != '+' && op != '-' && op != '=' {
+				return 0, fs.ErrInvalid
+			}
+			perms := who[1:]
+			who = strings.TrimLeft(perms, "rwxXst")
+			perms = perms[:len(perms)-len(who)]
+
+			var bits fs.FileMode
+			for _, c := range perms {
+				switch c {
+				case 'r':
+					bits |= 0o444
+				case 'w':
+					bits |= 0o222
+				case 'x':
+					bits |= 0o111
+				case 'X':
+					if isDir || mode&0o111 != 0 {
+						bits |= 0o111
+					}
+				case 's':
+					bits |= fs.ModeSetuid | fs.ModeSetgid
+				case 't':
+					bits |= fs.ModeSticky
+				}
+			}
+			bits &= mask
+			switch op {
+			case '+':
+				mode |= bits
+			case '-':
+				mode &^= bits
+			case '=':
+				mode = mode&^mask | bits
 			}
 		}
+	}
+	return mode, nil
+}
 
-		if !found {
-			fmt.Printf("touch: no such directory: %s\n", name)
-			return
-		}
+// userID returns the ID of the user with the given name or number.
+func (s *Shell) userID(op, user string) (int, error) {
+	accounts, err := s.Accounts()
+	if err != nil {
+		return 0, err
+	}
+	if u, err := accounts.LookupUser(user); err == nil {
+		return u.UID, nil
 	}
+	if uid, err := parseID(user); err == nil {
+		return uid, nil
+	}
+	return 0, &fs.PathError{Op: op, Path: user, Err: ErrUnknownUser}
+}
 
-	// Get the final component (file name)
-	filename := components[len(components)-1]
-	if filename == "" {
-		return
+// groupID returns the ID of the group with the given name or number.
+func (s *Shell) groupID(op, group string) (int, error) {
+	accounts, err := s.Accounts()
+	if err != nil {
+		return 0, err
+	}
+	if g, err := accounts.LookupGroup(group); err == nil {
+		return g.GID, nil
+	}
+	if gid, err := parseID(group); err == nil {
+		return gid, nil
 	}
+	return 0, &fs.PathError{Op: op, Path: group, Err: ErrUnknownGroup}
+}
 
-	// Check if file already exists
-	for _, child := range currentDir.Children {
-		if child.Name == filename {
-			// Update modification time if file exists
-			child.ModifiedAt = time.Now()
-			return
+// Chown changes the owner of name, and its group if owner has the form
+// user:group. Users and groups are given by name or number.
+func (s *Shell) Chown(owner, name string) error {
+	user, group, hasGroup := strings.Cut(owner, ":")
+	uid, gid := -1, -1
+	var err error
+	if user != "" {
+		if uid, err = s.userID("chown", user); err != nil {
+			return err
+		}
+	}
+	if hasGroup && group != "" {
+		if gid, err = s.groupID("chown", group); err != nil {
+			return err
 		}
 	}
+	return pathError("chown", name, s.FS.Chown(s.abs(name), uid, gid))
+}
 
-	// Create new file
-	newFile := &File{
-		Name:        filename,
-		IsDirectory: false,
-		CreatedAt:   time.Now(),
-		ModifiedAt:  time.Now(),
-		Parent:      currentDir,
+// Chgrp changes the group of name, given by name or number.
+func (s *Shell) Chgrp(group, name string) error {
+	gid, err := s.groupID("chgrp", group)
+	if err != nil {
+		return err
 	}
-	currentDir.Children = append(currentDir.Children, newFile)
+	return pathError("chgrp", name, s.FS.Chown(s.abs(name), -1, gid))
 }
 
-func (s *Shell) Cat(name string) string {
-	if name == "" {
-		return ""
+// Umask sets the shell's umask to the octal mask in spec, if spec is not
+// empty, and returns the umask in effect afterwards.
+func (s *Shell) Umask(spec string) (fs.FileMode, error) {
+	if spec == "" {
+		mask := s.FS.Umask(0)
+		s.FS.Umask(mask)
+		return mask, nil
 	}
+	n, err := strconv.ParseUint(spec, 8, 32)
+	if err != nil || n > 0o777 {
+		return 0, &fs.PathError{Op: "umask", Path: spec, Err: fs.ErrInvalid}
+	}
+	s.FS.Umask(fs.FileMode(n))
+	return fs.FileMode(n), nil
+}
 
-	// Handle parent directory navigation
-	if strings.HasPrefix(name, "../") {
-		if s.Cwd.Parent == nil {
-			return ""
+// ID describes the user the shell acts for, in the style of id(1).
+func (s *Shell) ID() string {
+	accounts, err := s.Accounts()
+	if err != nil {
+		accounts = &Accounts{}
+	}
+	user := func(uid int) string {
+		if u, err := accounts.LookupUID(uid); err == nil {
+			return fmt.Sprintf("%d(%s)", uid, u.Name)
 		}
-		// Save current directory
-		current := s.Cwd
-		// Move to parent
-		s.Cwd = s.Cwd.Parent
-		// Get content
-		content := s.Cat(strings.TrimPrefix(name, "../"))
-		// Restore current directory
-		s.Cwd = current
-		return content
+		return strconv.Itoa(uid)
+	}
+	group := func(gid int) string {
+		if g, err := accounts.LookupGID(gid); err == nil {
+			return fmt.Sprintf("%d(%s)", gid, g.Name)
+		}
+		return strconv.Itoa(gid)
 	}
 
-	// Find the file
-	for _, child := range s.Cwd.Children {
-		if child.Name == name {
-			if child.IsDirectory {
-				return ""
-			}
-			return string(child.Content)
+	cred := s.FS.Cred()
+	groups := []string{group(cred.GID)}
+	for _, gid := range cred.Groups {
+		if gid != cred.GID {
+			groups = append(groups, group(gid))
 		}
 	}
+	return fmt.Sprintf("uid=%s gid=%s groups=%s", user(cred.UID), group(cred.GID), strings.Join(groups, ","))
+}
 
-	return ""
+// Whoami returns the name of the user the shell acts for.
+func (s *Shell) Whoami() (string, error) {
+	accounts, err := s.Accounts()
+	if err != nil {
+		return "", err
+	}
+	uid := s.FS.Cred().UID
+	u, err := accounts.LookupUID(uid)
+	if err != nil {
+		return "", &fs.PathError{Op: "whoami", Path: strconv.Itoa(uid), Err: err}
+	}
+	return u.Name, nil
 }
 
-func (s *Shell) Find(name string) string {
+// mayBecome reports whether the shell's user may act as another user
+// without a password: the superuser and members of the wheel group may.
+func (s *Shell) mayBecome(accounts *Accounts) bool {
+	cred := s.FS.Cred()
+	if cred.UID == 0 {
+		return true
+	}
+	wheel, err := accounts.LookupGroup(wheelGroup)
+	return err == nil && cred.inGroup(wheel.GID)
+}
+
+// Su makes the shell act for the named user, or for root if name is empty,
+// until Exit. The working directory and umask are kept.
+func (s *Shell) Su(name string) error {
 	if name == "" {
-		return ""
+		name = "root"
+	}
+	accounts, err := s.Accounts()
+	if err != nil {
+		return err
+	}
+	u, err := accounts.LookupUser(name)
+	if err != nil {
+		return &fs.PathError{Op: "su", Path: name, Err: err}
+	}
+	if !s.mayBecome(accounts) {
+		return &fs.PathError{Op: "su", Path: name, Err: fs.ErrPermission}
 	}
+	s.outer = append(s.outer, s.FS)
+	s.FS = s.FS.WithCred(accounts.Cred(u))
+	return nil
+}
 
-	var searchDir func(dir *File, currentPath string) string
-	searchDir = func(dir *File, currentPath string) string {
-		for _, child := range dir.Children {
-			childPath := currentPath + "/" + child.Name
-			if strings.Contains(child.Name, name) {
-				return childPath
-			}
-			if child.IsDirectory {
-				if result := searchDir(child, childPath); result != "" {
-					return result
-				}
-			}
-		}
-		return ""
+// Exit ends the innermost Su, reporting false if there was none and the
+// shell itself should exit.
+func (s *Shell) Exit() bool {
+	if len(s.outer) == 0 {
+		return false
 	}
+	s.FS = s.outer[len(s.outer)-1]
+	s.outer = s.outer[:len(s.outer)-1]
+	return true
+}
 
-	return searchDir(s.Root, "")
+// Sudo calls run with the shell acting for root, then restores the
+// shell's identity, undoing any Su within run.
+func (s *Shell) Sudo(run func()) error {
+	accounts, err := s.Accounts()
+	if err != nil {
+		return err
+	}
+	if !s.mayBecome(accounts) {
+		return &fs.PathError{Op: "sudo", Path: "root", Err: fs.ErrPermission}
+	}
+	var cred Cred
+	if root, err := accounts.LookupUID(0); err == nil {
+		cred = accounts.Cred(root)
+	}
+	view, outer := s.FS, len(s.outer)
+	s.FS = s.FS.WithCred(cred)
+	defer func() {
+		s.FS, s.outer = view, s.outer[:outer]
+	}()
+	run()
+	return nil
 }
 
-func (s *Shell) Remove(name string, recursive bool) {
-	if name == "" {
-		fmt.Println("Usage: remove <name> [recursive]")
-		return
+// Flock calls run while holding a whole-file lock of type typ on name,
+// which is created if it does not exist, as flock(1) does. Without
+// blocking it fails with ErrWouldBlock instead of waiting for the lock.
+func (s *Shell) Flock(name string, typ LockType, blocking bool, run func()) error {
+	h, err := s.FS.OpenFile(s.abs(name), os.O_RDONLY|os.O_CREATE, 0o666)
+	if err != nil {
+		return pathError("flock", name, err)
 	}
+	defer h.Close()
+	if err := h.Lock(typ, blocking); err != nil {
+		return pathError("flock", name, err)
+	}
+	run()
+	return nil
+}
 
-	var target *File
-	var targetIndex int
-	for i, child := range s.Cwd.Children {
-		if child.Name == name {
-			target = child
-			targetIndex = i
-			break
+// Useradd adds a user with a home directory under /home. A negative uid
+// picks the next free one. If group is empty, a group of the same name is
+// created for the user, otherwise group names an existing group by name or
+// number.
+func (s *Shell) Useradd(name string, uid int, group string) error {
+	accounts, err := s.Accounts()
+	if err != nil {
+		return err
+	}
+	if uid < 0 {
+		uid = accounts.NextUID()
+	}
+	u := User{Name: name, UID: uid, Home: "/home/" + name, Shell: "/bin/sh"}
+	if group == "" {
+		u.GID = uid
+		if _, err := accounts.LookupGID(uid); err == nil {
+			u.GID = accounts.NextGID()
 		}
+		if err := accounts.AddGroup(Group{Name: name, GID: u.GID}); err != nil {
+			return &fs.PathError{Op: "useradd", Path: name, Err: err}
+		}
+	} else if u.GID, err = s.groupID("useradd", group); err != nil {
+		return err
 	}
-
-	if target == nil {
-		return
+	if err := accounts.AddUser(u); err != nil {
+		return &fs.PathError{Op: "useradd", Path: name, Err: err}
+	}
+	if err := s.SaveAccounts(accounts); err != nil {
+		return err
 	}
 
-	if target.IsDirectory && len(target.Children) > 0 && !recursive {
-		fmt.Printf("Cannot remove '%s': Directory not empty\n", name)
-		return
+	if err := s.FS.MkdirAll("/home"); err != nil {
+		return err
 	}
+	switch err := s.FS.Mkdir(u.Home); {
+	case errors.Is(err, fs.ErrExist):
+		// Leave an existing home directory as it is.
+		return nil
+	case err != nil:
+		return err
+	}
+	return s.FS.Chown(u.Home, u.UID, u.GID)
+}
 
-	s.Cwd.Children = append(s.Cwd.Children[:targetIndex], s.Cwd.Children[targetIndex+1:]...)
+// Groupadd adds a group. A negative gid picks the next free one.
+func (s *Shell) Groupadd(name string, gid int) error {
+	accounts, err := s.Accounts()
+	if err != nil {
+		return err
+	}
+	if gid < 0 {
+		gid = accounts.NextGID()
+	}
+	if err := accounts.AddGroup(Group{Name: name, GID: gid}); err != nil {
+		return &fs.PathError{Op: "groupadd", Path: name, Err: err}
+	}
+	return s.SaveAccounts(accounts)
 }
 
 func (s *Shell) Clear() {
-	// ANSI escape sequence to clear the screen and move cursor to top-left
-	fmt.Print("\033[H\033[2J")
+	fmt.Print(clearScreen)
 }
 
-func (s *Shell) Run() {
-	scanner := bufio.NewScanner(os.Stdin)
-	for {
-		fmt.Printf("%s> ", s.Pwd())
-		if !scanner.Scan() {
-			break
+// clearScreen is the ANSI escape sequence to clear the screen and move the
+// cursor to the top-left corner.
+const clearScreen = "\033[H\033[2J"
+
+// shellName is the name the shell goes by in $0 and in the diagnostics
+// that come from the shell rather than a command.
+const shellName = "imfs"
+
+// Stdio holds the standard streams of a command.
+type Stdio struct {
+	In  io.Reader
+	Out io.Writer
+	Err io.Writer
+}
+
+// Run reads commands from standard input and runs them until exit or the
+// end of the input, and returns the status of the last command run. When
+// standard input is a terminal, it prompts for each line and reads it with
+// the line editor, keeping the commands entered in the history.
+func (s *Shell) Run() int {
+	out := &lineWriter{w: os.Stdout}
+	stdio := Stdio{In: os.Stdin, Out: out, Err: os.Stderr}
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&fs.ModeCharDevice == 0 {
+		return s.interpret(scanLines(os.Stdin), stdio, false)
+	}
+
+	s.loadHistory()
+	editor := &lineEditor{
+		in:       bufio.NewReader(os.Stdin),
+		out:      os.Stdout,
+		history:  s.History,
+		complete: s.complete,
+	}
+	stdio.In = editor.in
+	read := func(continued bool) (string, error) {
+		if out.partial {
+			fmt.Println()
+			out.partial = false
 		}
-		input := strings.TrimSpace(scanner.Text())
-		parts := strings.SplitN(input, " ", 2)
-		cmd := parts[0]
-		arg := ""
-		if len(parts) > 1 {
-			arg = parts[1]
-		}
-
-		switch cmd {
-		case "exit":
-			return
-		case "ls":
-			s.Ls()
-		case "cd":
-			s.Cd(arg)
-		case "pwd":
-			fmt.Println(s.Pwd())
-		case "mkdir":
-			createParents := false
-			if strings.HasPrefix(arg, "-p ") {
-				createParents = true
-				arg = strings.TrimPrefix(arg, "-p ")
-			}
-			s.Mkdir(arg, createParents)
-		case "touch":
-			s.Touch(arg)
-		case "cat":
-			fmt.Println(s.Cat(arg))
-		case "clear":
-			s.Clear()
-		case "mv":
-			parts := strings.SplitN(arg, " ", 2)
-			if len(parts) == 2 {
-				s.Move(parts[0], parts[1])
-			} else {
-				fmt.Println("Usage: move <source> <destination>")
+		prompt := "> "
+		if !continued {
+			prompt = s.Pwd() + "> "
+		}
+		restore, err := makeRaw(int(os.Stdin.Fd()))
+		if err != nil {
+			// The terminal is left to edit the line itself.
+			fmt.Print(prompt)
+			line, err := editor.in.ReadString('\n')
+			if err != nil && line == "" {
+				return "", err
 			}
-		case "cp":
-			parts := strings.SplitN(arg, " ", 2)
-			if len(parts) == 2 {
-				s.Copy(parts[0], parts[1])
-			} else {
-				fmt.Println("Usage: copy <source> <destination>")
+			return strings.TrimRight(line, "\r\n"), nil
+		}
+		defer restore()
+		return editor.readLine(prompt)
+	}
+	return s.interpret(read, stdio, true)
+}
+
+// Exec runs script, which may hold several lines of commands, until it
+// ends or runs exit, and returns the status of the last command run.
+func (s *Shell) Exec(script string, stdio Stdio) int {
+	return s.interpret(scanLines(strings.NewReader(script)), stdio, false)
+}
+
+// Source runs the commands in the file name in the tree, as Exec does.
+func (s *Shell) Source(name string, stdio Stdio) (int, error) {
+	if s.sourcing == maxSourceDepth {
+		return 0, &fs.PathError{Op: "source", Path: name, Err: ErrLoop}
+	}
+	script, err := s.Cat(name)
+	if err != nil {
+		return 0, pathError("source", name, err)
+	}
+	s.sourcing++
+	defer func() {
+		s.sourcing--
+		s.returning = false
+	}()
+	return s.Exec(script, stdio), nil
+}
+
+// maxSourceDepth limits how deeply scripts may source one another.
+const maxSourceDepth = 64
+
+// interpret reads commands with read, a line at a time, and runs them
+// until exit or the end of the input, returning the status of the last
+// command. read is told whether the line it reads continues a command.
+// An interactive shell expands history references in each line and adds
+// each command to the history.
+func (s *Shell) interpret(read func(continued bool) (string, error), stdio Stdio, interactive bool) int {
+	for !s.exited && !s.returning {
+		l, err := s.readCommand(read, stdio, interactive)
+		var syntaxErr *SyntaxError
+		switch {
+		case err == nil:
+			s.runList(l, stdio)
+		case errors.As(err, &syntaxErr):
+			diagnose(stdio.Err, shellName, err)
+			s.status = 2
+		case errors.Is(err, errNoEvent):
+			diagnose(stdio.Err, shellName, err)
+			s.status = 1
+		case errors.Is(err, errInterrupted):
+			s.status = 130
+		default:
+			return s.status
+		}
+	}
+	return s.status
+}
+
+// readCommand reads lines with read until they make up a whole command,
+// or the input ends partway through one, and returns it parsed. A line
+// that ends partway through a command, such as inside quotes or after |,
+// is joined to the lines after it.
+func (s *Shell) readCommand(read func(continued bool) (string, error), stdio Stdio, interactive bool) (list, error) {
+	var input string
+	var l list
+	var err error
+	for continued := false; ; continued = true {
+		line, readErr := read(continued)
+		if readErr == io.EOF && continued {
+			break
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+		if interactive {
+			expanded, err := s.expandHistory(line)
+			if err != nil {
+				return nil, err
 			}
-		case "find":
-			if result := s.Find(arg); result != "" {
-				fmt.Println(result)
+			if expanded != line {
+				// As in bash, a line with history references is shown
+				// as it will run.
+				fmt.Fprintln(stdio.Out, expanded)
 			}
-		case "rm":
-			parts := strings.SplitN(arg, " ", 2)
-			recursive := false
-			if len(parts) == 2 && parts[1] == "-r" {
-				recursive = true
-				arg = parts[0]
+			line = expanded
+		}
+		if continued {
+			input += "\n"
+		}
+		input += line
+		if l, err = parseLine(input, s.aliases); !incomplete(err) {
+			break
+		}
+	}
+	if interactive {
+		s.addHistory(input)
+	}
+	return l, err
+}
+
+// scanLines returns a function that reads the lines of r in turn, for
+// interpret, and io.EOF at the end of r.
+func scanLines(r io.Reader) func(continued bool) (string, error) {
+	scanner := bufio.NewScanner(r)
+	return func(bool) (string, error) {
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return "", err
 			}
-			s.Remove(arg, recursive)
-		case "write":
-			parts := strings.SplitN(arg, " ", 2)
-			if len(parts) == 2 {
-				s.RedirectWrite(parts[0], parts[1], false)
-			} else {
-				fmt.Println("Usage: write <file> <content>")
+			return "", io.EOF
+		}
+		return strings.TrimSuffix(scanner.Text(), "\r"), nil
+	}
+}
+
+// lineWriter passes writes on to w, noting whether the last one left a
+// line unfinished so that the prompt can start on a line of its own.
+type lineWriter struct {
+	w       io.Writer
+	partial bool
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		lw.partial = p[len(p)-1] != '\n'
+	}
+	return lw.w.Write(p)
+}
+
+// execute runs the commands of input with the given standard streams
+// and returns the status of the last one.
+func (s *Shell) execute(input string, stdio Stdio) int {
+	l, err := parseLine(input, s.aliases)
+	if err != nil {
+		diagnose(stdio.Err, shellName, err)
+		s.status = 2
+		return s.status
+	}
+	return s.runList(l, stdio)
+}
+
+// runList runs the steps of l that their operators call for, returning the
+// status of the last one run, until exit, break, continue or return is
+// run. With set -e, a failing step also ends the shell unless it is
+// negated, && or || follows it, or it is part of a condition.
+func (s *Shell) runList(l list, stdio Stdio) int {
+	for i, st := range l {
+		if s.unwinding() {
+			break
+		}
+		if st.op == "&&" && s.status != 0 || st.op == "||" && s.status == 0 {
+			continue
+		}
+		s.status = s.pipeline(st.cmds, stdio)
+		if st.negate {
+			s.status = boolStatus(s.status != 0)
+		}
+		tested := i+1 < len(l) && l[i+1].op != ";" || st.negate || s.conditions > 0
+		if s.status != 0 && s.errexit && !tested {
+			s.exited = true
+		}
+	}
+	return s.status
+}
+
+// pipeline runs cmds with the output of each one as the input of the
+// next, and returns the status of the last. The commands run one after
+// another, each to completion, so the output of one is held in memory
+// until the next starts.
+func (s *Shell) pipeline(cmds []command, stdio Stdio) int {
+	status := 0
+	in := stdio.In
+	for i, cmd := range cmds {
+		cmdio := Stdio{In: in, Out: stdio.Out, Err: stdio.Err}
+		var piped *bytes.Buffer
+		if i < len(cmds)-1 {
+			piped = new(bytes.Buffer)
+			cmdio.Out = piped
+		}
+		status = s.command(cmd, cmdio)
+		in = piped
+	}
+	return status
+}
+
+// command expands the words of cmd and runs it with its redirections,
+// returning its status. Assignments before the command's name are made
+// for that command only; without a name, they set shell variables. A
+// compound command runs with its redirections too.
+func (s *Shell) command(cmd command, stdio Stdio) int {
+	argv := s.expand(cmd.words, stdio)
+	env := make([]string, len(cmd.assigns))
+	for i, a := range cmd.assigns {
+		env[i] = a.name + "=" + s.expandString(s.tilde(a.value), stdio)
+	}
+	files, err := s.redirect(cmd.redirs, &stdio)
+	defer func() {
+		for _, h := range files {
+			h.Close()
+		}
+	}()
+	if err != nil {
+		diagnose(stdio.Err, shellName, err)
+		return 1
+	}
+
+	if cmd.compound != nil {
+		return s.compound(cmd.compound, stdio)
+	}
+	if len(argv) == 0 {
+		for _, assignment := range env {
+			name, value, _ := strings.Cut(assignment, "=")
+			s.vars[name] = value
+		}
+		return 0
+	}
+	status := 0
+	s.withEnv(env, func() { status = s.run(argv, stdio) })
+	return status
+}
+
+// redirect opens the files named by redirs in the tree and puts them in
+// place of the streams of stdio, returning them for the caller to close.
+// Files written to are created, and truncated unless appended to.
+func (s *Shell) redirect(redirs []redirect, stdio *Stdio) ([]*Handle, error) {
+	var files []*Handle
+	for _, r := range redirs {
+		fields := s.expandWord(r.word, *stdio)
+		if len(fields) != 1 {
+			for _, f := range files {
+				f.Close()
 			}
-		case "append":
-			parts := strings.SplitN(arg, " ", 2)
-			if len(parts) == 2 {
-				s.RedirectWrite(parts[0], parts[1], true)
-			} else {
-				fmt.Println("Usage: append <file> <content>")
+			return nil, fmt.Errorf("%s: ambiguous redirect", r.word.text)
+		}
+		name := fields[0]
+		flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		switch r.op {
+		case "<":
+			flag = os.O_RDONLY
+		case ">>", "2>>":
+			flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		h, err := s.FS.OpenFile(s.abs(name), flag, 0o666)
+		if err != nil {
+			for _, f := range files {
+				f.Close()
 			}
+			return nil, pathError("open", name, err)
+		}
+		files = append(files, h)
+		switch r.op {
+		case "<":
+			stdio.In = h
+		case ">", ">>":
+			stdio.Out = h
 		default:
-			fmt.Println("Unknown command:", cmd)
+			stdio.Err = h
 		}
 	}
+	return files, nil
+}
+
+// run runs the command argv, a function if one has its name or else a
+// registered command, with the given standard streams and returns its
+// status: zero if it succeeded and non-zero if it failed.
+func (s *Shell) run(argv []string, stdio Stdio) int {
+	if body, ok := s.functions[argv[0]]; ok {
+		return s.call(body, argv, stdio)
+	}
+	return s.runCommand(argv, stdio)
 }
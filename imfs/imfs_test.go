@@ -1,7 +1,11 @@
 package imfs
 
 import (
+	"errors"
+	"io"
+	"io/fs"
 	"testing"
+	"testing/fstest"
 )
 
 func assertEqual(t *testing.T, expected, actual interface{}, msg string) {
@@ -227,33 +231,35 @@ func TestCat(t *testing.T) {
 	shell := NewShell()
 
 	// Test reading non-existent file
-	content := shell.Cat("nonexistent.txt")
+	content, err := shell.Cat("nonexistent.txt")
 	assertEqual(t, "", content, "Expected empty string for non-existent file")
+	assertEqual(t, true, errors.Is(err, fs.ErrNotExist), "Expected fs.ErrNotExist for non-existent file")
 
 	// Test reading directory
 	shell.Mkdir("testdir", false)
-	content = shell.Cat("testdir")
+	content, err = shell.Cat("testdir")
 	assertEqual(t, "", content, "Expected empty string when reading directory")
+	assertEqual(t, true, errors.Is(err, ErrIsDir), "Expected ErrIsDir when reading directory")
 
 	// Test reading empty file
 	shell.Touch("empty.txt")
-	content = shell.Cat("empty.txt")
+	content, _ = shell.Cat("empty.txt")
 	assertEqual(t, "", content, "Expected empty string for empty file")
 
 	// Test reading file with content
 	shell.RedirectWrite("test.txt", "Hello, world!", false)
-	content = shell.Cat("test.txt")
+	content, _ = shell.Cat("test.txt")
 	assertEqual(t, "Hello, world!", content, "Expected file content to be 'Hello, world!'")
 
 	// Test reading file in subdirectory
 	shell.Mkdir("subdir", false)
 	shell.Cd("subdir")
 	shell.RedirectWrite("nested.txt", "Nested content", false)
-	content = shell.Cat("nested.txt")
+	content, _ = shell.Cat("nested.txt")
 	assertEqual(t, "Nested content", content, "Expected nested file content to be 'Nested content'")
 
 	// Test reading file from parent directory
-	content = shell.Cat("../test.txt")
+	content, _ = shell.Cat("../test.txt")
 	assertEqual(t, "Hello, world!", content, "Expected to read file from parent directory")
 }
 
@@ -399,10 +405,76 @@ func TestPathResolution(t *testing.T) {
 	assertEqual(t, "/dir1/dir2", shell.Pwd(), "Expected path to be '/dir1/dir2'")
 
 	// Test complex path with mixed absolute, relative and parent references
-	// shell.Cd("/")
-	// shell.Cd("/dir1/./dir2/../dir2/dir3/..")
-	// assertEqual(t, "dir2", shell.Cwd.Name, "Expected to correctly resolve complex path")
-	// assertEqual(t, "/dir1/dir2", shell.Pwd(), "Expected path to be '/dir1/dir2'")
+	shell.Cd("/")
+	shell.Cd("/dir1/./dir2/../dir2/dir3/..")
+	assertEqual(t, "dir2", shell.Cwd.Name, "Expected to correctly resolve complex path")
+	assertEqual(t, "/dir1/dir2", shell.Pwd(), "Expected path to be '/dir1/dir2'")
+
+	// Test parent of root is root
+	shell.Cd("/../..//dir1/")
+	assertEqual(t, "/dir1", shell.Pwd(), "Expected '..' at root to stay at root")
+
+	// Test Resolve and ResolveParent relative to the working directory
+	shell.Touch("dir2/dir3/file.txt")
+	f, err := shell.Resolve("./dir2//dir3/file.txt")
+	assertEqual(t, nil, err, "Expected Resolve to succeed")
+	assertEqual(t, "file.txt", f.Name, "Expected Resolve to find file.txt")
+
+	dir, base, err := shell.ResolveParent("dir2/dir3/../new.txt")
+	assertEqual(t, nil, err, "Expected ResolveParent to succeed")
+	assertEqual(t, "dir2", dir.Name, "Expected parent to be dir2")
+	assertEqual(t, "new.txt", base, "Expected final component to be new.txt")
+
+	_, err = shell.Resolve("dir2/dir3/file.txt/")
+	assertEqual(t, true, errors.Is(err, ErrNotDir), "Expected trailing slash on a file to fail")
+
+	_, _, err = shell.ResolveParent("..")
+	assertEqual(t, true, errors.Is(err, fs.ErrInvalid), "Expected '..' to be rejected as a final component")
+}
+
+func TestPathArguments(t *testing.T) {
+	shell := NewShell()
+	shell.Mkdir("/a/b", true)
+	shell.Mkdir("/x/y", true)
+	shell.RedirectWrite("a/b/c.txt", "nested", false)
+
+	// Test cat with a nested relative path
+	content, err := shell.Cat("a/b/c.txt")
+	assertEqual(t, nil, err, "Expected cat of nested path to succeed")
+	assertEqual(t, "nested", content, "Expected nested content")
+
+	// Test rm with an absolute path from another directory
+	shell.Cd("/a")
+	assertEqual(t, nil, shell.Remove("/x/y", false), "Expected rm of absolute path to succeed")
+	_, err = shell.Resolve("/x/y")
+	assertEqual(t, true, errors.Is(err, fs.ErrNotExist), "Expected /x/y to be removed")
+
+	// Test mv from the parent directory into a directory with a trailing slash
+	shell.RedirectWrite("/x/a", "moved", false)
+	shell.Cd("/x")
+	shell.Mkdir("b", false)
+	shell.Cd("b")
+	assertEqual(t, nil, shell.Move("../a", "../b/"), "Expected mv ../a b/ to succeed")
+	content, _ = shell.Cat("a")
+	assertEqual(t, "moved", content, "Expected file to be moved into b")
+
+	// Test mv of a file onto a missing directory name
+	shell.Touch("f.txt")
+	err = shell.Move("f.txt", "missing/")
+	assertEqual(t, true, errors.Is(err, ErrNotDir), "Expected a file cannot be renamed to a directory name")
+
+	// Test cp into an existing directory keeps the source name
+	shell.Mkdir("dest", false)
+	assertEqual(t, nil, shell.Copy("f.txt", "dest"), "Expected cp into directory to succeed")
+	_, err = shell.Resolve("dest/f.txt")
+	assertEqual(t, nil, err, "Expected copy inside dest")
+
+	// Test writes and appends through paths
+	assertEqual(t, nil, shell.RedirectWrite("/a/b/c.txt", " appended", true), "Expected append by path to succeed")
+	content, _ = shell.Cat("/a/b/c.txt")
+	assertEqual(t, "nested appended", content, "Expected appended content")
+	err = shell.RedirectWrite("new/", "x", false)
+	assertEqual(t, true, errors.Is(err, ErrIsDir), "Expected trailing slash write to fail")
 }
 
 func TestMove(t *testing.T) {
@@ -606,13 +678,106 @@ func TestCopy(t *testing.T) {
 	}
 	assertEqual(t, 2, fileCount, "Expected no new file to be created when destination directory doesn't exist")
 
-	// Test copying a file to its current location (should create a copy)
-	shell.Copy("file1.txt", "file1.txt")
+	// Test copying a file onto an existing name (should fail rather than duplicate)
+	err := shell.Copy("file1.txt", "file1.txt")
+	assertEqual(t, true, errors.Is(err, fs.ErrExist), "Expected fs.ErrExist when copying onto an existing name")
 	fileCount = 0
 	for _, child := range shell.Cwd.Children {
 		if !child.IsDirectory {
 			fileCount++
 		}
 	}
-	assertEqual(t, 3, fileCount, "Expected a new copy to be created when copying to same location")
+	assertEqual(t, 2, fileCount, "Expected no duplicate entry when copying to same location")
+}
+
+func TestErrors(t *testing.T) {
+	shell := NewShell()
+
+	// Test that every failure is reported as an *fs.PathError
+	var pathErr *fs.PathError
+	err := shell.Cd("nonexistent")
+	assertEqual(t, true, errors.As(err, &pathErr), "Expected *fs.PathError from Cd")
+	assertEqual(t, "nonexistent", pathErr.Path, "Expected path to be reported")
+	assertEqual(t, true, errors.Is(err, fs.ErrNotExist), "Expected fs.ErrNotExist from Cd")
+
+	// Test successful operations return nil
+	assertEqual(t, nil, shell.Mkdir("dir", false), "Expected Mkdir to succeed")
+	assertEqual(t, nil, shell.Touch("file.txt"), "Expected Touch to succeed")
+	assertEqual(t, nil, shell.RedirectWrite("file.txt", "content", false), "Expected RedirectWrite to succeed")
+
+	// Test empty names are invalid
+	assertEqual(t, true, errors.Is(shell.Mkdir("", false), fs.ErrInvalid), "Expected fs.ErrInvalid for empty mkdir")
+	assertEqual(t, true, errors.Is(shell.Touch(""), fs.ErrInvalid), "Expected fs.ErrInvalid for empty touch")
+	assertEqual(t, true, errors.Is(shell.Remove("", false), fs.ErrInvalid), "Expected fs.ErrInvalid for empty remove")
+
+	// Test existing targets
+	assertEqual(t, true, errors.Is(shell.Mkdir("dir", false), fs.ErrExist), "Expected fs.ErrExist for duplicate mkdir")
+	assertEqual(t, nil, shell.Mkdir("dir", true), "Expected mkdir -p on existing directory to succeed")
+
+	// Test file used as a directory
+	assertEqual(t, true, errors.Is(shell.Cd("file.txt"), ErrNotDir), "Expected ErrNotDir from Cd into file")
+	assertEqual(t, true, errors.Is(shell.Mkdir("file.txt/sub", true), ErrNotDir), "Expected ErrNotDir from Mkdir under file")
+	assertEqual(t, true, errors.Is(shell.Touch("file.txt/sub"), ErrNotDir), "Expected ErrNotDir from Touch under file")
+	assertEqual(t, true, errors.Is(shell.RedirectWrite("dir", "content", false), ErrIsDir), "Expected ErrIsDir writing to directory")
+
+	// Test missing parents and sources
+	assertEqual(t, true, errors.Is(shell.Mkdir("a/b", false), fs.ErrNotExist), "Expected fs.ErrNotExist without -p")
+	assertEqual(t, true, errors.Is(shell.Move("missing", "dir"), fs.ErrNotExist), "Expected fs.ErrNotExist moving missing source")
+	assertEqual(t, true, errors.Is(shell.Copy("missing", "copy"), fs.ErrNotExist), "Expected fs.ErrNotExist copying missing source")
+	assertEqual(t, true, errors.Is(shell.Remove("missing", false), fs.ErrNotExist), "Expected fs.ErrNotExist removing missing file")
+
+	// Test removing a non-empty directory without recursion
+	shell.Touch("dir/nested.txt")
+	assertEqual(t, true, errors.Is(shell.Remove("dir", false), ErrNotEmpty), "Expected ErrNotEmpty removing non-empty directory")
+	assertEqual(t, nil, shell.Remove("dir", true), "Expected recursive remove to succeed")
+
+	// Test moving onto an existing name
+	shell.Touch("other.txt")
+	assertEqual(t, true, errors.Is(shell.Move("file.txt", "other.txt"), fs.ErrExist), "Expected fs.ErrExist moving onto existing file")
+}
+
+func TestIOFS(t *testing.T) {
+	shell := NewShell()
+	shell.Mkdir("/docs/nested", true)
+	shell.RedirectWrite("/docs/readme.txt", "read me", false)
+	shell.RedirectWrite("/docs/nested/deep.txt", "deep content", false)
+	shell.RedirectWrite("/top.txt", "top", false)
+	shell.Mkdir("/empty", false)
+
+	// Test the tree against the io/fs conformance checks
+	if err := fstest.TestFS(shell.FS, "docs/readme.txt", "docs/nested/deep.txt", "top.txt", "empty"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Test standard helpers work against the tree
+	content, err := fs.ReadFile(shell.FS, "docs/nested/deep.txt")
+	assertEqual(t, nil, err, "Expected fs.ReadFile to succeed")
+	assertEqual(t, "deep content", string(content), "Expected file content via fs.ReadFile")
+
+	matches, err := fs.Glob(shell.FS, "docs/*.txt")
+	assertEqual(t, nil, err, "Expected fs.Glob to succeed")
+	assertEqual(t, 1, len(matches), "Expected one match")
+	assertEqual(t, "docs/readme.txt", matches[0], "Expected readme.txt to match")
+
+	var walked []string
+	fs.WalkDir(shell.FS, ".", func(path string, d fs.DirEntry, err error) error {
+		walked = append(walked, path)
+		return err
+	})
+	assertEqual(t, 7, len(walked), "Expected WalkDir to visit every node")
+	assertEqual(t, "docs/nested/deep.txt", walked[3], "Expected WalkDir to visit in lexical order")
+
+	// Test invalid names are rejected
+	_, err = shell.FS.Open("/top.txt")
+	assertEqual(t, true, errors.Is(err, fs.ErrInvalid), "Expected fs.ErrInvalid for rooted name")
+
+	// Test reading and seeking an open file
+	f, err := shell.FS.Open("top.txt")
+	assertEqual(t, nil, err, "Expected Open to succeed")
+	f.(io.Seeker).Seek(1, io.SeekStart)
+	rest, _ := io.ReadAll(f)
+	assertEqual(t, "op", string(rest), "Expected read after seek")
+	assertEqual(t, nil, f.Close(), "Expected Close to succeed")
+	_, err = f.Read(make([]byte, 1))
+	assertEqual(t, true, errors.Is(err, fs.ErrClosed), "Expected fs.ErrClosed after Close")
 }
@@ -0,0 +1,192 @@
+package imfs
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	_ fs.FS         = (*FS)(nil)
+	_ fs.StatFS     = (*FS)(nil)
+	_ fs.ReadDirFS  = (*FS)(nil)
+	_ fs.ReadFileFS = (*FS)(nil)
+	_ fs.GlobFS     = (*FS)(nil)
+)
+
+// lookupName returns the node for an io/fs name, where "." is the root and
+// names never start or end with a slash. A symbolic link named by the final
+// component is followed if follow is set.
+func (fsys *FS) lookupName(op, name string, follow bool) (*File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	f, err := fsys.walk(fsys.Root, split(name), follow)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return f, nil
+}
+
+// Open opens the named file for reading.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	fsys.mu.RLock()
+	defer fsys.mu.RUnlock()
+
+	f, err := fsys.lookupName("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !fsys.access(f, accessRead) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	f.opens.Add(1)
+	return &Handle{fsys: fsys, f: f, name: name, flag: os.O_RDONLY}, nil
+}
+
+// Stat returns a description of the named file, following symbolic links.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	return fsys.stat("stat", name, true)
+}
+
+// Lstat returns a description of the named file. If it is a symbolic link,
+// the link itself is described.
+func (fsys *FS) Lstat(name string) (fs.FileInfo, error) {
+	return fsys.stat("lstat", name, false)
+}
+
+func (fsys *FS) stat(op, name string, follow bool) (fs.FileInfo, error) {
+	fsys.mu.RLock()
+	defer fsys.mu.RUnlock()
+
+	f, err := fsys.lookupName(op, name, follow)
+	if err != nil {
+		return nil, err
+	}
+	fi := f.info()
+	if name != "." {
+		// A file reached through a link is described by the name used.
+		fi.name = path.Base(name)
+	}
+	return fi, nil
+}
+
+// ReadLink returns the target of the named symbolic link.
+func (fsys *FS) ReadLink(name string) (string, error) {
+	fsys.mu.RLock()
+	defer fsys.mu.RUnlock()
+
+	f, err := fsys.lookupName("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if !f.IsSymlink {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return f.Target, nil
+}
+
+// ReadDir returns the entries of the named directory sorted by name.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	fsys.mu.RLock()
+	defer fsys.mu.RUnlock()
+
+	f, err := fsys.lookupName("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !f.IsDirectory {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: ErrNotDir}
+	}
+	if !fsys.access(f, accessRead) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrPermission}
+	}
+	return f.entries(), nil
+}
+
+// ReadFile returns the contents of the named file.
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	fsys.mu.RLock()
+	defer fsys.mu.RUnlock()
+
+	f, err := fsys.lookupName("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if f.IsDirectory {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: ErrIsDir}
+	}
+	if !fsys.access(f, accessRead) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return bytes.Clone(f.Content), nil
+}
+
+// entries returns the children of dir sorted by name. The caller must hold
+// the FS lock.
+func (dir *File) entries() []fs.DirEntry {
+	entries := make([]fs.DirEntry, 0, len(dir.Children))
+	for _, child := range dir.Children {
+		entries = append(entries, dirEntry{child.info()})
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return entries
+}
+
+// fileInfo is a snapshot of a File's metadata taken under the FS lock, so
+// it can be used after the lock is released.
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	ino     uint64
+	f       *File
+}
+
+// info returns a fileInfo for f. The caller must hold the FS lock.
+func (f *File) info() fileInfo {
+	fi := fileInfo{
+		name:    f.Name,
+		size:    f.Size,
+		mode:    f.Mode,
+		modTime: f.ModifiedAt,
+		ino:     f.Ino,
+		f:       f,
+	}
+	if f.Parent == nil {
+		// The root is called "." in io/fs.
+		fi.name = "."
+	}
+	if f.IsDirectory {
+		fi.mode |= fs.ModeDir
+	}
+	if f.IsSymlink {
+		fi.mode |= fs.ModeSymlink
+	}
+	return fi
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi fileInfo) Sys() any           { return fi.f }
+
+// dirEntry adapts a fileInfo to fs.DirEntry.
+type dirEntry struct {
+	info fileInfo
+}
+
+func (de dirEntry) Name() string               { return de.info.name }
+func (de dirEntry) IsDir() bool                { return de.info.IsDir() }
+func (de dirEntry) Type() fs.FileMode          { return de.info.mode.Type() }
+func (de dirEntry) Info() (fs.FileInfo, error) { return de.info, nil }
+func (de dirEntry) String() string             { return fs.FormatDirEntry(de) }
@@ -0,0 +1,395 @@
+package imfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// ErrBadJournal is returned when a journal record cannot be replayed.
+var ErrBadJournal = errors.New("invalid journal record")
+
+// DefaultJournalLimit is the journal size, in bytes, at which OpenJournal
+// compacts the journal into a new snapshot unless told otherwise.
+const DefaultJournalLimit = 1 << 20
+
+// A journal is a sequence of records, each encoded as
+//
+//	length  uvarint length of the payload
+//	payload seq uvarint, op byte, time, path, dest, off varint, data,
+//	        mode uvarint, uid varint, gid varint, ino uvarint
+//	sum     big-endian CRC-32 (IEEE) of the payload
+//
+// where time is encoded as in snapshots and path, dest and data are
+// uvarint-length byte strings. Paths are canonical absolute paths.
+type opcode byte
+
+const (
+	opMkdir       opcode = iota + 1 // create the directory path with mode, uid, gid and ino
+	opCreate                        // create the empty file path with mode, uid, gid and ino
+	opWriteFile                     // replace the contents of path with data
+	opWrite                         // write data to path at off
+	opTruncate                      // resize path to off bytes
+	opChtimes                       // set the modification time of path
+	opRemove                        // remove path and everything below it
+	opRename                        // move path to dest
+	opCopy                          // copy path to dest, owned by uid and gid with umask mode, numbering inodes from ino
+	opChmod                         // set the mode of path
+	opChown                         // set the owner and group of path
+	opSymlink                       // create path as a symbolic link to dest with uid, gid and ino
+	opLink                          // link dest to the inode of path
+	opSetXattr                      // set the extended attribute dest of path to data
+	opRemoveXattr                   // remove the extended attribute dest of path
+)
+
+// record describes one mutation of the tree. time is the time the
+// mutation was made, so replaying it reproduces the same timestamps.
+type record struct {
+	seq  uint64
+	op   opcode
+	time time.Time
+	path string
+	dest string
+	off  int64
+	data []byte
+	mode fs.FileMode
+	uid  int
+	gid  int
+	ino  uint64
+}
+
+// newFile returns the node created by an opMkdir, opCreate or opSymlink
+// record.
+func (rec record) newFile(name string) *File {
+	f := newFile(name, rec.op == opMkdir, rec.time)
+	f.Mode, f.UID, f.GID, f.Ino = rec.mode, rec.uid, rec.gid, rec.ino
+	if rec.op == opSymlink {
+		f.IsSymlink, f.Target = true, rec.dest
+		f.Size = int64(len(rec.dest))
+	}
+	return f
+}
+
+func appendRecord(buf []byte, rec record) []byte {
+	payload := binary.AppendUvarint(nil, rec.seq)
+	payload = append(payload, byte(rec.op))
+	payload = appendTime(payload, rec.time)
+	payload = appendBytes(payload, []byte(rec.path))
+	payload = appendBytes(payload, []byte(rec.dest))
+	payload = binary.AppendVarint(payload, rec.off)
+	payload = appendBytes(payload, rec.data)
+	payload = binary.AppendUvarint(payload, uint64(rec.mode))
+	payload = binary.AppendVarint(payload, int64(rec.uid))
+	payload = binary.AppendVarint(payload, int64(rec.gid))
+	payload = binary.AppendUvarint(payload, rec.ino)
+	buf = appendBytes(buf, payload)
+	return binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(payload))
+}
+
+// readRecord decodes the record at the start of buf and returns it along
+// with its encoded length. It reports false if buf does not start with a
+// complete, undamaged record.
+func readRecord(buf []byte) (record, int, bool) {
+	d := &decoder{buf: buf}
+	payload := d.bytes()
+	if d.err != nil || len(d.buf) < 4 || crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(d.buf) {
+		return record{}, 0, false
+	}
+	n := len(buf) - len(d.buf) + 4
+
+	d = &decoder{buf: payload}
+	rec := record{
+		seq:  d.uvarint(),
+		op:   opcode(d.byte()),
+		time: d.time(),
+		path: string(d.bytes()),
+		dest: string(d.bytes()),
+		off:  d.varint(),
+		data: d.bytes(),
+		mode: fs.FileMode(d.uvarint()),
+		uid:  int(d.varint()),
+		gid:  int(d.varint()),
+		ino:  d.uvarint(),
+	}
+	if d.err != nil || len(d.buf) != 0 {
+		return record{}, 0, false
+	}
+	return rec, n, true
+}
+
+// journal is an open write-ahead journal, kept in a host file beside the
+// snapshot it extends.
+type journal struct {
+	file     *os.File
+	snapshot string
+	size     int64
+	limit    int64
+}
+
+// OpenJournal loads the snapshot in the host file at path, if there is
+// one, replays the journal kept beside it in path+".journal", and from then
+// on records every mutation in the journal before making it. Once the
+// journal reaches limit bytes (DefaultJournalLimit if limit is not
+// positive) it is compacted: the tree is saved to path and the journal
+// starts over. A record cut short by a crash, and anything after it, is
+// discarded, so the tree is always recovered to a consistent state. Only
+// the superuser may open a journal.
+func (fsys *FS) OpenJournal(path string, limit int64) error {
+	if fsys.cred.UID != 0 {
+		return &fs.PathError{Op: "journal", Path: path, Err: fs.ErrPermission}
+	}
+	if limit <= 0 {
+		limit = DefaultJournalLimit
+	}
+
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	if fsys.journal != nil {
+		return &fs.PathError{Op: "journal", Path: path, Err: fs.ErrExist}
+	}
+	data, err := os.ReadFile(path)
+	missing := errors.Is(err, fs.ErrNotExist)
+	if err != nil && !missing {
+		return err
+	}
+	if err == nil {
+		root, seq, ino, err := decodeSnapshot(data)
+		if err != nil {
+			return &fs.PathError{Op: "load", Path: path, Err: err}
+		}
+		fsys.replace(root, ino)
+		fsys.seq = seq
+	}
+
+	file, err := os.OpenFile(path+".journal", os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	j := &journal{file: file, snapshot: path, limit: limit}
+	if err := j.replay(fsys); err != nil {
+		file.Close()
+		return err
+	}
+	if missing || j.size >= j.limit {
+		if err := j.compact(fsys); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	fsys.journal = j
+	return nil
+}
+
+// CloseJournal compacts the journal into its snapshot and stops recording
+// mutations.
+func (fsys *FS) CloseJournal() error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	j := fsys.journal
+	if j == nil {
+		return nil
+	}
+	fsys.journal = nil
+	err := j.compact(fsys)
+	if cerr := j.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// replay applies the records in the journal that are newer than the tree,
+// stopping at the first damaged or out-of-order record, and truncates the
+// journal after the last good one so new records follow it.
+func (j *journal) replay(fsys *FS) error {
+	data, err := io.ReadAll(j.file)
+	if err != nil {
+		return err
+	}
+	// Records were checked when they were made, so they are replayed with
+	// the superuser's rights.
+	root := &FS{tree: fsys.tree}
+	for j.size < int64(len(data)) {
+		rec, n, ok := readRecord(data[j.size:])
+		if !ok {
+			break
+		}
+		// Records up to the snapshot's sequence number are already in the
+		// tree; they are left behind when a crash interrupts compaction.
+		if rec.seq > fsys.seq {
+			if rec.seq != fsys.seq+1 {
+				break
+			}
+			if err := root.apply(rec); err != nil {
+				return &fs.PathError{Op: "replay", Path: j.file.Name(), Err: err}
+			}
+			fsys.seq = rec.seq
+		}
+		j.size += int64(n)
+	}
+	return j.reset(j.size)
+}
+
+// reset truncates the journal to size bytes and moves the write position
+// to its end.
+func (j *journal) reset(size int64) error {
+	if err := j.file.Truncate(size); err != nil {
+		return err
+	}
+	if _, err := j.file.Seek(size, io.SeekStart); err != nil {
+		return err
+	}
+	j.size = size
+	return nil
+}
+
+// compact saves the tree as the new snapshot and empties the journal. The
+// caller must hold the FS lock.
+func (j *journal) compact(fsys *FS) error {
+	if err := writeFileAtomic(j.snapshot, fsys.encode()); err != nil {
+		return err
+	}
+	return j.reset(0)
+}
+
+// append writes rec to the journal and syncs it, compacting first if the
+// journal has reached its limit. The caller must hold the FS lock for
+// writing.
+func (j *journal) append(fsys *FS, rec record) error {
+	if j.size >= j.limit {
+		// If compaction fails the journal simply keeps growing.
+		j.compact(fsys)
+	}
+	buf := appendRecord(nil, rec)
+	_, err := j.file.Write(buf)
+	if err == nil {
+		err = j.file.Sync()
+	}
+	if err != nil {
+		// Drop whatever part of the record made it to the file.
+		j.reset(j.size)
+		return err
+	}
+	j.size += int64(len(buf))
+	return nil
+}
+
+// log gives rec the next sequence number and appends it to the journal,
+// if one is open. Every mutation calls log before changing the tree and
+// is abandoned if it fails. The caller must hold the FS lock for writing.
+func (fsys *FS) log(rec record) error {
+	rec.seq = fsys.seq + 1
+	if fsys.journal != nil {
+		if err := fsys.journal.append(fsys, rec); err != nil {
+			return err
+		}
+	}
+	fsys.seq = rec.seq
+	return nil
+}
+
+// apply makes the mutation described by rec. The caller must hold the FS
+// lock for writing.
+func (fsys *FS) apply(rec record) error {
+	if rec.op == opMkdir || rec.op == opCreate || rec.op == opSymlink {
+		dir, base, err := fsys.lookupParent("replay", rec.path)
+		if err != nil {
+			return err
+		}
+		if dir.child(base) != nil {
+			return &fs.PathError{Op: "replay", Path: rec.path, Err: fs.ErrExist}
+		}
+		if rec.ino == 0 {
+			return &fs.PathError{Op: "replay", Path: rec.path, Err: ErrBadJournal}
+		}
+		fsys.ino = max(fsys.ino, rec.ino)
+		dir.link(rec.newFile(base), rec.time)
+		return nil
+	}
+
+	// Recorded paths are canonical, so a symbolic link can only be the
+	// final component, and is then what the record is about.
+	f, err := fsys.lookupLink("replay", rec.path)
+	if err != nil {
+		return err
+	}
+	switch rec.op {
+	case opWriteFile, opWrite, opTruncate:
+		if f.IsDirectory {
+			return &fs.PathError{Op: "replay", Path: rec.path, Err: ErrIsDir}
+		}
+		if rec.off < 0 {
+			return &fs.PathError{Op: "replay", Path: rec.path, Err: ErrBadJournal}
+		}
+	case opRemove:
+		if f == fsys.Root {
+			return &fs.PathError{Op: "replay", Path: rec.path, Err: fs.ErrInvalid}
+		}
+	case opLink:
+		if f.IsDirectory {
+			return &fs.PathError{Op: "replay", Path: rec.path, Err: ErrIsDir}
+		}
+	}
+
+	switch rec.op {
+	case opWriteFile:
+		f.setContent(bytes.Clone(rec.data), rec.time)
+	case opWrite:
+		f.writeAt(rec.data, rec.off, rec.time)
+	case opTruncate:
+		f.truncate(rec.off, rec.time)
+	case opChtimes:
+		f.ModifiedAt = rec.time
+	case opChmod:
+		f.Mode = rec.mode & modeBits
+	case opChown:
+		f.UID, f.GID = rec.uid, rec.gid
+	case opSetXattr:
+		f.setXattr(rec.dest, bytes.Clone(rec.data))
+	case opRemoveXattr:
+		delete(f.Xattrs, rec.dest)
+	case opRemove:
+		f.unlink(rec.time)
+		f.release()
+	case opRename, opCopy, opLink:
+		dir, base, err := fsys.lookupParent("replay", rec.dest)
+		if err != nil {
+			return err
+		}
+		if dir.child(base) != nil {
+			return &fs.PathError{Op: "replay", Path: rec.dest, Err: fs.ErrExist}
+		}
+		switch rec.op {
+		case opCopy:
+			next := rec.ino
+			if next == 0 {
+				next = fsys.ino + 1
+			}
+			dup := f.clone(rec.time, rec.uid, rec.gid, rec.mode, &next)
+			dup.Name = base
+			dir.link(dup, rec.time)
+			fsys.ino = max(fsys.ino, next-1)
+			return nil
+		case opLink:
+			dir.link(f.hardlink(base), rec.time)
+			return nil
+		}
+		for p := dir; p != nil; p = p.Parent {
+			if p == f {
+				return &fs.PathError{Op: "replay", Path: rec.dest, Err: fs.ErrInvalid}
+			}
+		}
+		f.unlink(rec.time)
+		f.Name = base
+		dir.link(f, rec.time)
+	default:
+		return &fs.PathError{Op: "replay", Path: rec.path, Err: ErrBadJournal}
+	}
+	return nil
+}
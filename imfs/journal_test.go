@@ -0,0 +1,148 @@
+package imfs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// saved returns the snapshot Save writes for fsys.
+func saved(fsys *FS) []byte {
+	var buf bytes.Buffer
+	fsys.Save(&buf)
+	return buf.Bytes()
+}
+
+func TestJournalRecovery(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.imfs")
+
+	fsys := NewFS()
+	assertEqual(t, nil, fsys.OpenJournal(path, 1<<30), "Expected OpenJournal to succeed")
+	base, err := os.ReadFile(path)
+	assertEqual(t, nil, err, "Expected OpenJournal to write an initial snapshot")
+
+	fsys.MkdirAll("/a/b")
+	fsys.WriteFile("/a/b/file", []byte("hello"))
+	fsys.AppendFile("/a/b/file", []byte(" world"))
+	f, _ := fsys.Create("/a/data")
+	f.WriteString("0123456789")
+	f.WriteAt([]byte("xy"), 12)
+	f.Truncate(4)
+	f.Close()
+	fsys.Rename("/a/b/file", "/a/moved")
+	fsys.Copy("/a", "/copy")
+	fsys.Chtimes("/copy/moved", time.Date(2001, 2, 3, 4, 5, 6, 7, time.UTC))
+	fsys.touch("/copy/new")
+	fsys.Remove("/a/b")
+	fsys.RemoveAll("/copy/b")
+	live := saved(fsys)
+	journal, _ := os.ReadFile(path + ".journal")
+
+	// Work out where each record ends, and the tree after each prefix of
+	// the records.
+	var ends []int
+	for off := 0; off < len(journal); {
+		_, n, ok := readRecord(journal[off:])
+		assertEqual(t, true, ok, "Expected the journal to hold only complete records")
+		off += n
+		ends = append(ends, off)
+	}
+	expected := [][]byte{base}
+	prefix := NewFS()
+	prefix.Load(bytes.NewReader(base))
+	for off := 0; off < len(journal); {
+		rec, n, _ := readRecord(journal[off:])
+		assertEqual(t, nil, prefix.apply(rec), "Expected every record to replay")
+		prefix.seq = rec.seq
+		expected = append(expected, saved(prefix))
+		off += n
+	}
+	assertEqual(t, true, bytes.Equal(live, expected[len(ends)]), "Expected a full replay to rebuild the live tree")
+
+	// Test a journal cut off at every byte recovers to the last complete
+	// record
+	crash := filepath.Join(t.TempDir(), "state.imfs")
+	complete := 0
+	for cut := 0; cut <= len(journal); cut++ {
+		if complete < len(ends) && cut >= ends[complete] {
+			complete++
+		}
+		os.WriteFile(crash, base, 0o644)
+		os.WriteFile(crash+".journal", journal[:cut], 0o644)
+
+		recovered := NewFS()
+		if err := recovered.OpenJournal(crash, 1<<30); err != nil {
+			t.Fatalf("cut at %d: %v", cut, err)
+		}
+		if !bytes.Equal(saved(recovered), expected[complete]) {
+			t.Fatalf("cut at %d: expected the tree after %d records", cut, complete)
+		}
+		info, _ := os.Stat(crash + ".journal")
+		want := 0
+		if complete > 0 {
+			want = ends[complete-1]
+		}
+		assertEqual(t, int64(want), info.Size(), "Expected the torn record to be dropped")
+
+		// Test new records follow the recovered ones
+		recovered.WriteFile("/after", []byte("crash"))
+		recovered.journal.file.Close()
+		recovered.journal = nil
+		again := NewFS()
+		again.OpenJournal(crash, 1<<30)
+		content, err := again.ReadFile("after")
+		assertEqual(t, nil, err, "Expected a record written after recovery to replay")
+		assertEqual(t, "crash", string(content), "Expected a record written after recovery to replay")
+		again.CloseJournal()
+	}
+}
+
+func TestJournalCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.imfs")
+
+	// Test the journal is folded into the snapshot once it reaches its limit
+	fsys := NewFS()
+	fsys.OpenJournal(path, 256)
+	for i := range 50 {
+		fsys.WriteFile("/file", bytes.Repeat([]byte{'x'}, i))
+	}
+	info, _ := os.Stat(path + ".journal")
+	assertEqual(t, true, info.Size() < 512, "Expected the journal to stay near its limit")
+	live := saved(fsys)
+	reopened := NewFS()
+	assertEqual(t, nil, reopened.OpenJournal(path, 256), "Expected reopening to succeed")
+	assertEqual(t, true, bytes.Equal(live, saved(reopened)), "Expected snapshot and journal together to rebuild the tree")
+	reopened.journal.file.Close()
+	reopened.journal = nil
+
+	// Test records already in the snapshot are skipped, as when a crash
+	// interrupts compaction before the journal is emptied
+	fsys.Mkdir("/dir")
+	fsys.Rename("/file", "/dir/file")
+	stale, _ := os.ReadFile(path + ".journal")
+	assertEqual(t, nil, fsys.CloseJournal(), "Expected CloseJournal to succeed")
+	info, _ = os.Stat(path + ".journal")
+	assertEqual(t, int64(0), info.Size(), "Expected CloseJournal to empty the journal")
+	os.WriteFile(path+".journal", stale, 0o644)
+	reopened = NewFS()
+	assertEqual(t, nil, reopened.OpenJournal(path, 256), "Expected stale records to be skipped")
+	assertEqual(t, true, bytes.Equal(saved(fsys), saved(reopened)), "Expected stale records not to change the tree")
+
+	// Test Load replaces the journaled tree
+	other := NewFS()
+	other.WriteFile("/other", []byte("loaded"))
+	assertEqual(t, nil, reopened.Load(bytes.NewReader(saved(other))), "Expected Load to succeed")
+	reopened.WriteFile("/other", []byte("changed"))
+	reopened.journal.file.Close()
+	reopened.journal = nil
+	final := NewFS()
+	final.OpenJournal(path, 256)
+	content, _ := final.ReadFile("other")
+	assertEqual(t, "changed", string(content), "Expected the loaded tree and later changes to persist")
+	_, err := final.Stat("dir")
+	assertEqual(t, true, err != nil, "Expected the old tree to be gone")
+	final.CloseJournal()
+}
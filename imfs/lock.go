@@ -0,0 +1,269 @@
+package imfs
+
+import (
+	"errors"
+	"io/fs"
+	"math"
+	"slices"
+	"sync"
+)
+
+// Errors returned when taking locks.
+var (
+	ErrWouldBlock = errors.New("resource temporarily unavailable")
+	ErrDeadlock   = errors.New("resource deadlock avoided")
+)
+
+// LockType is the kind of an advisory lock.
+type LockType int
+
+const (
+	LockShared    LockType = iota + 1 // may be held by any number of handles at once
+	LockExclusive                     // may be held by one handle only
+)
+
+// Locks are advisory: they keep out other locks, not reads or writes. They
+// belong to a handle and an inode, so hard links share them, and come in
+// two independent kinds as on Linux. Whole-file locks, taken with Lock,
+// are like flock(2); byte-range locks, taken with LockRange, are like
+// fcntl(2) locks, except that they belong to the handle rather than to a
+// process, as open file description locks do.
+//
+// A fileLock is one lock held on an inode. It covers the bytes from start
+// up to but not including end; whole-file locks cover everything.
+type fileLock struct {
+	owner *Handle
+	typ   LockType
+	whole bool
+	start int64
+	end   int64
+}
+
+// conflicts reports whether l and other cannot both be held.
+func (l fileLock) conflicts(other fileLock) bool {
+	return l.owner != other.owner && l.whole == other.whole &&
+		l.start < other.end && other.start < l.end &&
+		(l.typ == LockExclusive || other.typ == LockExclusive)
+}
+
+// lockTable is the lock state of a tree. Its mutex guards the locks of
+// every inode along with the handles waiting for them, and is never held
+// together with the FS lock.
+type lockTable struct {
+	mu      sync.Mutex
+	cond    sync.Cond // broadcast whenever a lock is released
+	waiting map[*Handle]waiter
+}
+
+// A waiter is a handle blocked until it can take lock on n.
+type waiter struct {
+	n    *Inode
+	lock fileLock
+}
+
+// blockers returns the owners of the locks on n that keep l out. The
+// caller must hold the lock table mutex.
+func (n *Inode) blockers(l fileLock) []*Handle {
+	var owners []*Handle
+	for _, held := range n.locks {
+		if held.conflicts(l) && !slices.Contains(owners, held.owner) {
+			owners = append(owners, held.owner)
+		}
+	}
+	return owners
+}
+
+// deadlocks reports whether h waiting for w would complete a cycle of
+// handles each waiting for a lock the next one holds, so that none of them
+// could ever go on. The caller must hold the lock table mutex.
+func (t *lockTable) deadlocks(h *Handle, w waiter) bool {
+	seen := make(map[*Handle]bool)
+	var waitsFor func(w waiter) bool
+	waitsFor = func(w waiter) bool {
+		for _, owner := range w.n.blockers(w.lock) {
+			if owner == h {
+				return true
+			}
+			if seen[owner] {
+				continue
+			}
+			seen[owner] = true
+			if next, ok := t.waiting[owner]; ok && waitsFor(next) {
+				return true
+			}
+		}
+		return false
+	}
+	return waitsFor(w)
+}
+
+// acquire takes l on n for h once nothing keeps it out. Unless blocking
+// is set it fails with ErrWouldBlock instead of waiting, and it fails with
+// ErrDeadlock rather than wait forever.
+func (t *lockTable) acquire(op string, h *Handle, n *Inode, l fileLock, blocking bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	w := waiter{n: n, lock: l}
+	for {
+		switch {
+		case h.unlocked:
+			// Closed while waiting.
+			return &fs.PathError{Op: op, Path: h.name, Err: fs.ErrClosed}
+		case len(n.blockers(l)) == 0:
+			delete(t.waiting, h)
+			n.unlock(h, l.whole, l.start, l.end)
+			n.locks = append(n.locks, l)
+			return nil
+		case !blocking:
+			return &fs.PathError{Op: op, Path: h.name, Err: ErrWouldBlock}
+		case t.deadlocks(h, w):
+			delete(t.waiting, h)
+			return &fs.PathError{Op: op, Path: h.name, Err: ErrDeadlock}
+		}
+		if t.waiting == nil {
+			t.waiting = make(map[*Handle]waiter)
+		}
+		t.waiting[h] = w
+		t.cond.Wait()
+	}
+}
+
+// release drops the locks of h on n of the given kind between start and
+// end, and wakes any handles waiting for them.
+func (t *lockTable) release(h *Handle, n *Inode, whole bool, start, end int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n.unlock(h, whole, start, end)
+	t.cond.Broadcast()
+}
+
+// unlock removes the part between start and end of the locks of the given
+// kind that h holds on n, splitting any that reach beyond it. The caller
+// must hold the lock table mutex.
+func (n *Inode) unlock(h *Handle, whole bool, start, end int64) {
+	var kept []fileLock
+	for _, l := range n.locks {
+		if l.owner != h || l.whole != whole || l.end <= start || end <= l.start {
+			kept = append(kept, l)
+			continue
+		}
+		if l.start < start {
+			before := l
+			before.end = start
+			kept = append(kept, before)
+		}
+		if end < l.end {
+			after := l
+			after.start = end
+			kept = append(kept, after)
+		}
+	}
+	n.locks = kept
+}
+
+// Lock takes a whole-file advisory lock of type typ, as flock(2) does,
+// converting any whole-file lock the handle already holds. If another
+// handle holds a conflicting lock, Lock waits for it to be released, or
+// with blocking unset fails at once with ErrWouldBlock. If waiting would
+// deadlock, Lock fails with ErrDeadlock. Whole-file locks do not interact
+// with byte-range locks.
+func (h *Handle) Lock(typ LockType, blocking bool) error {
+	if err := h.checkLock("flock", typ, false); err != nil {
+		return err
+	}
+	l := fileLock{owner: h, typ: typ, whole: true, end: math.MaxInt64}
+	return h.fsys.locks.acquire("flock", h, h.f.Inode, l, blocking)
+}
+
+// Unlock releases the whole-file lock held by the handle, if any.
+func (h *Handle) Unlock() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return &fs.PathError{Op: "flock", Path: h.name, Err: fs.ErrClosed}
+	}
+	h.fsys.locks.release(h, h.f.Inode, true, 0, math.MaxInt64)
+	return nil
+}
+
+// LockRange takes an advisory lock of type typ on length bytes from start,
+// as fcntl(2) F_SETLK and F_SETLKW do; a length of 0 locks through to the
+// end of the file however far it grows. Any part of the range the handle
+// already holds is converted to typ. A shared lock needs a handle open for
+// reading and an exclusive one a handle open for writing. Conflicts,
+// blocking and deadlocks are handled as by Lock.
+func (h *Handle) LockRange(typ LockType, start, length int64, blocking bool) error {
+	end, err := h.lockRange("fcntl", start, length)
+	if err != nil {
+		return err
+	}
+	if err := h.checkLock("fcntl", typ, true); err != nil {
+		return err
+	}
+	l := fileLock{owner: h, typ: typ, start: start, end: end}
+	return h.fsys.locks.acquire("fcntl", h, h.f.Inode, l, blocking)
+}
+
+// UnlockRange releases the handle's byte-range locks on length bytes from
+// start, where a length of 0 reaches to the end of the file.
+func (h *Handle) UnlockRange(start, length int64) error {
+	end, err := h.lockRange("fcntl", start, length)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return &fs.PathError{Op: "fcntl", Path: h.name, Err: fs.ErrClosed}
+	}
+	h.fsys.locks.release(h, h.f.Inode, false, start, end)
+	return nil
+}
+
+// lockRange returns the end of the range of length bytes from start.
+func (h *Handle) lockRange(op string, start, length int64) (int64, error) {
+	switch {
+	case start < 0 || length < 0:
+		return 0, &fs.PathError{Op: op, Path: h.name, Err: fs.ErrInvalid}
+	case length == 0 || length > math.MaxInt64-start:
+		return math.MaxInt64, nil
+	}
+	return start + length, nil
+}
+
+// checkLock checks that h may take a lock of type typ: that the type is
+// valid and the handle open and, for byte-range locks, that the handle is
+// open for reading (shared) or writing (exclusive).
+func (h *Handle) checkLock(op string, typ LockType, byteRange bool) error {
+	if typ != LockShared && typ != LockExclusive {
+		return &fs.PathError{Op: op, Path: h.name, Err: fs.ErrInvalid}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !byteRange {
+		if h.closed {
+			return &fs.PathError{Op: op, Path: h.name, Err: fs.ErrClosed}
+		}
+		return nil
+	}
+	return h.check(op, typ == LockExclusive)
+}
+
+// releaseAll drops every lock h holds. It is called when h is closed, and
+// stops any Lock or LockRange call waiting on h.
+func (t *lockTable) releaseAll(h *Handle) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h.unlocked = true
+	delete(t.waiting, h)
+	h.f.locks = slices.DeleteFunc(h.f.locks, func(l fileLock) bool { return l.owner == h })
+	t.cond.Broadcast()
+}
@@ -0,0 +1,159 @@
+package imfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFlock(t *testing.T) {
+	fsys := NewFS()
+	fsys.WriteFile("/file", nil)
+	a, _ := fsys.OpenFile("/file", os.O_RDONLY, 0)
+	b, _ := fsys.OpenFile("/file", os.O_RDONLY, 0)
+	defer b.Close()
+
+	// Test shared locks coexist and keep out exclusive ones
+	assertEqual(t, nil, a.Lock(LockShared, false), "Expected a shared lock to succeed")
+	assertEqual(t, nil, b.Lock(LockShared, false), "Expected a second shared lock to succeed")
+	err := b.Lock(LockExclusive, false)
+	assertEqual(t, true, errors.Is(err, ErrWouldBlock), "Expected ErrWouldBlock upgrading past another shared lock")
+
+	// Test hard links share locks
+	fsys.Link("/file", "/other")
+	c, _ := fsys.OpenFile("/other", os.O_RDONLY, 0)
+	defer c.Close()
+	err = c.Lock(LockExclusive, false)
+	assertEqual(t, true, errors.Is(err, ErrWouldBlock), "Expected a lock through a hard link to conflict")
+
+	// Test whole-file locks do not interact with byte-range locks
+	w, _ := fsys.OpenFile("/file", os.O_RDWR, 0)
+	defer w.Close()
+	assertEqual(t, nil, w.LockRange(LockExclusive, 0, 0, false), "Expected a byte-range lock to ignore whole-file locks")
+
+	// Test a blocked lock is granted when the holders let go
+	done := make(chan error)
+	go func() { done <- c.Lock(LockExclusive, true) }()
+	time.Sleep(10 * time.Millisecond)
+	b.Unlock()
+	a.Close()
+	assertEqual(t, nil, <-done, "Expected the blocked lock to be granted once released")
+	err = b.Lock(LockShared, false)
+	assertEqual(t, true, errors.Is(err, ErrWouldBlock), "Expected the exclusive lock to keep out a shared one")
+
+	// Test locks on a closed handle
+	err = a.Lock(LockShared, false)
+	assertEqual(t, true, errors.Is(err, fs.ErrClosed), "Expected fs.ErrClosed locking a closed handle")
+}
+
+func TestLockRange(t *testing.T) {
+	fsys := NewFS()
+	fsys.WriteFile("/file", make([]byte, 100))
+	a, _ := fsys.OpenFile("/file", os.O_RDWR, 0)
+	defer a.Close()
+	b, _ := fsys.OpenFile("/file", os.O_RDWR, 0)
+	defer b.Close()
+
+	// Test only overlapping ranges conflict
+	assertEqual(t, nil, a.LockRange(LockExclusive, 0, 10, false), "Expected a range lock to succeed")
+	assertEqual(t, nil, b.LockRange(LockExclusive, 10, 10, false), "Expected an adjacent range lock to succeed")
+	err := b.LockRange(LockShared, 5, 1, false)
+	assertEqual(t, true, errors.Is(err, ErrWouldBlock), "Expected ErrWouldBlock for an overlapping range")
+
+	// Test unlocking part of a range splits it
+	a.UnlockRange(4, 2)
+	assertEqual(t, nil, b.LockRange(LockShared, 4, 2, false), "Expected the unlocked middle to be free")
+	err = b.LockRange(LockShared, 6, 1, false)
+	assertEqual(t, true, errors.Is(err, ErrWouldBlock), "Expected the rest of the range to stay locked")
+
+	// Test a zero length reaches to the end of the file and beyond
+	b.UnlockRange(0, 0)
+	a.LockRange(LockShared, 50, 0, false)
+	err = b.LockRange(LockExclusive, 1000, 1, false)
+	assertEqual(t, true, errors.Is(err, ErrWouldBlock), "Expected a zero-length lock to cover past the end")
+
+	// Test locks need matching access and valid ranges
+	r, _ := fsys.OpenFile("/file", os.O_RDONLY, 0)
+	defer r.Close()
+	err = r.LockRange(LockExclusive, 0, 1, false)
+	assertEqual(t, true, errors.Is(err, ErrBadHandle), "Expected ErrBadHandle for an exclusive lock on a read-only handle")
+	err = a.LockRange(LockShared, -1, 1, false)
+	assertEqual(t, true, errors.Is(err, fs.ErrInvalid), "Expected fs.ErrInvalid for a negative start")
+
+	// Test Close releases range locks
+	a.Close()
+	assertEqual(t, nil, b.LockRange(LockExclusive, 0, 0, false), "Expected Close to release every lock")
+}
+
+func TestLockDeadlock(t *testing.T) {
+	fsys := NewFS()
+	fsys.WriteFile("/file", make([]byte, 2))
+	a, _ := fsys.OpenFile("/file", os.O_RDWR, 0)
+	defer a.Close()
+	b, _ := fsys.OpenFile("/file", os.O_RDWR, 0)
+	defer b.Close()
+	a.LockRange(LockExclusive, 0, 1, false)
+	b.LockRange(LockExclusive, 1, 1, false)
+
+	// Test the handle that would close a cycle of waiters is refused
+	done := make(chan error)
+	go func() { done <- a.LockRange(LockExclusive, 1, 1, true) }()
+	time.Sleep(10 * time.Millisecond)
+	err := b.LockRange(LockExclusive, 0, 1, true)
+	assertEqual(t, true, errors.Is(err, ErrDeadlock), "Expected ErrDeadlock for a cycle of waiters")
+	b.UnlockRange(1, 1)
+	assertEqual(t, nil, <-done, "Expected the other waiter to go on")
+
+	// Test two shared holders upgrading at once
+	fsys.WriteFile("/other", nil)
+	c, _ := fsys.OpenFile("/other", os.O_RDONLY, 0)
+	d, _ := fsys.OpenFile("/other", os.O_RDONLY, 0)
+	defer d.Close()
+	c.Lock(LockShared, false)
+	d.Lock(LockShared, false)
+	go func() { done <- c.Lock(LockExclusive, true) }()
+	time.Sleep(10 * time.Millisecond)
+	err = d.Lock(LockExclusive, true)
+	assertEqual(t, true, errors.Is(err, ErrDeadlock), "Expected ErrDeadlock for two upgrades")
+	d.Unlock()
+	assertEqual(t, nil, <-done, "Expected the first upgrade to go on")
+	c.Close()
+}
+
+func TestFlockShell(t *testing.T) {
+	fsys := NewFS()
+	fsys.WriteFile("/counter", []byte("0"))
+
+	// Test flock serialises shells sharing a tree
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			shell := NewShellFS(fsys)
+			shell.Flock("/lock", LockExclusive, true, func() {
+				content, _ := shell.Cat("/counter")
+				time.Sleep(time.Millisecond)
+				shell.RedirectWrite("/counter", content+"1", false)
+			})
+		}()
+	}
+	wg.Wait()
+	content, _ := fsys.ReadFile("counter")
+	assertEqual(t, "011111111", string(content), "Expected every update under the lock to be kept")
+
+	// Test flock -n fails while the lock is held
+	shell := NewShellFS(fsys)
+	h, _ := fsys.OpenFile("/lock", os.O_RDONLY, 0)
+	h.Lock(LockShared, false)
+	ran := false
+	err := shell.Flock("/lock", LockExclusive, false, func() { ran = true })
+	assertEqual(t, true, errors.Is(err, ErrWouldBlock), "Expected flock -n to fail while the lock is held")
+	assertEqual(t, false, ran, "Expected the command not to run")
+	assertEqual(t, nil, shell.Flock("/lock", LockShared, false, func() { ran = true }), "Expected a shared flock to succeed")
+	assertEqual(t, true, ran, "Expected the command to run")
+	h.Close()
+}
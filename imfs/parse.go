@@ -0,0 +1,887 @@
+package imfs
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// A SyntaxError reports a command line that cannot be parsed.
+type SyntaxError struct {
+	Msg string
+
+	eof bool // the line ended too soon, and more lines might complete it
+}
+
+func (e *SyntaxError) Error() string {
+	return "syntax error: " + e.Msg
+}
+
+// A token is a word of a command line or, if op is set, an unquoted
+// operator such as | or >. The text of a word has its quotes removed, and
+// its parts say which characters were quoted and where the expansions
+// are. A word with unquoted wildcards or braces and no expansions has a
+// pattern as well, which is the word with its quoted characters escaped
+// by backslashes, ready for globbing.
+type token struct {
+	text    string
+	op      bool
+	pattern string
+	parts   []part
+	aliases []string // the aliases whose values the word came from
+}
+
+// A part is a piece of a word: literal text, or a parameter or command
+// substitution that is expanded when the command runs.
+type part struct {
+	kind   partKind
+	text   string // the literal text, parameter name or command
+	quoted bool   // inside quotes, so neither split into fields nor globbed
+	source string // for expansions, the text that gave rise to it
+
+	// For ${name-word} and ${name:-word}, op is "-" or ":-" and alt holds
+	// the parts of word.
+	op  string
+	alt []part
+}
+
+type partKind int
+
+const (
+	partLiteral partKind = iota
+	partParam            // $name, ${name} or $?
+	partCommand          // $(command)
+)
+
+// expands reports whether any of parts is a substitution.
+func expands(parts []part) bool {
+	for _, p := range parts {
+		if p.kind != partLiteral || expands(p.alt) {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenize breaks a command line into words and operators as a POSIX shell
+// does. Unquoted blanks separate words. Single quotes keep everything up to
+// the next single quote as it is, while double quotes keep everything up
+// to the next unescaped double quote, where a backslash escapes only \, ",
+// $ and ` and substitutions are still made. Outside quotes a backslash
+// escapes any character. A backslash before a newline joins the lines, and
+// a quoted empty string is a word of its own. The operators are ;, &, &&,
+// |, ||, <, >, >>, 2>, 2>>, (, ) and the newline, which need no blanks
+// around them. An unquoted # at the start of a word begins a comment,
+// which runs to the end of the line.
+func tokenize(line string) ([]token, error) {
+	lx := &lexer{line: line}
+	for lx.pos < len(line) {
+		c := line[lx.pos]
+		switch {
+		case c == ' ' || c == '\t':
+			lx.endWord()
+			lx.pos++
+		case strings.IndexByte("\n;&|<>()", c) >= 0:
+			lx.endWord()
+			op := line[lx.pos : lx.pos+1]
+			if strings.IndexByte("&|<>", c) >= 0 && strings.HasPrefix(line[lx.pos+1:], op) {
+				op += op
+			}
+			lx.operator(op)
+		case c == '2' && !lx.inWord && strings.HasPrefix(line[lx.pos:], "2>"):
+			op := "2>"
+			if strings.HasPrefix(line[lx.pos:], "2>>") {
+				op = "2>>"
+			}
+			lx.operator(op)
+		case c == '#' && !lx.inWord:
+			for lx.pos < len(line) && line[lx.pos] != '\n' {
+				lx.pos++
+			}
+		default:
+			if err := lx.wordChar(false); err != nil {
+				return nil, err
+			}
+			lx.inWord = true
+		}
+	}
+	lx.endWord()
+	return lx.tokens, nil
+}
+
+// A lexer holds the state of tokenize.
+type lexer struct {
+	line   string
+	pos    int
+	tokens []token
+
+	inWord    bool
+	parts     []part          // of the word being read
+	run       strings.Builder // literal text not yet added to parts
+	inRun     bool
+	runQuoted bool
+}
+
+func (lx *lexer) operator(op string) {
+	lx.tokens = append(lx.tokens, token{text: op, op: true})
+	lx.pos += len(op)
+}
+
+// literal adds text to the word being read.
+func (lx *lexer) literal(text string, quoted bool) {
+	if lx.inRun && lx.runQuoted != quoted {
+		lx.endRun()
+	}
+	lx.inRun, lx.runQuoted = true, quoted
+	lx.run.WriteString(text)
+}
+
+func (lx *lexer) endRun() {
+	if lx.inRun {
+		lx.parts = append(lx.parts, part{text: lx.run.String(), quoted: lx.runQuoted})
+		lx.run.Reset()
+		lx.inRun = false
+	}
+}
+
+func (lx *lexer) endWord() {
+	if !lx.inWord {
+		return
+	}
+	lx.endRun()
+	t := token{parts: lx.parts}
+	var text, pattern strings.Builder
+	meta := false
+	for _, p := range lx.parts {
+		if p.kind != partLiteral {
+			text.WriteString(p.source)
+			continue
+		}
+		text.WriteString(p.text)
+		if p.quoted {
+			pattern.WriteString(escapeGlob(p.text))
+		} else {
+			pattern.WriteString(p.text)
+			meta = meta || strings.ContainsAny(p.text, "*?[{")
+		}
+	}
+	t.text = text.String()
+	if meta && !expands(lx.parts) {
+		t.pattern = pattern.String()
+	}
+	lx.tokens = append(lx.tokens, t)
+	lx.parts, lx.inWord = nil, false
+}
+
+// wordChar reads the character at lx.pos into the word being read, along
+// with the rest of any quoted string or substitution it begins. If quoted
+// is set, everything counts as quoted.
+func (lx *lexer) wordChar(quoted bool) error {
+	line := lx.line
+	switch c := line[lx.pos]; c {
+	case '\\':
+		if lx.pos+1 < len(line) {
+			lx.pos++
+			if line[lx.pos] != '\n' {
+				lx.literal(line[lx.pos:lx.pos+1], true)
+			}
+		} else {
+			lx.literal(`\`, true)
+		}
+		lx.pos++
+	case '\'':
+		end := strings.IndexByte(line[lx.pos+1:], '\'')
+		if end < 0 {
+			return &SyntaxError{"unterminated quoted string", true}
+		}
+		lx.literal(line[lx.pos+1:lx.pos+1+end], true)
+		lx.pos += end + 2
+	case '"':
+		lx.literal("", true)
+		for lx.pos++; ; {
+			if lx.pos == len(line) {
+				return &SyntaxError{"unterminated quoted string", true}
+			}
+			c := line[lx.pos]
+			switch {
+			case c == '"':
+				lx.pos++
+				return nil
+			case c == '\\' && lx.pos+1 < len(line) && strings.IndexByte("\\\"$`\n", line[lx.pos+1]) >= 0:
+				if line[lx.pos+1] != '\n' {
+					lx.literal(line[lx.pos+1:lx.pos+2], true)
+				}
+				lx.pos += 2
+			case c == '$':
+				if err := lx.dollar(true); err != nil {
+					return err
+				}
+			default:
+				lx.literal(line[lx.pos:lx.pos+1], true)
+				lx.pos++
+			}
+		}
+	case '$':
+		return lx.dollar(quoted)
+	default:
+		lx.literal(line[lx.pos:lx.pos+1], quoted)
+		lx.pos++
+	}
+	return nil
+}
+
+// dollar reads the substitution that starts with the $ at lx.pos: $name,
+// ${name}, ${name-word}, ${name:-word} or $(command), where name may also
+// be a positional parameter ($1, ${10}) or one of the special parameters
+// ?, #, @ and *. A $ that starts none of them stands for itself.
+func (lx *lexer) dollar(quoted bool) error {
+	line, start := lx.line, lx.pos
+	rest := line[start+1:]
+	p := part{kind: partParam, quoted: quoted}
+	switch {
+	case strings.HasPrefix(rest, "("):
+		end := closing(line, start+2, '(', ')')
+		if end < 0 {
+			return &SyntaxError{"unterminated command substitution", true}
+		}
+		p.kind, p.text = partCommand, line[start+2:end]
+		lx.pos = end + 1
+	case strings.HasPrefix(rest, "{"):
+		end := closing(line, start+2, '{', '}')
+		if end < 0 {
+			return &SyntaxError{"unterminated parameter substitution", true}
+		}
+		inner := line[start+2 : end]
+		n := paramLen(inner, true)
+		p.text, inner = inner[:n], inner[n:]
+		for _, op := range []string{":-", "-"} {
+			if n > 0 && strings.HasPrefix(inner, op) {
+				p.op, inner = op, inner[len(op):]
+				break
+			}
+		}
+		if n == 0 || p.op == "" && inner != "" {
+			return &SyntaxError{"bad substitution: " + line[start:end+1], false}
+		}
+		if p.op != "" {
+			alt := &lexer{line: inner}
+			for alt.pos < len(inner) {
+				if err := alt.wordChar(quoted); err != nil {
+					return err
+				}
+			}
+			alt.endRun()
+			p.alt = alt.parts
+		}
+		lx.pos = end + 1
+	case paramLen(rest, false) > 0:
+		p.text = rest[:paramLen(rest, false)]
+		lx.pos += 1 + len(p.text)
+	default:
+		lx.literal("$", quoted)
+		lx.pos++
+		return nil
+	}
+	p.source = line[start:lx.pos]
+	lx.endRun()
+	lx.parts = append(lx.parts, p)
+	return nil
+}
+
+// closing returns the index of the close bracket that matches an open one
+// just before line[start], skipping quoted strings and nested brackets, or
+// -1 if there is none.
+func closing(line string, start int, open, close byte) int {
+	depth := 0
+	for i := start; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return -1
+			}
+			i += end + 1
+		case '"':
+			for i++; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' {
+					i++
+				}
+			}
+		case open:
+			depth++
+		case close:
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// nameLen returns the length of the variable name at the start of s: a
+// letter or underscore followed by letters, digits and underscores.
+func nameLen(s string) int {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9' {
+			continue
+		}
+		return i
+	}
+	return len(s)
+}
+
+// paramLen returns the length of the parameter name at the start of s: a
+// variable name, a special parameter or the number of a positional
+// parameter, which is a single digit unless braced.
+func paramLen(s string, braced bool) int {
+	if n := nameLen(s); n > 0 || s == "" {
+		return n
+	}
+	switch c := s[0]; {
+	case strings.IndexByte("?#@*", c) >= 0:
+		return 1
+	case '0' <= c && c <= '9':
+		n := 1
+		for braced && n < len(s) && '0' <= s[n] && s[n] <= '9' {
+			n++
+		}
+		return n
+	}
+	return 0
+}
+
+// escapeGlob escapes with backslashes the characters of s that glob
+// patterns and brace expansion treat specially.
+func escapeGlob(s string) string {
+	if !strings.ContainsAny(s, `*?[]{},\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(`*?[]{},\`, s[i]) >= 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// A command is one stage of a pipeline: the variable assignments before
+// its words, its words, which are expanded into its arguments when it
+// runs, and the redirections of its standard streams in the order given.
+// A compound command has no assignments or words, but its compound is
+// one of *ifClause, *forClause, *whileClause, *group or *funcDef.
+type command struct {
+	assigns  []assign
+	words    []token
+	redirs   []redirect
+	compound any
+}
+
+// An ifClause is if, then, any number of elif and then, and an optional
+// else: the body after the first condition to succeed runs, or else.
+type ifClause struct {
+	conds  []list
+	bodies []list
+	els    list
+}
+
+// A forClause runs body with the variable name set to each of the words
+// after in, or to each positional parameter if there is no in.
+type forClause struct {
+	name  string
+	in    bool
+	words []token
+	body  list
+}
+
+// A whileClause runs body for as long as cond succeeds or, for until,
+// fails.
+type whileClause struct {
+	cond  list
+	body  list
+	until bool
+}
+
+// A group is a list in braces, run as one command.
+type group struct {
+	body list
+}
+
+// A funcDef is name() followed by a compound command, which defines a
+// function to run as name.
+type funcDef struct {
+	name string
+	body command
+}
+
+// An assign is a NAME=value word before a command.
+type assign struct {
+	name  string
+	value []part
+}
+
+// assignment returns the assignment t makes, if it is one: an unquoted
+// name followed by = and the value.
+func assignment(t token) (assign, bool) {
+	if t.op || len(t.parts) == 0 || t.parts[0].kind != partLiteral || t.parts[0].quoted {
+		return assign{}, false
+	}
+	first := t.parts[0].text
+	n := nameLen(first)
+	if n == 0 || n == len(first) || first[n] != '=' {
+		return assign{}, false
+	}
+	value := append([]part{{text: first[n+1:]}}, t.parts[1:]...)
+	return assign{name: first[:n], value: value}, true
+}
+
+// A redirect sends a standard stream of a command to or from a file. op
+// is one of the redirection operators tokenize recognises, and word names
+// the file.
+type redirect struct {
+	op   string
+	word token
+}
+
+// A list is a sequence of pipelines, each run or skipped according to
+// the operator before it and the status of the one before.
+type list []step
+
+// A step is a pipeline of a list, whose commands are joined by |, and the
+// operator before it: ";" to run it regardless, "&&" to run it only if the
+// last status was zero and "||" only if it was not. A pipeline after !
+// is negated, succeeding if its last command fails and failing if it
+// succeeds.
+type step struct {
+	op     string
+	negate bool
+	cmds   []command
+}
+
+// parse parses tokens as a list, expanding the aliases given in the
+// first word of each simple command.
+func parse(tokens []token, aliases map[string]string) (list, error) {
+	p := &parser{tokens: tokens, aliases: aliases}
+	l, err := p.list()
+	if err == nil && p.pos < len(p.tokens) {
+		err = p.unexpected()
+	}
+	return l, err
+}
+
+// parseLine tokenizes and parses a command line.
+func parseLine(line string, aliases map[string]string) (list, error) {
+	tokens, err := tokenize(line)
+	if err != nil {
+		return nil, err
+	}
+	return parse(tokens, aliases)
+}
+
+// incomplete reports whether err is a syntax error that more lines of
+// input might fix.
+func incomplete(err error) bool {
+	var syntaxErr *SyntaxError
+	return errors.As(err, &syntaxErr) && syntaxErr.eof
+}
+
+// A parser walks through the tokens of a command line.
+type parser struct {
+	tokens  []token
+	pos     int
+	aliases map[string]string
+}
+
+// unexpected returns the error for the token at p.pos, or for running out
+// of tokens after an operator.
+func (p *parser) unexpected() error {
+	if p.pos < len(p.tokens) {
+		text := p.tokens[p.pos].text
+		if text == "\n" {
+			text = "newline"
+		}
+		return &SyntaxError{"unexpected token `" + text + "'", false}
+	}
+	last := len(p.tokens) - 1
+	for last > 0 && p.tokens[last].text == "\n" {
+		last--
+	}
+	return &SyntaxError{"unexpected end of line after `" + p.tokens[last].text + "'", true}
+}
+
+func (p *parser) skipNewlines() {
+	for p.pos < len(p.tokens) && p.tokens[p.pos].op && p.tokens[p.pos].text == "\n" {
+		p.pos++
+	}
+}
+
+// reservedWords are the words that begin and end compound commands.
+var reservedWords = []string{"if", "then", "elif", "else", "fi", "for", "in", "do", "done", "while", "until", "{", "}", "!"}
+
+// at reports whether the token at p.pos is one of words, unquoted.
+func (p *parser) at(words ...string) bool {
+	if p.pos == len(p.tokens) {
+		return false
+	}
+	return plain(p.tokens[p.pos]) && slices.Contains(words, p.tokens[p.pos].text)
+}
+
+// plain reports whether t is a word with no quotes or substitutions.
+func plain(t token) bool {
+	return !t.op && len(t.parts) == 1 && t.parts[0].kind == partLiteral && !t.parts[0].quoted
+}
+
+// expect skips the reserved word w, which must come next.
+func (p *parser) expect(w string) error {
+	if p.at(w) {
+		p.pos++
+		return nil
+	}
+	if p.pos == len(p.tokens) {
+		return &SyntaxError{"unexpected end of line, expecting `" + w + "'", true}
+	}
+	return p.unexpected()
+}
+
+// list parses pipelines separated by ;, &&, || or newlines, up to the end
+// of the tokens or a reserved word in end where a command could begin. A
+// list may end with ;, and newlines may also follow | and the operators
+// that join pipelines.
+func (p *parser) list(end ...string) (list, error) {
+	var l list
+	op := ";"
+	for {
+		p.skipNewlines()
+		if p.pos == len(p.tokens) || p.at(end...) {
+			if op != ";" {
+				return nil, p.unexpected()
+			}
+			return l, nil
+		}
+		st, err := p.pipeline()
+		if err != nil {
+			return nil, err
+		}
+		st.op = op
+		l = append(l, st)
+		if p.pos == len(p.tokens) || p.at(end...) {
+			return l, nil
+		}
+		switch t := p.tokens[p.pos]; t.text {
+		case ";", "\n":
+			op = ";"
+		case "&&", "||":
+			op = t.text
+		default:
+			return nil, p.unexpected()
+		}
+		p.pos++
+	}
+}
+
+// body parses the list of a compound command up to one of the reserved
+// words in end, which must hold at least one command.
+func (p *parser) body(end ...string) (list, error) {
+	l, err := p.list(end...)
+	if err == nil && len(l) == 0 {
+		if p.pos == len(p.tokens) {
+			return nil, &SyntaxError{"unexpected end of line, expecting `" + end[0] + "'", true}
+		}
+		err = p.unexpected()
+	}
+	return l, err
+}
+
+// pipeline parses commands separated by |, after an optional !.
+func (p *parser) pipeline() (step, error) {
+	var st step
+	if p.at("!") {
+		st.negate = true
+		p.pos++
+	}
+	for {
+		cmd, err := p.command()
+		if err != nil {
+			return step{}, err
+		}
+		st.cmds = append(st.cmds, cmd)
+		if p.pos == len(p.tokens) || p.tokens[p.pos].text != "|" {
+			return st, nil
+		}
+		p.pos++
+		p.skipNewlines()
+	}
+}
+
+// command parses one command: a compound command or function definition
+// followed by any redirections, or the assignments, words and
+// redirections of a simple command. Each redirection operator takes the
+// word after it.
+func (p *parser) command() (command, error) {
+	if err := p.expandAliases(); err != nil {
+		return command{}, err
+	}
+	var cmd command
+	var err error
+	switch {
+	case p.at("if"):
+		cmd.compound, err = p.ifClause()
+	case p.at("for"):
+		cmd.compound, err = p.forClause()
+	case p.at("while", "until"):
+		cmd.compound, err = p.whileClause()
+	case p.at("{"):
+		p.pos++
+		var g group
+		if g.body, err = p.body("}"); err == nil {
+			err = p.expect("}")
+		}
+		cmd.compound = &g
+	case p.at(reservedWords...):
+		return command{}, p.unexpected()
+	case p.pos+2 < len(p.tokens) && !p.tokens[p.pos].op && p.tokens[p.pos+1].text == "(" && p.tokens[p.pos+1].op:
+		cmd.compound, err = p.funcDef()
+	}
+	if err != nil {
+		return command{}, err
+	}
+
+	for ; p.pos < len(p.tokens); p.pos++ {
+		t := p.tokens[p.pos]
+		if !t.op && cmd.compound == nil {
+			if a, ok := assignment(t); ok && len(cmd.words) == 0 {
+				cmd.assigns = append(cmd.assigns, a)
+			} else {
+				cmd.words = append(cmd.words, t)
+			}
+			continue
+		}
+		if !t.op || !strings.Contains(t.text, "<") && !strings.Contains(t.text, ">") {
+			break
+		}
+		p.pos++
+		if p.pos == len(p.tokens) || p.tokens[p.pos].op {
+			return command{}, p.unexpected()
+		}
+		cmd.redirs = append(cmd.redirs, redirect{op: t.text, word: p.tokens[p.pos]})
+	}
+	if cmd.compound == nil && len(cmd.assigns) == 0 && len(cmd.words) == 0 && len(cmd.redirs) == 0 {
+		return command{}, p.unexpected()
+	}
+	return cmd, nil
+}
+
+// expandAliases replaces the word at p.pos, if it is an unquoted alias, by
+// the words of its value, over and over until the first word is not an
+// alias or is one whose value it came from.
+func (p *parser) expandAliases() error {
+	for p.pos < len(p.tokens) {
+		t := p.tokens[p.pos]
+		value, ok := p.aliases[t.text]
+		if !ok || !plain(t) || slices.Contains(t.aliases, t.text) {
+			return nil
+		}
+		tokens, err := tokenize(value)
+		if err != nil {
+			return &SyntaxError{"alias " + t.text + ": " + err.(*SyntaxError).Msg, false}
+		}
+		for i := range tokens {
+			tokens[i].aliases = append(slices.Clone(t.aliases), t.text)
+		}
+		p.tokens = slices.Concat(p.tokens[:p.pos], tokens, p.tokens[p.pos+1:])
+		if len(tokens) == 0 {
+			return nil
+		}
+	}
+	return nil
+}
+
+// validAliasName reports whether name can be an alias: a word that needs
+// no quoting and holds no substitutions.
+func validAliasName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, " \t\n'\"\\$`=/;&|<>()")
+}
+
+// ifClause parses if, elif, else and fi and the lists between them.
+func (p *parser) ifClause() (*ifClause, error) {
+	c := new(ifClause)
+	for p.at("if", "elif") {
+		p.pos++
+		cond, err := p.body("then")
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("then"); err != nil {
+			return nil, err
+		}
+		body, err := p.body("elif", "else", "fi")
+		if err != nil {
+			return nil, err
+		}
+		c.conds = append(c.conds, cond)
+		c.bodies = append(c.bodies, body)
+	}
+	if p.at("else") {
+		p.pos++
+		var err error
+		if c.els, err = p.body("fi"); err != nil {
+			return nil, err
+		}
+	}
+	return c, p.expect("fi")
+}
+
+// forClause parses for, the variable name and the words after in, if any,
+// and the body between do and done.
+func (p *parser) forClause() (*forClause, error) {
+	p.pos++
+	if p.pos == len(p.tokens) {
+		return nil, p.unexpected()
+	}
+	c := &forClause{name: p.tokens[p.pos].text}
+	if p.tokens[p.pos].op || !validVarName(c.name) {
+		if p.tokens[p.pos].op {
+			return nil, p.unexpected()
+		}
+		return nil, &SyntaxError{"`" + c.name + "': not a valid identifier", false}
+	}
+	p.pos++
+	p.skipNewlines()
+	if p.at("in") {
+		c.in = true
+		for p.pos++; p.pos < len(p.tokens) && !p.tokens[p.pos].op; p.pos++ {
+			c.words = append(c.words, p.tokens[p.pos])
+		}
+	}
+	if p.pos < len(p.tokens) && (p.tokens[p.pos].text == ";" || p.tokens[p.pos].text == "\n") && p.tokens[p.pos].op {
+		p.pos++
+	}
+	p.skipNewlines()
+	var err error
+	if err = p.expect("do"); err == nil {
+		if c.body, err = p.body("done"); err == nil {
+			err = p.expect("done")
+		}
+	}
+	return c, err
+}
+
+// whileClause parses while or until, the condition, and the body between
+// do and done.
+func (p *parser) whileClause() (*whileClause, error) {
+	c := &whileClause{until: p.at("until")}
+	p.pos++
+	var err error
+	if c.cond, err = p.body("do"); err != nil {
+		return nil, err
+	}
+	if err = p.expect("do"); err == nil {
+		if c.body, err = p.body("done"); err == nil {
+			err = p.expect("done")
+		}
+	}
+	return c, err
+}
+
+// funcDef parses name() and the compound command after it.
+func (p *parser) funcDef() (*funcDef, error) {
+	f := &funcDef{name: p.tokens[p.pos].text}
+	if !plain(p.tokens[p.pos]) || strings.Contains(f.name, "=") {
+		return nil, &SyntaxError{"`" + f.name + "': not a valid identifier", false}
+	}
+	p.pos += 2
+	if p.pos == len(p.tokens) || p.tokens[p.pos].text != ")" || !p.tokens[p.pos].op {
+		return nil, p.unexpected()
+	}
+	p.pos++
+	p.skipNewlines()
+	if p.pos == len(p.tokens) {
+		return nil, &SyntaxError{"unexpected end of line after `)'", true}
+	}
+	if !p.at("{", "if", "for", "while", "until") {
+		return nil, p.unexpected()
+	}
+	var err error
+	f.body, err = p.command()
+	return f, err
+}
+
+// options holds the options given to a command by letter, with the value
+// of those that take one.
+type options map[byte]string
+
+func (o options) has(c byte) bool {
+	_, ok := o[c]
+	return ok
+}
+
+// A usageError reports a command invoked with options or operands it does
+// not take. The shell follows its message, if any, with the command's
+// usage.
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+// getopt separates the options in argv[1:] from the operands, in the style
+// of getopt(3). spec lists the option letters, each followed by ':' if it
+// takes a value, which is either the rest of the argument (-ufoo) or the
+// next argument (-u foo). Options without values may be grouped (-rf). As
+// with GNU getopt, options may come after operands, unless spec starts
+// with '+', when the first operand ends them; "--" always ends them, and a
+// lone "-" is an operand. There must be at least min operands and, unless
+// max is negative, at most max.
+func getopt(argv []string, spec string, min, max int) (options, []string, error) {
+	opts := make(options)
+	var operands []string
+	inOrder := strings.HasPrefix(spec, "+")
+	for i := 1; i < len(argv); i++ {
+		arg := argv[i]
+		if arg == "--" {
+			operands = append(operands, argv[i+1:]...)
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			if inOrder {
+				operands = append(operands, argv[i:]...)
+				break
+			}
+			operands = append(operands, arg)
+			continue
+		}
+		for j := 1; j < len(arg); j++ {
+			c := arg[j]
+			k := strings.IndexByte(spec, c)
+			if k < 0 || c == ':' || c == '+' {
+				return nil, nil, &usageError{fmt.Sprintf("invalid option -- '%c'", c)}
+			}
+			if !strings.HasPrefix(spec[k+1:], ":") {
+				opts[c] = ""
+				continue
+			}
+			switch {
+			case j+1 < len(arg):
+				opts[c] = arg[j+1:]
+			case i+1 < len(argv):
+				i++
+				opts[c] = argv[i]
+			default:
+				return nil, nil, &usageError{fmt.Sprintf("option requires an argument -- '%c'", c)}
+			}
+			break
+		}
+	}
+	if len(operands) < min || (max >= 0 && len(operands) > max) {
+		return nil, nil, &usageError{}
+	}
+	return opts, operands, nil
+}
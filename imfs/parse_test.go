@@ -0,0 +1,289 @@
+package imfs
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`  ls   -i  `, `ls|-i`},
+		{`write f "Hello, World!"`, `write|f|Hello, World!`},
+		{`cat 'my file'`, `cat|my file`},
+		{`cat my\ file`, `cat|my file`},
+		{`echo 'it''s'`, `echo|its`},
+		{`echo "a \"b\" \\ \$ \n"`, `echo|a "b" \ $ \n`},
+		{`echo 'no \escapes "here"'`, `echo|no \escapes "here"`},
+		{`echo "" ''`, `echo||`},
+		{`echo a"b c"'d'`, `echo|ab cd`},
+		{"echo one\\\ntwo", `echo|onetwo`},
+		{`echo tail\`, `echo|tail\`},
+		{`echo hi>f`, `echo|hi|>|f`},
+		{`cat<in>>out 2>err`, `cat|<|in|>>|out|2>|err`},
+		{`ls 2>>log x2>y`, `ls|2>>|log|x2|>|y`},
+		{`a|b '|' ">"`, `a|||b|||>`},
+		{`a||b&&c;d&e # f`, `a||||b|&&|c|;|d|&|e`},
+		{`a#b '#c' #d`, `a#b|#c`},
+	}
+	for _, test := range tests {
+		tokens, err := tokenize(test.line)
+		assertEqual(t, nil, err, "Expected "+test.line+" to parse")
+		words := make([]string, len(tokens))
+		for i, tok := range tokens {
+			words[i] = tok.text
+		}
+		assertEqual(t, test.want, strings.Join(words, "|"), "Expected the words of "+test.line)
+	}
+
+	// Test quoted operators are words
+	tokens, _ := tokenize(`a '|' \>`)
+	assertEqual(t, false, tokens[1].op || tokens[2].op, "Expected quoted operators to be words")
+
+	// Test only unquoted wildcards and braces make patterns
+	tokens, _ = tokenize(`*.txt "my "*.{go,md} '*' \? a`)
+	assertEqual(t, "*.txt", tokens[0].pattern, "Expected an unquoted wildcard to make a pattern")
+	assertEqual(t, `my *.{go,md}`, tokens[1].pattern, "Expected the quoted part to be kept")
+	assertEqual(t, "", tokens[2].pattern+tokens[3].pattern+tokens[4].pattern, "Expected quoted wildcards and plain words not to")
+	tokens, _ = tokenize(`"[x]"*`)
+	assertEqual(t, `\[x\]*`, tokens[0].pattern, "Expected quoted special characters to be escaped")
+
+	// Test unterminated quotes
+	var syntaxErr *SyntaxError
+	_, err := tokenize(`cat "open`)
+	assertEqual(t, true, errors.As(err, &syntaxErr), "Expected a SyntaxError for an unterminated double quote")
+	_, err = tokenize(`cat 'open`)
+	assertEqual(t, true, errors.As(err, &syntaxErr), "Expected a SyntaxError for an unterminated single quote")
+}
+
+func TestParse(t *testing.T) {
+	l, err := parseLine("cat < in | grep -v x > out 2>> err | wc", nil)
+	assertEqual(t, nil, err, "Expected the pipeline to parse")
+	cmds := l[0].cmds
+	assertEqual(t, 3, len(cmds), "Expected three commands")
+	words := make([]string, len(cmds[1].words))
+	for i, w := range cmds[1].words {
+		words[i] = w.text
+	}
+	assertEqual(t, "grep -v x", strings.Join(words, " "), "Expected redirections to be taken out of the words")
+	assertEqual(t, 2, len(cmds[1].redirs), "Expected both redirections of the second command")
+	assertEqual(t, "2>>", cmds[1].redirs[1].op, "Expected the redirections in order")
+	assertEqual(t, "err", cmds[1].redirs[1].word.text, "Expected a redirection to take the next word")
+
+	// Test lists of pipelines
+	l, err = parseLine("a; b && c | d || e;\n\nf &&\n g # h; i", nil)
+	assertEqual(t, nil, err, "Expected the list to parse")
+	ops := make([]string, len(l))
+	for i, st := range l {
+		ops[i] = st.op + st.cmds[0].words[0].text
+	}
+	assertEqual(t, ";a ;b &&c ||e ;f &&g", strings.Join(ops, " "), "Expected each pipeline with the operator before it")
+
+	// Test misplaced operators
+	for _, line := range []string{"| ls", "ls | | wc", "ls >", "ls > | wc", "cat < > f", "; ls", "ls ;; wc", "ls & wc", "ls >\nf"} {
+		_, err := parseLine(line, nil)
+		var syntaxErr *SyntaxError
+		assertEqual(t, true, errors.As(err, &syntaxErr), "Expected a SyntaxError for "+line)
+	}
+
+	// Test lines that more input could complete
+	for _, line := range []string{"ls |", "ls &&", "ls ||\n", `echo "a`} {
+		_, err := parseLine(line, nil)
+		assertEqual(t, true, incomplete(err), "Expected "+line+" to be incomplete")
+	}
+	_, err = parseLine("ls | ;", nil)
+	assertEqual(t, false, incomplete(err), "Expected a misplaced operator to be final")
+}
+
+func TestGetopt(t *testing.T) {
+	// Test options before, after and between operands
+	opts, args, err := getopt([]string{"rm", "a", "-r", "b"}, "rR", 1, -1)
+	assertEqual(t, nil, err, "Expected getopt to succeed")
+	assertEqual(t, true, opts.has('r'), "Expected -r after an operand")
+	assertEqual(t, "a,b", strings.Join(args, ","), "Expected the operands in order")
+
+	// Test grouped options and values
+	opts, args, _ = getopt([]string{"tar", "-cf", "out.tar", "dir"}, "cxf:C:", 0, 1)
+	assertEqual(t, true, opts.has('c'), "Expected -c from a group")
+	assertEqual(t, "out.tar", opts['f'], "Expected -f to take the next argument")
+	assertEqual(t, "dir", strings.Join(args, ","), "Expected the operand")
+	opts, _, _ = getopt([]string{"useradd", "-u1000", "bob"}, "u:g:", 1, 1)
+	assertEqual(t, "1000", opts['u'], "Expected a value attached to its option")
+
+	// Test "--", "-" and '+' end or escape options
+	_, args, _ = getopt([]string{"rm", "--", "-r"}, "r", 1, -1)
+	assertEqual(t, "-r", strings.Join(args, ","), "Expected -- to end options")
+	_, args, _ = getopt([]string{"cat", "-"}, "", 1, -1)
+	assertEqual(t, "-", strings.Join(args, ","), "Expected a lone - to be an operand")
+	_, args, _ = getopt([]string{"sudo", "rm", "-r", "x"}, "+", 1, -1)
+	assertEqual(t, "rm,-r,x", strings.Join(args, ","), "Expected + to stop at the first operand")
+
+	// Test bad options and operand counts
+	var usage *usageError
+	_, _, err = getopt([]string{"rm", "-q", "x"}, "r", 1, -1)
+	assertEqual(t, true, errors.As(err, &usage), "Expected a usage error for an unknown option")
+	assertEqual(t, "invalid option -- 'q'", usage.msg, "Expected the unknown option to be named")
+	_, _, err = getopt([]string{"tar", "-f"}, "f:", 0, 1)
+	assertEqual(t, "option requires an argument -- 'f'", err.Error(), "Expected a missing value to be reported")
+	_, _, err = getopt([]string{"mv", "a"}, "", 2, 2)
+	assertEqual(t, true, errors.As(err, &usage), "Expected a usage error for too few operands")
+}
+
+// runLine runs line in shell with empty standard input, returning what it
+// writes to standard output and standard error.
+func runLine(shell *Shell, line string) (string, string) {
+	var out, errOut bytes.Buffer
+	shell.execute(line, Stdio{In: strings.NewReader(""), Out: &out, Err: &errOut})
+	return out.String(), errOut.String()
+}
+
+func TestShellArgv(t *testing.T) {
+	shell := NewShell()
+
+	// Test quoted names and content
+	runLine(shell, `mkdir "my dir" other`)
+	runLine(shell, `write "my dir/note" "Hello, World!"`)
+	content, _ := shell.Cat("my dir/note")
+	assertEqual(t, "Hello, World!", content, "Expected the quotes to be removed")
+	runLine(shell, `append my\ dir/note ' and  more'`)
+	content, _ = shell.Cat("my dir/note")
+	assertEqual(t, "Hello, World! and  more", content, "Expected quoted blanks to be kept")
+
+	// Test options may come before or after operands
+	runLine(shell, "mkdir -p a/b/c x/y/z")
+	runLine(shell, "rm -r a")
+	_, err := shell.Resolve("a")
+	assertEqual(t, true, err != nil, "Expected rm -r dir to remove the directory")
+	runLine(shell, "rm x -r")
+	_, err = shell.Resolve("x")
+	assertEqual(t, true, err != nil, "Expected rm dir -r to remove the directory")
+
+	// Test commands take several operands
+	runLine(shell, "touch one two three")
+	runLine(shell, "rm one two")
+	_, err = shell.Resolve("three")
+	assertEqual(t, nil, err, "Expected touch to create every file")
+	_, err = shell.Resolve("two")
+	assertEqual(t, true, err != nil, "Expected rm to remove every file")
+
+	// Test modes and content that look like options
+	runLine(shell, "chmod -w three")
+	f, _ := shell.Resolve("three")
+	assertEqual(t, "-r--r--r--", f.Mode.String(), "Expected chmod to take -w as a mode")
+	runLine(shell, "write other/file -n")
+	content, _ = shell.Cat("other/file")
+	assertEqual(t, "-n", content, "Expected write to take -n as content")
+
+	// Test sudo runs a command with its own options
+	runLine(shell, "sudo mkdir -p deep/er")
+	_, err = shell.Resolve("deep/er")
+	assertEqual(t, nil, err, "Expected sudo to pass on the command's options")
+}
+
+func TestPipeline(t *testing.T) {
+	shell := NewShell()
+	shell.RedirectWrite("a.txt", "food\nbar\nfoo bar\n", false)
+
+	// Test a pipeline kept entirely inside the tree
+	out, _ := runLine(shell, "cat a.txt | grep foo > b.txt")
+	assertEqual(t, "", out, "Expected the output to go to the file")
+	content, _ := shell.Cat("b.txt")
+	assertEqual(t, "food\nfoo bar\n", content, "Expected grep to filter the output of cat")
+
+	// Test each stage reads the output of the one before
+	out, _ = runLine(shell, "cat a.txt | grep -v foo | cat")
+	assertEqual(t, "bar\n", out, "Expected three stages to be chained")
+	out, _ = runLine(shell, "echo -n one two | grep -c o")
+	assertEqual(t, "1\n", out, "Expected an unterminated last line to be read")
+
+	// Test grep options and file names
+	out, _ = runLine(shell, "grep -in '^FOO' a.txt b.txt")
+	assertEqual(t, "a.txt:1:food\na.txt:3:foo bar\nb.txt:1:food\nb.txt:2:foo bar\n", out, "Expected matches prefixed by file and line")
+	_, errOut := runLine(shell, "grep '(' a.txt")
+	assertEqual(t, true, errOut != "", "Expected a bad pattern to be reported")
+}
+
+func TestRedirection(t *testing.T) {
+	shell := NewShell()
+
+	// Test > truncates and >> appends
+	runLine(shell, "echo first > out")
+	runLine(shell, "echo second >> out")
+	content, _ := shell.Cat("out")
+	assertEqual(t, "first\nsecond\n", content, "Expected >> to append to the file")
+	runLine(shell, "echo third >out")
+	content, _ = shell.Cat("out")
+	assertEqual(t, "third\n", content, "Expected > to truncate the file")
+
+	// Test < reads standard input from a file
+	out, _ := runLine(shell, "grep ir < out")
+	assertEqual(t, "third\n", out, "Expected < to feed the file to the command")
+	out, _ = runLine(shell, "cat - < out")
+	assertEqual(t, "third\n", out, "Expected cat - to read standard input")
+
+	// Test 2> captures errors and leaves output alone
+	out, errOut := runLine(shell, "cat out missing 2> err")
+	assertEqual(t, "third\n", out, "Expected output to go to standard output")
+	assertEqual(t, "", errOut, "Expected nothing on standard error")
+	content, _ = shell.Cat("err")
+	assertEqual(t, "cat: missing: file does not exist\n", content, "Expected the error in the file")
+
+	// Test redirection alone creates a file, and a missing input stops the command
+	runLine(shell, "> empty")
+	_, err := shell.Resolve("empty")
+	assertEqual(t, nil, err, "Expected > alone to create the file")
+	_, errOut = runLine(shell, "cat < nowhere > created")
+	assertEqual(t, "imfs: nowhere: file does not exist\n", errOut, "Expected the missing input to be reported")
+	_, err = shell.Resolve("created")
+	assertEqual(t, true, err != nil, "Expected redirections after a failed one not to be made")
+}
+
+func TestScript(t *testing.T) {
+	shell := NewShell()
+	var out, errOut bytes.Buffer
+	stdio := Stdio{In: strings.NewReader(""), Out: &out, Err: &errOut}
+
+	// Test sequencing on exit statuses, comments and continued lines
+	status := shell.Exec(`# build a fixture
+mkdir -p data; echo "one
+two" > data/f
+grep -q x data/f 2> errors || echo no x   # grep has no -q
+grep two data/f && echo found |
+  grep -c found
+false && echo skipped
+`, stdio)
+	assertEqual(t, 1, status, "Expected the status of the last command run")
+	assertEqual(t, "no x\ntwo\n1\n", out.String(), "Expected && and || to follow the statuses")
+	assertEqual(t, "", errOut.String(), "Expected no errors")
+
+	// Test set -e stops at a failure that nothing tests
+	out.Reset()
+	status = shell.Exec("set -e\nfalse || echo tested\ncat missing\necho unreached", stdio)
+	assertEqual(t, 1, status, "Expected the status of the failed command")
+	assertEqual(t, "tested\n", out.String(), "Expected set -e to stop the script")
+
+	// Test exit ends the script with its status
+	shell = NewShell()
+	out.Reset()
+	status = shell.Exec("echo a; exit 3; echo b", stdio)
+	assertEqual(t, 3, status, "Expected the status given to exit")
+	assertEqual(t, "a\n", out.String(), "Expected nothing to run after exit")
+
+	// Test source runs a script from the tree in the same shell
+	shell = NewShell()
+	out.Reset()
+	shell.RedirectWrite("lib.imfs", "cd /tmp\necho sourced\n", false)
+	shell.Mkdir("tmp", false)
+	status = shell.Exec("source lib.imfs && pwd", stdio)
+	assertEqual(t, 0, status, "Expected source to succeed")
+	assertEqual(t, "sourced\n/tmp\n", out.String(), "Expected the sourced script to change the shell")
+	errOut.Reset()
+	shell.RedirectWrite("/loop.imfs", ". /loop.imfs", false)
+	status = shell.Exec(". /loop.imfs", stdio)
+	assertEqual(t, 1, status, "Expected endless sourcing to fail")
+	assertEqual(t, true, strings.Contains(errOut.String(), "too many levels"), "Expected endless sourcing to be reported")
+}
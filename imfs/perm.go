@@ -0,0 +1,195 @@
+package imfs
+
+import (
+	"io/fs"
+	"slices"
+)
+
+// Cred identifies the user an FS view acts for. UID 0 is the superuser,
+// who passes every permission check.
+type Cred struct {
+	UID    int
+	GID    int
+	Groups []int // supplementary groups
+}
+
+// inGroup reports whether gid is the primary or a supplementary group of c.
+func (c Cred) inGroup(gid int) bool {
+	return c.GID == gid || slices.Contains(c.Groups, gid)
+}
+
+// modeBits are the parts of a mode that Chmod can change.
+const modeBits = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
+// Permissions that access checks for, as in the "other" bits of a mode.
+const (
+	accessSearch fs.FileMode = 1 << iota
+	accessWrite
+	accessRead
+)
+
+// WithCred returns a view of the same tree that acts for cred. It starts
+// with the umask of fsys.
+func (fsys *FS) WithCred(cred Cred) *FS {
+	return &FS{tree: fsys.tree, cred: cred, umask: fsys.umask}
+}
+
+// Cred returns the user fsys acts for.
+func (fsys *FS) Cred() Cred {
+	return fsys.cred
+}
+
+// Umask sets the permission bits cleared from the mode of new files and
+// directories, returning the previous mask. Like the umask system call it
+// affects only this view, and it must not be called concurrently with
+// operations that create files.
+func (fsys *FS) Umask(mask fs.FileMode) fs.FileMode {
+	old := fsys.umask
+	fsys.umask = mask & fs.ModePerm
+	return old
+}
+
+// access reports whether the user of fsys has every permission in want on
+// f. The caller must hold the FS lock.
+func (fsys *FS) access(f *File, want fs.FileMode) bool {
+	if fsys.cred.UID == 0 {
+		return true
+	}
+	perm := f.Mode.Perm()
+	switch {
+	case f.UID == fsys.cred.UID:
+		perm >>= 6
+	case fsys.cred.inGroup(f.GID):
+		perm >>= 3
+	}
+	return perm&want == want
+}
+
+// permit returns fs.ErrPermission, reported against op, unless the user of
+// fsys has every permission in want on f.
+func (fsys *FS) permit(op string, f *File, want fs.FileMode) error {
+	if !fsys.access(f, want) {
+		return &fs.PathError{Op: op, Path: f.path(), Err: fs.ErrPermission}
+	}
+	return nil
+}
+
+// owns reports whether the user of fsys owns f or is the superuser.
+func (fsys *FS) owns(f *File) bool {
+	return fsys.cred.UID == 0 || fsys.cred.UID == f.UID
+}
+
+// permitUnlink checks that f may be taken out of its directory, which must
+// be writable and searchable. In a sticky directory only the owner of f or
+// of the directory may do so.
+func (fsys *FS) permitUnlink(op string, f *File) error {
+	dir := f.Parent
+	if err := fsys.permit(op, dir, accessWrite|accessSearch); err != nil {
+		return err
+	}
+	if dir.Mode&fs.ModeSticky != 0 && !fsys.owns(f) && !fsys.owns(dir) {
+		return &fs.PathError{Op: op, Path: f.path(), Err: fs.ErrPermission}
+	}
+	return nil
+}
+
+// permitRemoveAll checks that f and everything below it may be removed.
+func (fsys *FS) permitRemoveAll(op string, f *File) error {
+	if err := fsys.permitUnlink(op, f); err != nil {
+		return err
+	}
+	if len(f.Children) == 0 {
+		return nil
+	}
+	if err := fsys.permit(op, f, accessRead|accessWrite|accessSearch); err != nil {
+		return err
+	}
+	for _, child := range f.Children {
+		if err := fsys.permitRemoveAll(op, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// permitRead checks that f and everything below it may be read, as copying
+// or archiving it needs: files must be readable, and directories readable
+// and searchable.
+func (fsys *FS) permitRead(op string, f *File) error {
+	if !f.IsDirectory {
+		return fsys.permit(op, f, accessRead)
+	}
+	if err := fsys.permit(op, f, accessRead|accessSearch); err != nil {
+		return err
+	}
+	for _, child := range f.Children {
+		if err := fsys.permitRead(op, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Chmod changes the mode of the named file to the permission bits of mode,
+// along with fs.ModeSetuid, fs.ModeSetgid and fs.ModeSticky. Only the owner
+// of the file and the superuser may change it.
+func (fsys *FS) Chmod(name string, mode fs.FileMode) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	f, err := fsys.lookup("chmod", name)
+	if err != nil {
+		return err
+	}
+	if !fsys.owns(f) {
+		return &fs.PathError{Op: "chmod", Path: name, Err: fs.ErrPermission}
+	}
+	return fsys.chmod(f, mode&modeBits)
+}
+
+func (fsys *FS) chmod(f *File, mode fs.FileMode) error {
+	if err := f.writable("chmod"); err != nil {
+		return err
+	}
+	path, _ := fsys.pathOf(f)
+	if err := fsys.log(record{op: opChmod, path: path, mode: mode}); err != nil {
+		return err
+	}
+	f.Mode = mode
+	return nil
+}
+
+// Chown changes the owner and group of the named file. A uid or gid of -1
+// leaves that ID unchanged. Only the superuser may give a file away; the
+// owner may change its group to one of their own groups.
+func (fsys *FS) Chown(name string, uid, gid int) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	f, err := fsys.lookup("chown", name)
+	if err != nil {
+		return err
+	}
+	if uid == -1 {
+		uid = f.UID
+	}
+	if gid == -1 {
+		gid = f.GID
+	}
+	if fsys.cred.UID != 0 && (uid != f.UID || !fsys.owns(f) || (gid != f.GID && !fsys.cred.inGroup(gid))) {
+		return &fs.PathError{Op: "chown", Path: name, Err: fs.ErrPermission}
+	}
+	return fsys.chown(f, uid, gid)
+}
+
+func (fsys *FS) chown(f *File, uid, gid int) error {
+	if err := f.writable("chown"); err != nil {
+		return err
+	}
+	path, _ := fsys.pathOf(f)
+	if err := fsys.log(record{op: opChown, path: path, uid: uid, gid: gid}); err != nil {
+		return err
+	}
+	f.UID, f.GID = uid, gid
+	return nil
+}
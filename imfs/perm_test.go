@@ -0,0 +1,276 @@
+package imfs
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPermissionChecks(t *testing.T) {
+	root := NewFS()
+	root.MkdirAll("/home/alice")
+	root.WriteFile("/home/alice/notes", []byte("secret"))
+	root.Chown("/home/alice", 1000, 1000)
+	root.Chown("/home/alice/notes", 1000, 1000)
+	root.Chmod("/home/alice/notes", 0o600)
+
+	alice := root.WithCred(Cred{UID: 1000, GID: 1000})
+	bob := root.WithCred(Cred{UID: 1001, GID: 1001})
+
+	// Test the owner can read and write
+	content, err := alice.ReadFile("home/alice/notes")
+	assertEqual(t, nil, err, "Expected the owner to read a 0600 file")
+	assertEqual(t, "secret", string(content), "Expected the owner to see the content")
+	assertEqual(t, nil, alice.WriteFile("/home/alice/notes", []byte("mine")), "Expected the owner to write a 0600 file")
+
+	// Test others are refused read and write
+	_, err = bob.ReadFile("home/alice/notes")
+	assertEqual(t, true, errors.Is(err, fs.ErrPermission), "Expected fs.ErrPermission reading another user's 0600 file")
+	err = bob.WriteFile("/home/alice/notes", []byte("x"))
+	assertEqual(t, true, errors.Is(err, fs.ErrPermission), "Expected fs.ErrPermission writing another user's 0600 file")
+	_, err = bob.OpenFile("/home/alice/notes", os.O_RDONLY, 0)
+	assertEqual(t, true, errors.Is(err, fs.ErrPermission), "Expected fs.ErrPermission opening another user's 0600 file")
+
+	// Test creating in a directory needs write permission on it
+	err = bob.WriteFile("/home/alice/new", nil)
+	assertEqual(t, true, errors.Is(err, fs.ErrPermission), "Expected fs.ErrPermission creating in another user's directory")
+	err = bob.Remove("/home/alice/notes")
+	assertEqual(t, true, errors.Is(err, fs.ErrPermission), "Expected fs.ErrPermission removing from another user's directory")
+
+	// Test searching a directory needs execute permission
+	root.Chmod("/home/alice", 0o700)
+	_, err = bob.Stat("home/alice/notes")
+	assertEqual(t, true, errors.Is(err, fs.ErrPermission), "Expected fs.ErrPermission passing through a 0700 directory")
+
+	// Test the superuser passes every check
+	content, err = root.ReadFile("home/alice/notes")
+	assertEqual(t, nil, err, "Expected the superuser to read any file")
+	assertEqual(t, "mine", string(content), "Expected the superuser to see the content")
+
+	// Test group permissions apply to supplementary groups
+	root.Chmod("/home/alice", 0o750)
+	root.Chmod("/home/alice/notes", 0o640)
+	carol := root.WithCred(Cred{UID: 1002, GID: 1002, Groups: []int{1000}})
+	_, err = carol.ReadFile("home/alice/notes")
+	assertEqual(t, nil, err, "Expected a group member to read a 0640 file")
+	_, err = bob.ReadFile("home/alice/notes")
+	assertEqual(t, true, errors.Is(err, fs.ErrPermission), "Expected fs.ErrPermission for a non-member")
+}
+
+func TestMountPermissions(t *testing.T) {
+	root := NewFS()
+	root.MkdirAll("/src/inner")
+	root.WriteFile("/src/inner/secret", []byte("secret"))
+	var buf bytes.Buffer
+	root.ExportZip(&buf, "/src")
+	root.WriteFile("/private.zip", buf.Bytes())
+	root.Chown("/private.zip", 1000, 1000)
+	root.Chmod("/private.zip", 0o600)
+
+	alice := root.WithCred(Cred{UID: 1000, GID: 1000})
+	bob := root.WithCred(Cred{UID: 1001, GID: 1001})
+
+	// Test a user who cannot read the archive cannot mount it
+	err := bob.Mount("/private.zip")
+	assertEqual(t, true, errors.Is(err, fs.ErrPermission), "Expected fs.ErrPermission mounting an unreadable archive")
+	_, err = bob.ReadFile("private.zip/src/inner/secret")
+	assertEqual(t, true, errors.Is(err, ErrNotDir), "Expected nothing to be mounted")
+
+	// Test reading is not enough without owning the archive
+	root.Chmod("/private.zip", 0o644)
+	err = bob.Mount("/private.zip")
+	assertEqual(t, true, errors.Is(err, fs.ErrPermission), "Expected fs.ErrPermission mounting another user's archive")
+
+	// Test the owner mounts it, and only they or the superuser unmount it
+	assertEqual(t, nil, alice.Mount("/private.zip"), "Expected the owner to mount the archive")
+	err = bob.Unmount("/private.zip")
+	assertEqual(t, true, errors.Is(err, fs.ErrPermission), "Expected fs.ErrPermission unmounting another user's archive")
+	assertEqual(t, nil, root.Unmount("/private.zip"), "Expected the superuser to unmount any archive")
+}
+
+func TestHostAccessNeedsRoot(t *testing.T) {
+	dir := t.TempDir()
+	root := NewShell()
+	state := filepath.Join(dir, "state")
+	assertEqual(t, nil, root.FS.SaveFile(state), "Expected the superuser to save a snapshot")
+
+	shell := NewShellFS(root.FS.WithCred(Cred{UID: 1000, GID: 1000}))
+	err := shell.FS.LoadFile(state)
+	assertEqual(t, true, errors.Is(err, fs.ErrPermission), "Expected fs.ErrPermission from load")
+	err = shell.FS.SaveFile(filepath.Join(dir, "saved"))
+	assertEqual(t, true, errors.Is(err, fs.ErrPermission), "Expected fs.ErrPermission from save")
+	err = shell.TarCreate("host:"+filepath.Join(dir, "out.tar"), ".")
+	assertEqual(t, true, errors.Is(err, fs.ErrPermission), "Expected fs.ErrPermission from tar -cf to a host path")
+	_, err = os.Stat(filepath.Join(dir, "saved"))
+	assertEqual(t, true, errors.Is(err, fs.ErrNotExist), "Expected save to write nothing")
+	_, err = os.Stat(filepath.Join(dir, "out.tar"))
+	assertEqual(t, true, errors.Is(err, fs.ErrNotExist), "Expected tar to write nothing to the host")
+
+	err = shell.FS.Load(bytes.NewReader(nil))
+	assertEqual(t, true, errors.Is(err, fs.ErrPermission), "Expected fs.ErrPermission from Load")
+}
+
+func TestStickyDirectory(t *testing.T) {
+	root := NewFS()
+	root.Mkdir("/tmp")
+	root.Chmod("/tmp", 0o777|fs.ModeSticky)
+	alice := root.WithCred(Cred{UID: 1000, GID: 1000})
+	bob := root.WithCred(Cred{UID: 1001, GID: 1001})
+
+	alice.WriteFile("/tmp/a", []byte("a"))
+	bob.WriteFile("/tmp/b", []byte("b"))
+
+	// Test users cannot remove or rename each other's files
+	err := bob.Remove("/tmp/a")
+	assertEqual(t, true, errors.Is(err, fs.ErrPermission), "Expected fs.ErrPermission removing another user's file from a sticky directory")
+	err = bob.Rename("/tmp/a", "/tmp/c")
+	assertEqual(t, true, errors.Is(err, fs.ErrPermission), "Expected fs.ErrPermission renaming another user's file in a sticky directory")
+
+	// Test users can remove their own files
+	assertEqual(t, nil, bob.Remove("/tmp/b"), "Expected the owner to remove their file from a sticky directory")
+}
+
+func TestChmodChown(t *testing.T) {
+	root := NewFS()
+	root.WriteFile("/file", nil)
+	root.Chown("/file", 1000, 1000)
+	alice := root.WithCred(Cred{UID: 1000, GID: 1000, Groups: []int{50}})
+	bob := root.WithCred(Cred{UID: 1001, GID: 1001})
+
+	// Test only the owner may change the mode
+	assertEqual(t, nil, alice.Chmod("/file", 0o600), "Expected the owner to chmod")
+	info, _ := root.Stat("file")
+	assertEqual(t, fs.FileMode(0o600), info.Mode(), "Expected the new mode")
+	err := bob.Chmod("/file", 0o666)
+	assertEqual(t, true, errors.Is(err, fs.ErrPermission), "Expected fs.ErrPermission for chmod by another user")
+
+	// Test the owner may give the file to one of their groups but not away
+	assertEqual(t, nil, alice.Chown("/file", -1, 50), "Expected the owner to chgrp to their own group")
+	err = alice.Chown("/file", -1, 60)
+	assertEqual(t, true, errors.Is(err, fs.ErrPermission), "Expected fs.ErrPermission for chgrp to a foreign group")
+	err = alice.Chown("/file", 1001, -1)
+	assertEqual(t, true, errors.Is(err, fs.ErrPermission), "Expected fs.ErrPermission for giving a file away")
+	assertEqual(t, nil, root.Chown("/file", 1001, -1), "Expected the superuser to chown")
+
+	f, _ := root.Resolve("/file")
+	assertEqual(t, 1001, f.UID, "Expected the new owner")
+	assertEqual(t, 50, f.GID, "Expected the group to be unchanged")
+}
+
+func TestUmask(t *testing.T) {
+	fsys := NewFS()
+
+	// Test the default umask
+	fsys.WriteFile("/file", nil)
+	fsys.Mkdir("/dir")
+	info, _ := fsys.Stat("file")
+	assertEqual(t, fs.FileMode(0o644), info.Mode(), "Expected files to default to 0644")
+	info, _ = fsys.Stat("dir")
+	assertEqual(t, fs.ModeDir|0o755, info.Mode(), "Expected directories to default to 0755")
+
+	// Test a stricter umask and the OpenFile creation mode
+	old := fsys.Umask(0o077)
+	assertEqual(t, fs.FileMode(0o022), old, "Expected Umask to return the previous mask")
+	f, _ := fsys.OpenFile("/private", os.O_CREATE|os.O_WRONLY, 0o666)
+	f.Close()
+	info, _ = fsys.Stat("private")
+	assertEqual(t, fs.FileMode(0o600), info.Mode(), "Expected the umask to clear group and other bits")
+
+	// Test new files belong to the creating user
+	alice := fsys.WithCred(Cred{UID: 1000, GID: 100})
+	fsys.Chmod("/dir", 0o777)
+	alice.WriteFile("/dir/owned", nil)
+	owned, _ := fsys.Resolve("/dir/owned")
+	assertEqual(t, 1000, owned.UID, "Expected the creator to own the file")
+	assertEqual(t, 100, owned.GID, "Expected the creator's group")
+}
+
+func TestPermissionsPersist(t *testing.T) {
+	fsys := NewFS()
+	fsys.WriteFile("/file", []byte("data"))
+	fsys.Chmod("/file", 0o640|fs.ModeSetgid)
+	fsys.Chown("/file", 1000, 50)
+
+	// Test a snapshot keeps mode and ownership
+	var buf bytes.Buffer
+	fsys.Save(&buf)
+	loaded := NewFS()
+	assertEqual(t, nil, loaded.Load(&buf), "Expected Load to succeed")
+	f, _ := loaded.Resolve("/file")
+	assertEqual(t, 0o640|fs.ModeSetgid, f.Mode, "Expected the mode to survive a snapshot")
+	assertEqual(t, 1000, f.UID, "Expected the owner to survive a snapshot")
+	assertEqual(t, 50, f.GID, "Expected the group to survive a snapshot")
+
+	// Test the journal replays chmod and chown
+	state := t.TempDir() + "/state"
+	journaled := NewFS()
+	journaled.OpenJournal(state, DefaultJournalLimit)
+	journaled.WriteFile("/file", nil)
+	journaled.Chmod("/file", 0o600)
+	journaled.Chown("/file", 7, 8)
+	journaled.journal.file.Close()
+
+	recovered := NewFS()
+	assertEqual(t, nil, recovered.OpenJournal(state, DefaultJournalLimit), "Expected recovery to succeed")
+	defer recovered.CloseJournal()
+	f, _ = recovered.Resolve("/file")
+	assertEqual(t, fs.FileMode(0o600), f.Mode, "Expected the journal to replay chmod")
+	assertEqual(t, 7, f.UID, "Expected the journal to replay chown")
+	assertEqual(t, 8, f.GID, "Expected the journal to replay chown's group")
+}
+
+func TestPermissionShell(t *testing.T) {
+	shell := NewShell()
+	shell.Touch("script")
+
+	// Test octal and symbolic modes
+	assertEqual(t, nil, shell.Chmod("750", "script"), "Expected octal chmod to succeed")
+	f, _ := shell.Resolve("script")
+	assertEqual(t, fs.FileMode(0o750), f.Mode, "Expected the octal mode")
+	shell.Chmod("g-x,o+r", "script")
+	assertEqual(t, fs.FileMode(0o744), f.Mode, "Expected clauses to apply in order")
+	shell.Chmod("a=rw", "script")
+	assertEqual(t, fs.FileMode(0o666), f.Mode, "Expected = to replace the bits")
+	shell.Chmod("-w", "script")
+	assertEqual(t, fs.FileMode(0o466), f.Mode, "Expected a bare clause to respect the umask")
+	shell.Chmod("u+s,+t", "script")
+	assertEqual(t, 0o466|fs.ModeSetuid|fs.ModeSticky, f.Mode, "Expected setuid and sticky bits")
+	err := shell.Chmod("u+q", "script")
+	assertEqual(t, true, errors.Is(err, fs.ErrInvalid), "Expected fs.ErrInvalid for a bad mode")
+
+	// Test chown and chgrp
+	assertEqual(t, nil, shell.Chown("1000:100", "script"), "Expected chown to succeed")
+	assertEqual(t, 1000, f.UID, "Expected the new owner")
+	assertEqual(t, 100, f.GID, "Expected the new group")
+	shell.Chgrp("200", "script")
+	assertEqual(t, 200, f.GID, "Expected chgrp to change the group")
+
+	// Test refused moves and copies name the path that was refused
+	shell.Mkdir("/src", false)
+	shell.Touch("/src/secret")
+	shell.Chmod("600", "/src/secret")
+	shell.Mkdir("/home", false)
+	shell.Mkdir("/home/bob", false)
+	shell.Chown("1001", "/home/bob")
+	bob := NewShellFS(shell.FS.WithCred(Cred{UID: 1001, GID: 1001}))
+	bob.Cd("/home/bob")
+	var pathErr *fs.PathError
+	err = bob.Copy("../../src/secret", "copy")
+	assertEqual(t, true, errors.As(err, &pathErr) && errors.Is(err, fs.ErrPermission), "Expected cp of an unreadable file to be refused")
+	assertEqual(t, "../../src/secret", pathErr.Path, "Expected cp to name the source as given")
+	err = bob.Move("../../src/secret", "moved")
+	assertEqual(t, true, errors.As(err, &pathErr) && errors.Is(err, fs.ErrPermission), "Expected mv out of an unwritable directory to be refused")
+	assertEqual(t, "/src", pathErr.Path, "Expected mv to name the unwritable directory")
+
+	// Test umask is per shell
+	other := NewShellFS(shell.FS)
+	mask, _ := shell.Umask("077")
+	assertEqual(t, fs.FileMode(0o077), mask, "Expected the new umask")
+	mask, _ = other.Umask("")
+	assertEqual(t, fs.FileMode(0o022), mask, "Expected another shell's umask to be unaffected")
+
+	assertEqual(t, "uid=0(root) gid=0(root) groups=0(root),10(wheel)", shell.ID(), "Expected id to describe the superuser")
+}
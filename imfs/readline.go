@@ -0,0 +1,437 @@
+package imfs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// errInterrupted is returned by the line editor for a line abandoned with
+// Ctrl-C.
+var errInterrupted = errors.New("interrupted")
+
+// A lineEditor reads lines typed at a terminal in raw mode, echoing and
+// editing them itself. Besides typing and deleting, it takes the keys of
+// readline's emacs mode: the arrow keys and Ctrl-B, Ctrl-F, Ctrl-A and
+// Ctrl-E move the cursor, Ctrl-K, Ctrl-U and Ctrl-W delete to the end of
+// the line, to its start and the word before the cursor, the up and down
+// arrows (or Ctrl-P and Ctrl-N) step through the history, Ctrl-R searches
+// it, and Tab completes the word before the cursor.
+type lineEditor struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	// history returns the earlier lines, oldest first.
+	history func() []string
+
+	// complete returns the word that line ends with and the words it
+	// might be completed to.
+	complete func(line string) (string, []string)
+
+	prompt string
+	buf    []rune
+	pos    int
+}
+
+// Keys read as escape sequences are given codes past the last rune.
+const (
+	keyNone rune = utf8.MaxRune + 1 + iota
+	keyUp
+	keyDown
+	keyRight
+	keyLeft
+	keyHome
+	keyEnd
+	keyDelete
+	keyWordLeft
+	keyWordRight
+	keyUnknown
+)
+
+// ctrl returns the code of the key typed with Ctrl and c.
+func ctrl(c byte) rune {
+	return rune(c & 0x1f)
+}
+
+// readLine shows prompt and reads a line. It returns io.EOF for Ctrl-D on
+// an empty line and errInterrupted for Ctrl-C.
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	e.prompt, e.buf, e.pos = prompt, nil, 0
+	hist := e.history()
+	index := len(hist) // in hist, or len(hist) for the line being typed
+	typed := ""        // the line being typed, while browsing hist
+	tabbed := false
+	pending := keyNone
+	e.refresh()
+	for {
+		key := pending
+		pending = keyNone
+		if key == keyNone {
+			var err error
+			if key, err = e.readKey(); err != nil {
+				return "", err
+			}
+		}
+
+		switch key {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(e.buf), nil
+		case ctrl('C'):
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case ctrl('D'):
+			if len(e.buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			e.delete(e.pos, e.pos+1)
+		case keyDelete:
+			e.delete(e.pos, e.pos+1)
+		case 127, ctrl('H'):
+			e.delete(e.pos-1, e.pos)
+		case ctrl('A'), keyHome:
+			e.pos = 0
+		case ctrl('E'), keyEnd:
+			e.pos = len(e.buf)
+		case ctrl('B'), keyLeft:
+			e.pos = max(e.pos-1, 0)
+		case ctrl('F'), keyRight:
+			e.pos = min(e.pos+1, len(e.buf))
+		case keyWordLeft:
+			e.pos = e.wordStart()
+		case keyWordRight:
+			for e.pos < len(e.buf) && unicode.IsSpace(e.buf[e.pos]) {
+				e.pos++
+			}
+			for e.pos < len(e.buf) && !unicode.IsSpace(e.buf[e.pos]) {
+				e.pos++
+			}
+		case ctrl('K'):
+			e.delete(e.pos, len(e.buf))
+		case ctrl('U'):
+			e.delete(0, e.pos)
+		case ctrl('W'):
+			e.delete(e.wordStart(), e.pos)
+		case ctrl('L'):
+			fmt.Fprint(e.out, clearScreen)
+		case ctrl('P'), keyUp:
+			if index > 0 {
+				if index == len(hist) {
+					typed = string(e.buf)
+				}
+				index--
+				e.set(hist[index])
+			}
+		case ctrl('N'), keyDown:
+			if index < len(hist) {
+				index++
+				if index == len(hist) {
+					e.set(typed)
+				} else {
+					e.set(hist[index])
+				}
+			}
+		case ctrl('R'):
+			var err error
+			if pending, err = e.search(hist); err != nil {
+				return "", err
+			}
+		case '\t':
+			e.completeWord(tabbed)
+			tabbed = true
+			e.refresh()
+			continue
+		default:
+			if key < keyNone && unicode.IsPrint(key) {
+				e.insert(string(key))
+			}
+		}
+		tabbed = false
+		e.refresh()
+	}
+}
+
+// readKey reads a key, turning the escape sequences sent by the arrow,
+// Home, End and Delete keys and by Alt-B and Alt-F into key codes.
+func (e *lineEditor) readKey() (rune, error) {
+	r, _, err := e.in.ReadRune()
+	if err != nil || r != 27 {
+		return r, err
+	}
+	r, _, err = e.in.ReadRune()
+	switch {
+	case err != nil:
+		return 0, err
+	case r == 'b':
+		return keyWordLeft, nil
+	case r == 'f':
+		return keyWordRight, nil
+	case r != '[' && r != 'O':
+		return keyUnknown, nil
+	}
+
+	// A control sequence is parameters ended by a final character, as
+	// in ESC [ 3 ~.
+	var params strings.Builder
+	for {
+		if r, _, err = e.in.ReadRune(); err != nil {
+			return 0, err
+		}
+		if r >= 0x40 && r <= 0x7e {
+			break
+		}
+		params.WriteRune(r)
+	}
+	switch r {
+	case 'A':
+		return keyUp, nil
+	case 'B':
+		return keyDown, nil
+	case 'C':
+		return keyRight, nil
+	case 'D':
+		return keyLeft, nil
+	case 'H':
+		return keyHome, nil
+	case 'F':
+		return keyEnd, nil
+	case '~':
+		switch params.String() {
+		case "1", "7":
+			return keyHome, nil
+		case "4", "8":
+			return keyEnd, nil
+		case "3":
+			return keyDelete, nil
+		}
+	}
+	return keyUnknown, nil
+}
+
+// refresh redraws the line after the prompt and puts the cursor in place.
+func (e *lineEditor) refresh() {
+	fmt.Fprintf(e.out, "\r%s%s\033[K", e.prompt, string(e.buf))
+	if n := len(e.buf) - e.pos; n > 0 {
+		fmt.Fprintf(e.out, "\033[%dD", n)
+	}
+}
+
+// set replaces the line with s, with the cursor at its end.
+func (e *lineEditor) set(s string) {
+	e.buf = []rune(s)
+	e.pos = len(e.buf)
+}
+
+// insert inserts s at the cursor and moves the cursor past it.
+func (e *lineEditor) insert(s string) {
+	r := []rune(s)
+	e.buf = slices.Insert(e.buf, e.pos, r...)
+	e.pos += len(r)
+}
+
+// delete deletes the characters from start up to end, as far as the line
+// holds them.
+func (e *lineEditor) delete(start, end int) {
+	start, end = max(start, 0), min(end, len(e.buf))
+	if start >= end {
+		return
+	}
+	e.buf = slices.Delete(e.buf, start, end)
+	if e.pos > end {
+		e.pos -= end - start
+	} else if e.pos > start {
+		e.pos = start
+	}
+}
+
+// wordStart returns the start of the word before the cursor.
+func (e *lineEditor) wordStart() int {
+	i := e.pos
+	for i > 0 && unicode.IsSpace(e.buf[i-1]) {
+		i--
+	}
+	for i > 0 && !unicode.IsSpace(e.buf[i-1]) {
+		i--
+	}
+	return i
+}
+
+// completeWord completes the word before the cursor as far as all the
+// words it might be completed to agree, and past the end of the word if
+// there is only one. Otherwise, if again is set because Tab was pressed
+// twice, it lists them.
+func (e *lineEditor) completeWord(again bool) {
+	word, words := e.complete(string(e.buf[:e.pos]))
+	if len(words) == 0 {
+		fmt.Fprint(e.out, "\a")
+		return
+	}
+	prefix := words[0]
+	for _, w := range words[1:] {
+		n := 0
+		for n < len(prefix) && n < len(w) && prefix[n] == w[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	if len(words) == 1 && !strings.HasSuffix(prefix, "/") {
+		prefix += " "
+	}
+	if strings.HasPrefix(prefix, word) && len(prefix) > len(word) && utf8.ValidString(prefix) {
+		e.insert(prefix[len(word):])
+		return
+	}
+	if !again {
+		fmt.Fprint(e.out, "\a")
+		return
+	}
+
+	// The words are listed by their last component.
+	names := make([]string, len(words))
+	for i, w := range words {
+		names[i] = w[strings.LastIndex(strings.TrimSuffix(w, "/"), "/")+1:]
+	}
+	fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(names, "  "))
+}
+
+// search reads a reverse incremental search through hist, as Ctrl-R
+// starts in bash: each character typed is added to the text searched for,
+// and Ctrl-R again finds an older line holding it. Ctrl-G or Ctrl-C gives
+// up and puts back the line as it was. Any other key leaves the line found
+// to be edited and is returned to take effect as usual, so that Enter
+// runs it.
+func (e *lineEditor) search(hist []string) (rune, error) {
+	prompt, buf, pos := e.prompt, e.buf, e.pos
+	defer func() { e.prompt = prompt }()
+	query := ""
+	found := len(hist)
+	failing := false
+	// find looks for the query in the lines from hist[from] back.
+	find := func(from int) {
+		for i := min(from, len(hist)-1); i >= 0; i-- {
+			if at := strings.Index(hist[i], query); at >= 0 {
+				found, failing = i, false
+				e.set(hist[i])
+				e.pos = utf8.RuneCountInString(hist[i][:at])
+				return
+			}
+		}
+		failing = true
+	}
+	for {
+		e.prompt = fmt.Sprintf("(reverse-i-search)'%s': ", query)
+		if failing {
+			e.prompt = "(failed " + e.prompt[1:]
+		}
+		e.refresh()
+		key, err := e.readKey()
+		if err != nil {
+			return keyNone, err
+		}
+		switch {
+		case key == ctrl('R'):
+			if query != "" {
+				find(found - 1)
+			}
+		case key == 127 || key == ctrl('H'):
+			if query != "" {
+				_, n := utf8.DecodeLastRuneInString(query)
+				query = query[:len(query)-n]
+				find(len(hist) - 1)
+			}
+		case key == ctrl('G') || key == ctrl('C'):
+			e.buf, e.pos = buf, pos
+			return keyNone, nil
+		case key < keyNone && unicode.IsPrint(key):
+			query += string(key)
+			find(found)
+		default:
+			return key, nil
+		}
+	}
+}
+
+// complete returns the word that line ends with and the words it might
+// be completed to: the names of commands if it is the first word of a
+// command, or else the paths that start with it, directories with a
+// trailing slash. Hidden files are only offered for a word that starts
+// their name with a dot.
+func (s *Shell) complete(line string) (string, []string) {
+	start := 0
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\':
+			i++
+		case isBlank(c) || strings.IndexByte(";&|<>", c) >= 0:
+			start = i + 1
+		}
+	}
+	word := line[start:]
+	before := strings.TrimRight(line[:start], " \t")
+	if before == "" || strings.ContainsAny(before[len(before)-1:], ";&|") {
+		var words []string
+		for _, name := range s.commandNames() {
+			if strings.HasPrefix(name, word) {
+				words = append(words, name)
+			}
+		}
+		return word, words
+	}
+
+	dir, prefix := path.Split(unescape(word))
+	f, err := s.Resolve(dir + ".")
+	if err != nil {
+		return word, nil
+	}
+	if f.mount != nil {
+		f = f.mount
+	}
+	s.FS.mu.RLock()
+	var entries []fs.DirEntry
+	if f.IsDirectory && s.FS.access(f, accessRead) {
+		entries = f.entries()
+	}
+	s.FS.mu.RUnlock()
+
+	var words []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".") {
+			continue
+		}
+		isDir := entry.IsDir()
+		if !isDir {
+			// Links to directories and mounted archives count as
+			// directories too.
+			f, err := s.Resolve(dir + name)
+			isDir = err == nil && (f.IsDirectory || f.mount != nil)
+		}
+		candidate := escapeWord(dir + name)
+		if isDir {
+			candidate += "/"
+		}
+		words = append(words, candidate)
+	}
+	return word, words
+}
+
+// escapeWord escapes with backslashes the characters of s that the shell
+// would otherwise take as something other than part of a word.
+func escapeWord(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(" \t\n'\"\\$`*?[]{}();&|<>#!", r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,98 @@
+package imfs
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// edit types input at a line editor and returns the line it reads and
+// what it shows.
+func edit(input string, history []string, complete func(string) (string, []string)) (string, string, error) {
+	var out strings.Builder
+	e := &lineEditor{
+		in:       bufio.NewReader(strings.NewReader(input)),
+		out:      &out,
+		history:  func() []string { return history },
+		complete: complete,
+	}
+	line, err := e.readLine("$ ")
+	return line, out.String(), err
+}
+
+func TestLineEditor(t *testing.T) {
+	history := []string{"two a", "one", "two b"}
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"abc\x1b[D\x1b[DX\r", "aXbc"},
+		{"abc\x01>\x05<\r", ">abc<"},
+		{"hello world\x17there\r", "hello there"},
+		{"abcd\x02\x02\x0b\x15x\r", "x"},
+		{"ab\x7f\x01\x1b[3~c\r", "c"},
+		{"héllo\x1b[D\x1b[D\x1b[D\x08\r", "hllo"},
+		{"\x1b[A\x1b[A\r", "one"},
+		{"x\x1b[A\x1b[A\x1b[B\x1b[B\r", "x"},
+		{"\x12tw\r", "two b"},
+		{"\x12tw\x12\r", "two a"},
+		{"\x12on\x05!\r", "one!"},
+		{"ab\x12zz\x07c\r", "abc"},
+	}
+	for _, test := range tests {
+		line, _, err := edit(test.input, history, nil)
+		assertEqual(t, nil, err, "Expected a line for "+test.want)
+		assertEqual(t, test.want, line, "Expected the keys to edit the line to "+test.want)
+	}
+
+	// Test Ctrl-D and Ctrl-C
+	_, _, err := edit("\x04", nil, nil)
+	assertEqual(t, io.EOF, err, "Expected Ctrl-D on an empty line to end the input")
+	line, _, _ := edit("ab\x01\x04\r", nil, nil)
+	assertEqual(t, "b", line, "Expected Ctrl-D to delete otherwise")
+	_, out, err := edit("abc\x03", nil, nil)
+	assertEqual(t, true, errors.Is(err, errInterrupted), "Expected Ctrl-C to abandon the line")
+	assertEqual(t, true, strings.HasSuffix(out, "^C\r\n"), "Expected Ctrl-C to be shown")
+	_, out, _ = edit("\x12zz\r", history, nil)
+	assertEqual(t, true, strings.Contains(out, "(failed reverse-i-search)'zz': "), "Expected a failed search to be shown")
+}
+
+func TestComplete(t *testing.T) {
+	shell := NewShell()
+	shell.Mkdir("docs", false)
+	shell.Mkdir("dev", false)
+	shell.Touch("docs/a.txt")
+	shell.Touch("docs/my notes.txt")
+	shell.Touch(".hidden")
+	shell.Symlink("docs", "link")
+
+	// Test command names complete at the start of a command, and paths elsewhere
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"ec", "echo"},
+		{"ls | gre", "grep"},
+		{"true && um", "umask umount"},
+		{"cat d", "dev/ docs/"},
+		{"cat docs/m", `docs/my\ notes.txt`},
+		{`cat docs/my\ n`, `docs/my\ notes.txt`},
+		{"cd /l", "/link/"},
+		{"cat ", "dev/ docs/ link/"},
+		{"cat .", ".hidden"},
+		{"cat x", ""},
+	}
+	for _, test := range tests {
+		_, words := shell.complete(test.line)
+		assertEqual(t, test.want, strings.Join(words, " "), "Expected the completions of "+test.line)
+	}
+
+	// Test Tab completes in the editor, and lists the words when pressed twice
+	line, _, _ := edit("cat do\tm\t\r", nil, shell.complete)
+	assertEqual(t, `cat docs/my\ notes.txt `, line, "Expected Tab to complete a path")
+	line, out, _ := edit("cat d\t\t\r", nil, shell.complete)
+	assertEqual(t, "cat d", line, "Expected an ambiguous word to be kept")
+	assertEqual(t, true, strings.Contains(out, "\r\ndev/  docs/\r\n"), "Expected a second Tab to list the words")
+}
@@ -0,0 +1,397 @@
+package imfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// ErrBadSnapshot is returned by Load when its input is not a snapshot
+// written by Save, or has been damaged.
+var ErrBadSnapshot = errors.New("invalid snapshot")
+
+// Snapshots start with snapshotMagic and a big-endian uint16 format
+// version, followed by the uvarint sequence number of the last journal
+// record the snapshot includes and the last inode number handed out, then
+// the root node and a trailing big-endian CRC-32 (IEEE) of every byte
+// before it. Each node is encoded as
+//
+//	kind     byte (0 file, 1 directory, 2 symbolic link, 3 hard link)
+//	name     uvarint length, bytes
+//	ino      uvarint
+//
+// A hard link ends there, and shares the inode of the earlier node with the
+// same number, which may not be a directory. Other nodes go on with
+//
+//	created  varint seconds, uvarint nanoseconds
+//	modified varint seconds, uvarint nanoseconds
+//	mode     uvarint fs.FileMode, uid varint, gid varint
+//	xattrs   uvarint count, then uvarint-length name and value of each in
+//	         sorted order
+//	file:      uvarint length, content
+//	directory: uvarint child count, children
+//	link:      uvarint length, target
+const (
+	snapshotMagic   = "IMFS"
+	snapshotVersion = 6
+)
+
+const (
+	nodeFile = iota
+	nodeDir
+	nodeSymlink
+	nodeHardlink
+)
+
+// Save writes a snapshot of the whole tree to w. A snapshot holds every
+// file whatever its permissions, so only the superuser may take one.
+func (fsys *FS) Save(w io.Writer) error {
+	if fsys.cred.UID != 0 {
+		return fs.ErrPermission
+	}
+	fsys.mu.RLock()
+	buf := fsys.encode()
+	fsys.mu.RUnlock()
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// encode returns a snapshot of the tree. The caller must hold the FS lock.
+func (fsys *FS) encode() []byte {
+	buf := append([]byte(snapshotMagic), 0, 0)
+	binary.BigEndian.PutUint16(buf[len(snapshotMagic):], snapshotVersion)
+	buf = binary.AppendUvarint(buf, fsys.seq)
+	buf = binary.AppendUvarint(buf, fsys.ino)
+	buf = appendNode(buf, fsys.Root, make(map[*Inode]bool))
+	return binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+}
+
+// appendNode encodes f and everything below it. Inodes already in written
+// are encoded as hard links.
+func appendNode(buf []byte, f *File, written map[*Inode]bool) []byte {
+	switch {
+	case written[f.Inode]:
+		buf = append(buf, nodeHardlink)
+		buf = appendBytes(buf, []byte(f.Name))
+		return binary.AppendUvarint(buf, f.Ino)
+	case f.IsDirectory:
+		buf = append(buf, nodeDir)
+	case f.IsSymlink:
+		buf = append(buf, nodeSymlink)
+	default:
+		buf = append(buf, nodeFile)
+	}
+	written[f.Inode] = true
+	buf = appendBytes(buf, []byte(f.Name))
+	buf = binary.AppendUvarint(buf, f.Ino)
+	buf = appendTime(buf, f.CreatedAt)
+	buf = appendTime(buf, f.ModifiedAt)
+	buf = binary.AppendUvarint(buf, uint64(f.Mode))
+	buf = binary.AppendVarint(buf, int64(f.UID))
+	buf = binary.AppendVarint(buf, int64(f.GID))
+	buf = binary.AppendUvarint(buf, uint64(len(f.Xattrs)))
+	for _, name := range slices.Sorted(maps.Keys(f.Xattrs)) {
+		buf = appendBytes(buf, []byte(name))
+		buf = appendBytes(buf, f.Xattrs[name])
+	}
+	if f.IsSymlink {
+		return appendBytes(buf, []byte(f.Target))
+	}
+	if !f.IsDirectory {
+		return appendBytes(buf, f.Content)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(f.Children)))
+	for _, child := range f.Children {
+		buf = appendNode(buf, child, written)
+	}
+	return buf
+}
+
+func appendBytes(buf, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+func appendTime(buf []byte, t time.Time) []byte {
+	buf = binary.AppendVarint(buf, t.Unix())
+	return binary.AppendUvarint(buf, uint64(t.Nanosecond()))
+}
+
+// Load replaces the whole tree with the snapshot read from r. The root node
+// itself is kept, so shells whose working directory is the root remain
+// valid; any other working directory is left detached from the new tree.
+// If a journal is attached, the loaded tree is compacted into it at once,
+// since the records already in the journal no longer apply. Only the
+// superuser may load a snapshot, since it replaces the tree, owners and
+// all, for every view.
+func (fsys *FS) Load(r io.Reader) error {
+	if fsys.cred.UID != 0 {
+		return fs.ErrPermission
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	root, seq, ino, err := decodeSnapshot(data)
+	if err != nil {
+		return err
+	}
+
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	fsys.replace(root, ino)
+	if fsys.journal != nil {
+		// Keep counting from our own sequence so the journal stays ordered.
+		return fsys.journal.compact(fsys)
+	}
+	fsys.seq = seq
+	return nil
+}
+
+// replace swaps the contents of the tree for those of root, whose inode
+// numbers go up to ino. The caller must hold the FS lock for writing.
+func (fsys *FS) replace(root *File, ino uint64) {
+	fsys.Root.Inode = root.Inode
+	fsys.Root.links = []*File{fsys.Root}
+	fsys.Root.Children = root.Children
+	for _, child := range fsys.Root.Children {
+		child.Parent = fsys.Root
+	}
+	fsys.ino = ino
+}
+
+// decodeSnapshot returns the root node, the journal sequence number and the
+// last inode number handed out, as stored in a snapshot.
+func decodeSnapshot(data []byte) (*File, uint64, uint64, error) {
+	header := len(snapshotMagic) + 2
+	if len(data) < header+4 || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, 0, 0, ErrBadSnapshot
+	}
+	body, sum := data[:len(data)-4], data[len(data)-4:]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(sum) {
+		return nil, 0, 0, ErrBadSnapshot
+	}
+	version := binary.BigEndian.Uint16(data[len(snapshotMagic):])
+	if version != snapshotVersion {
+		return nil, 0, 0, ErrBadSnapshot
+	}
+
+	d := &decoder{buf: body[header:], inodes: make(map[uint64]*Inode)}
+	seq := d.uvarint()
+	ino := d.uvarint()
+	root := d.node()
+	if d.err != nil || len(d.buf) != 0 || !root.IsDirectory {
+		return nil, 0, 0, ErrBadSnapshot
+	}
+	return root, seq, max(ino, d.ino), nil
+}
+
+// decoder reads snapshot fields from buf, remembering the first error so
+// callers can check once at the end.
+type decoder struct {
+	buf []byte
+	err error
+
+	inodes map[uint64]*Inode // inodes decoded so far, by number
+	ino    uint64            // highest inode number seen
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = ErrBadSnapshot
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = ErrBadSnapshot
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.buf) == 0 {
+		d.err = ErrBadSnapshot
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil || n > uint64(len(d.buf)) {
+		d.err = ErrBadSnapshot
+		return nil
+	}
+	b := bytes.Clone(d.buf[:n])
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) time() time.Time {
+	sec := d.varint()
+	nsec := d.uvarint()
+	return time.Unix(sec, int64(nsec))
+}
+
+func (d *decoder) node() *File {
+	kind := d.byte()
+	f := &File{
+		Name:        string(d.bytes()),
+		IsDirectory: kind == nodeDir,
+		Inode:       new(Inode),
+	}
+	f.links = []*File{f}
+	f.Ino = d.uvarint()
+	if kind == nodeHardlink {
+		n := d.inodes[f.Ino]
+		if n == nil || n.links[0].IsDirectory {
+			d.err = ErrBadSnapshot
+			return f
+		}
+		f.Inode, f.IsSymlink = n, n.links[0].IsSymlink
+		n.links = append(n.links, f)
+		return f
+	}
+	if d.inodes[f.Ino] != nil || f.Ino == 0 {
+		d.err = ErrBadSnapshot
+		return f
+	}
+	d.inodes[f.Ino] = f.Inode
+	d.ino = max(d.ino, f.Ino)
+	f.CreatedAt = d.time()
+	f.ModifiedAt = d.time()
+	f.Mode = fs.FileMode(d.uvarint()) & modeBits
+	f.UID = int(d.varint())
+	f.GID = int(d.varint())
+	// Every attribute takes at least two bytes, which bounds the count.
+	count := d.uvarint()
+	if count > uint64(len(d.buf)) {
+		d.err = ErrBadSnapshot
+		return f
+	}
+	for range count {
+		name := string(d.bytes())
+		if _, ok := f.Xattrs[name]; ok || name == "" {
+			d.err = ErrBadSnapshot
+			return f
+		}
+		f.setXattr(name, d.bytes())
+	}
+	switch {
+	case d.err != nil:
+		return f
+	case kind == nodeFile:
+		f.Content = d.bytes()
+		f.Size = int64(len(f.Content))
+	case kind == nodeSymlink:
+		f.IsSymlink, f.Target = true, string(d.bytes())
+		f.Size = int64(len(f.Target))
+		if f.Target == "" {
+			d.err = ErrBadSnapshot
+		}
+	case kind == nodeDir:
+		// Every child takes at least one byte, which bounds the count.
+		count := d.uvarint()
+		if count > uint64(len(d.buf)) {
+			d.err = ErrBadSnapshot
+			return f
+		}
+		seen := make(map[string]bool, count)
+		for range count {
+			child := d.node()
+			if d.err != nil {
+				return f
+			}
+			if !validName(child.Name) || seen[child.Name] {
+				d.err = ErrBadSnapshot
+				return f
+			}
+			seen[child.Name] = true
+			child.Parent = f
+			f.Children = append(f.Children, child)
+		}
+	default:
+		d.err = ErrBadSnapshot
+	}
+	return f
+}
+
+// SaveFile writes a snapshot to the host file at path. The snapshot is
+// written to a temporary file first and renamed into place, so a crash
+// never leaves a partial snapshot behind. As with Save, only the superuser
+// may do so.
+func (fsys *FS) SaveFile(path string) error {
+	if fsys.cred.UID != 0 {
+		return &fs.PathError{Op: "save", Path: path, Err: fs.ErrPermission}
+	}
+	fsys.mu.RLock()
+	data := fsys.encode()
+	fsys.mu.RUnlock()
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic replaces the host file at path with data by way of a
+// synced temporary file in the same directory.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadFile replaces the tree with the snapshot in the host file at path.
+// As with Load, only the superuser may do so.
+func (fsys *FS) LoadFile(path string) error {
+	if fsys.cred.UID != 0 {
+		return &fs.PathError{Op: "load", Path: path, Err: fs.ErrPermission}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := fsys.Load(f); err != nil {
+		return &fs.PathError{Op: "load", Path: path, Err: err}
+	}
+	return nil
+}
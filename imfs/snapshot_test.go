@@ -0,0 +1,103 @@
+package imfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	fsys := NewFS()
+	fsys.MkdirAll("/home/user/docs")
+	fsys.Mkdir("/empty")
+	fsys.WriteFile("/home/user/docs/note.txt", []byte("Hello, World!"))
+	fsys.WriteFile("/home/user/binary", []byte{0, 1, 2, 255})
+	fsys.WriteFile("/top.txt", nil)
+	created := time.Date(2001, 2, 3, 4, 5, 6, 7, time.UTC)
+	modified := time.Date(2002, 3, 4, 5, 6, 7, 8, time.UTC)
+	note, _ := fsys.Resolve("/home/user/docs/note.txt")
+	note.CreatedAt = created
+	note.ModifiedAt = modified
+
+	var buf bytes.Buffer
+	assertEqual(t, nil, fsys.Save(&buf), "Expected Save to succeed")
+
+	restored := NewFS()
+	assertEqual(t, nil, restored.Load(bytes.NewReader(buf.Bytes())), "Expected Load to succeed")
+
+	// Test content and hierarchy are preserved
+	content, err := restored.ReadFile("home/user/docs/note.txt")
+	assertEqual(t, nil, err, "Expected restored file to exist")
+	assertEqual(t, "Hello, World!", string(content), "Expected content to be preserved")
+	content, _ = restored.ReadFile("home/user/binary")
+	assertEqual(t, string([]byte{0, 1, 2, 255}), string(content), "Expected binary content to be preserved")
+	info, err := restored.Stat("empty")
+	assertEqual(t, nil, err, "Expected empty directory to be preserved")
+	assertEqual(t, true, info.IsDir(), "Expected empty to be a directory")
+	info, _ = restored.Stat("top.txt")
+	assertEqual(t, false, info.IsDir(), "Expected empty file to stay a file")
+
+	// Test timestamps are preserved
+	restoredNote, _ := restored.Resolve("/home/user/docs/note.txt")
+	assertEqual(t, true, restoredNote.CreatedAt.Equal(created), "Expected CreatedAt to be preserved")
+	assertEqual(t, true, restoredNote.ModifiedAt.Equal(modified), "Expected ModifiedAt to be preserved")
+	assertEqual(t, "docs", restoredNote.Parent.Name, "Expected parent links to be rebuilt")
+
+	// Test Load replaces existing content
+	restored.WriteFile("/stale.txt", []byte("stale"))
+	restored.Load(bytes.NewReader(buf.Bytes()))
+	_, err = restored.Stat("stale.txt")
+	assertEqual(t, true, err != nil, "Expected Load to replace the tree")
+
+	// Test snapshots of another format version are rejected
+	old := append([]byte(snapshotMagic), 0, snapshotVersion-1)
+	old = append(old, buf.Bytes()[len(snapshotMagic)+2:len(buf.Bytes())-4]...)
+	old = binary.BigEndian.AppendUint32(old, crc32.ChecksumIEEE(old))
+	err = restored.Load(bytes.NewReader(old))
+	assertEqual(t, true, errors.Is(err, ErrBadSnapshot), "Expected an older version to be rejected")
+}
+
+func TestSnapshotCorruption(t *testing.T) {
+	fsys := NewFS()
+	fsys.MkdirAll("/a/b")
+	fsys.WriteFile("/a/b/file", []byte("content"))
+	var buf bytes.Buffer
+	fsys.Save(&buf)
+	data := buf.Bytes()
+
+	// Test every single-byte corruption and truncation is detected
+	for i := range data {
+		damaged := bytes.Clone(data)
+		damaged[i] ^= 0x40
+		err := NewFS().Load(bytes.NewReader(damaged))
+		assertEqual(t, true, errors.Is(err, ErrBadSnapshot), "Expected corrupted snapshot to be rejected")
+
+		err = NewFS().Load(bytes.NewReader(data[:i]))
+		assertEqual(t, true, errors.Is(err, ErrBadSnapshot), "Expected truncated snapshot to be rejected")
+	}
+
+	// Test a failed Load leaves the tree untouched
+	target := NewFS()
+	target.WriteFile("/keep", []byte("keep"))
+	target.Load(bytes.NewReader(data[:len(data)-1]))
+	content, _ := target.ReadFile("keep")
+	assertEqual(t, "keep", string(content), "Expected failed Load not to modify the tree")
+}
+
+func TestSnapshotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.imfs")
+
+	shell := NewShell()
+	shell.Mkdir("dir", false)
+	shell.RedirectWrite("dir/file.txt", "saved", false)
+	assertEqual(t, nil, shell.FS.SaveFile(path), "Expected SaveFile to succeed")
+
+	restored := NewShell()
+	assertEqual(t, nil, restored.FS.LoadFile(path), "Expected LoadFile to succeed")
+	content, _ := restored.Cat("dir/file.txt")
+	assertEqual(t, "saved", content, "Expected content to survive a save and load")
+}
@@ -0,0 +1,169 @@
+package imfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io/fs"
+	"strconv"
+	"testing"
+)
+
+func TestSymlinkResolution(t *testing.T) {
+	fsys := NewFS()
+	fsys.MkdirAll("/data/real")
+	fsys.WriteFile("/data/real/file", []byte("contents"))
+
+	// Test absolute and relative targets
+	assertEqual(t, nil, fsys.Symlink("/data/real", "/abs"), "Expected Symlink to succeed")
+	assertEqual(t, nil, fsys.Symlink("real/file", "/data/rel"), "Expected a relative Symlink to succeed")
+	content, err := fsys.ReadFile("abs/file")
+	assertEqual(t, nil, err, "Expected to read through a link to a directory")
+	assertEqual(t, "contents", string(content), "Expected the target's contents")
+	content, _ = fsys.ReadFile("data/rel")
+	assertEqual(t, "contents", string(content), "Expected a relative target to resolve from the link's directory")
+
+	// Test Stat follows links while Lstat and ReadLink do not
+	info, _ := fsys.Stat("abs")
+	assertEqual(t, true, info.IsDir(), "Expected Stat to describe the target")
+	assertEqual(t, "abs", info.Name(), "Expected Stat to use the name given")
+	info, _ = fsys.Lstat("abs")
+	assertEqual(t, fs.ModeSymlink, info.Mode().Type(), "Expected Lstat to describe the link")
+	target, _ := fsys.ReadLink("data/rel")
+	assertEqual(t, "real/file", target, "Expected ReadLink to return the target as given")
+	_, err = fsys.ReadLink("data/real")
+	assertEqual(t, true, errors.Is(err, fs.ErrInvalid), "Expected fs.ErrInvalid for ReadLink of a directory")
+
+	// Test ".." after a link leads to the parent of its target
+	fsys.Mkdir("/data/sibling")
+	_, err = fsys.Resolve("/abs/../sibling")
+	assertEqual(t, nil, err, "Expected .. to be taken from the link's target")
+
+	// Test dangling links
+	fsys.Symlink("/nowhere/new", "/dangling")
+	_, err = fsys.Stat("dangling")
+	assertEqual(t, true, errors.Is(err, fs.ErrNotExist), "Expected fs.ErrNotExist for Stat of a dangling link")
+	_, err = fsys.Lstat("dangling")
+	assertEqual(t, nil, err, "Expected Lstat of a dangling link to succeed")
+	fsys.Mkdir("/nowhere")
+	assertEqual(t, nil, fsys.WriteFile("/dangling", []byte("made")), "Expected writing through a dangling link to create its target")
+	content, _ = fsys.ReadFile("nowhere/new")
+	assertEqual(t, "made", string(content), "Expected the target to be created")
+
+	// Test loops fail with ErrLoop
+	fsys.Symlink("/loop2", "/loop1")
+	fsys.Symlink("/loop1", "/loop2")
+	_, err = fsys.Stat("loop1")
+	assertEqual(t, true, errors.Is(err, ErrLoop), "Expected ErrLoop for a cycle of links")
+	err = fsys.WriteFile("/loop1", nil)
+	assertEqual(t, true, errors.Is(err, ErrLoop), "Expected ErrLoop creating through a cycle of links")
+
+	// Test a chain of 40 links resolves and 41 does not
+	fsys.Symlink("/data/real/file", "/chain0")
+	for i := 1; i <= 40; i++ {
+		fsys.Symlink("/chain"+strconv.Itoa(i-1), "/chain"+strconv.Itoa(i))
+	}
+	_, err = fsys.Stat("chain39")
+	assertEqual(t, nil, err, "Expected 40 hops to resolve")
+	_, err = fsys.Stat("chain40")
+	assertEqual(t, true, errors.Is(err, ErrLoop), "Expected ErrLoop after 40 hops")
+
+	// Test Remove and Rename act on the link itself
+	assertEqual(t, nil, fsys.Rename("/abs", "/moved"), "Expected Rename of a link to succeed")
+	_, err = fsys.Stat("data/real/file")
+	assertEqual(t, nil, err, "Expected renaming a link to leave its target alone")
+	assertEqual(t, nil, fsys.Remove("/moved"), "Expected Remove of a link to succeed")
+	assertEqual(t, nil, fsys.RemoveAll("/data/rel"), "Expected RemoveAll of a link to succeed")
+	_, err = fsys.Stat("data/real/file")
+	assertEqual(t, nil, err, "Expected removing a link to leave its target alone")
+}
+
+func TestSymlinkPersistence(t *testing.T) {
+	fsys := NewFS()
+	fsys.Mkdir("/dir")
+	fsys.Symlink("../target", "/dir/link")
+
+	// Test a snapshot keeps links
+	var buf bytes.Buffer
+	fsys.Save(&buf)
+	loaded := NewFS()
+	assertEqual(t, nil, loaded.Load(&buf), "Expected Load to succeed")
+	target, _ := loaded.ReadLink("dir/link")
+	assertEqual(t, "../target", target, "Expected the link to survive a snapshot")
+
+	// Test the journal replays links and their removal
+	state := t.TempDir() + "/state"
+	journaled := NewFS()
+	journaled.OpenJournal(state, DefaultJournalLimit)
+	journaled.WriteFile("/file", []byte("x"))
+	journaled.Symlink("/file", "/kept")
+	journaled.Symlink("/file", "/gone")
+	journaled.Remove("/gone")
+	journaled.journal.file.Close()
+
+	recovered := NewFS()
+	assertEqual(t, nil, recovered.OpenJournal(state, DefaultJournalLimit), "Expected recovery to succeed")
+	defer recovered.CloseJournal()
+	target, _ = recovered.ReadLink("kept")
+	assertEqual(t, "/file", target, "Expected the journal to replay the link")
+	_, err := recovered.Lstat("gone")
+	assertEqual(t, true, errors.Is(err, fs.ErrNotExist), "Expected the journal to replay removing a link")
+	_, err = recovered.Stat("file")
+	assertEqual(t, nil, err, "Expected removing a link not to remove its target")
+
+	// Test tar archives keep links
+	var archive bytes.Buffer
+	fsys.ExportTar(&archive, "/dir")
+	other := NewFS()
+	assertEqual(t, nil, other.ImportTar(&archive, "/"), "Expected ImportTar to succeed")
+	target, _ = other.ReadLink("dir/link")
+	assertEqual(t, "../target", target, "Expected the link to survive a tar round trip")
+}
+
+func TestSymlinkArchiveEscape(t *testing.T) {
+	fsys := NewFS()
+	fsys.Mkdir("/outside")
+
+	// Test an archive cannot write through a link it creates
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: "/outside"})
+	tw.WriteHeader(&tar.Header{Name: "escape/planted", Typeflag: tar.TypeReg, Size: 1})
+	tw.Write([]byte("x"))
+	tw.Close()
+	err := fsys.ImportTar(&buf, "/dest")
+	assertEqual(t, true, errors.Is(err, ErrIsDir), "Expected the link to clash with the directory its entries made")
+	_, err = fsys.Stat("outside/planted")
+	assertEqual(t, true, errors.Is(err, fs.ErrNotExist), "Expected nothing to be written through the link")
+}
+
+func TestSymlinkShell(t *testing.T) {
+	shell := NewShell()
+	shell.Mkdir("/srv/www/html", true)
+	shell.RedirectWrite("/srv/www/html/index", "hello", false)
+
+	// Test ln -s and readlink
+	assertEqual(t, nil, shell.Symlink("/srv/www", "/www"), "Expected ln -s to succeed")
+	target, _ := shell.Readlink("/www")
+	assertEqual(t, "/srv/www", target, "Expected readlink to print the target")
+	shell.Mkdir("/links", false)
+	shell.Symlink("/srv/www/html/index", "/links")
+	content, _ := shell.Cat("/links/index")
+	assertEqual(t, "hello", content, "Expected ln -s into a directory to keep the target's name")
+
+	// Test logical and physical working directories
+	assertEqual(t, nil, shell.Cd("/www/html"), "Expected cd through a link to succeed")
+	assertEqual(t, "/www/html", shell.Pwd(), "Expected pwd to show the path taken")
+	assertEqual(t, "/srv/www/html", shell.PwdPhysical(), "Expected pwd -P to resolve links")
+	content, _ = shell.Cat("index")
+	assertEqual(t, "hello", content, "Expected relative names to resolve from the working directory")
+	shell.Cd("../..")
+	assertEqual(t, "/", shell.Pwd(), "Expected cd .. to retrace the logical path")
+
+	// Test rm removes the link, not its target
+	shell.Symlink("/nowhere", "/dangling")
+	assertEqual(t, nil, shell.Remove("/dangling", false), "Expected rm of a dangling link to succeed")
+	assertEqual(t, nil, shell.Remove("/www", true), "Expected rm -r of a link to succeed")
+	_, err := shell.Resolve("/srv/www/html/index")
+	assertEqual(t, nil, err, "Expected rm -r of a link to leave its target alone")
+}
@@ -0,0 +1,135 @@
+package imfs
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// ImportTar extracts the tar archive read from r into the directory dest,
+// which is created if it does not exist. Directories, regular files,
+// symbolic links and hard links to earlier files are supported, and their
+// modification times and extended attributes (as SCHILY.xattr PAX
+// records) are restored. Existing files are replaced and existing
+// directories merged into. The whole archive is read and checked before
+// the tree is changed, and entries may not name anything outside dest.
+func (fsys *FS) ImportTar(r io.Reader, dest string) error {
+	var entries []archiveEntry
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return &fs.PathError{Op: "import", Path: dest, Err: err}
+		}
+		name, err := archiveName(hdr.Name)
+		if err != nil {
+			return &fs.PathError{Op: "import", Path: hdr.Name, Err: err}
+		}
+		e := archiveEntry{name: name, mode: hdr.FileInfo().Mode(), uid: hdr.Uid, gid: hdr.Gid, mtime: hdr.ModTime}
+		for k, v := range hdr.PAXRecords {
+			if attr, ok := strings.CutPrefix(k, paxXattr); ok {
+				if e.xattrs == nil {
+					e.xattrs = make(map[string][]byte)
+				}
+				e.xattrs[attr] = []byte(v)
+			}
+		}
+		switch hdr.Typeflag {
+		case tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeDir:
+		case tar.TypeSymlink:
+			e.data = []byte(hdr.Linkname)
+		case tar.TypeLink:
+			if e.hardlink, err = archiveName(hdr.Linkname); err != nil || name == "" {
+				return &fs.PathError{Op: "import", Path: hdr.Name, Err: fs.ErrInvalid}
+			}
+		case tar.TypeReg:
+			if name == "" {
+				return &fs.PathError{Op: "import", Path: hdr.Name, Err: fs.ErrInvalid}
+			}
+			if e.data, err = io.ReadAll(tr); err != nil {
+				return &fs.PathError{Op: "import", Path: hdr.Name, Err: err}
+			}
+		default:
+			return &fs.PathError{Op: "import", Path: hdr.Name, Err: errors.ErrUnsupported}
+		}
+		entries = append(entries, e)
+	}
+	return fsys.importEntries("import", dest, entries)
+}
+
+// ExportTar writes src and everything below it to w as a tar archive.
+// Entry names are relative to the directory holding src, so exporting
+// /home/user gives user/, user/notes.txt and so on, while exporting the
+// root gives its contents. Further names for a file are written as hard
+// links to the first. The tree is read in a single consistent pass before
+// anything is written.
+func (fsys *FS) ExportTar(w io.Writer, src string) error {
+	entries, err := fsys.exportEntries("export", src)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: tar.TypeReg,
+			Mode:     tarMode(e.mode),
+			Uid:      e.uid,
+			Gid:      e.gid,
+			Size:     int64(len(e.data)),
+			ModTime:  e.mtime,
+			Format:   tar.FormatPAX,
+		}
+		for attr, value := range e.xattrs {
+			if hdr.PAXRecords == nil {
+				hdr.PAXRecords = make(map[string]string)
+			}
+			hdr.PAXRecords[paxXattr+attr] = string(value)
+		}
+		switch {
+		case e.mode.IsDir():
+			hdr.Name += "/"
+			hdr.Typeflag = tar.TypeDir
+		case e.mode&fs.ModeSymlink != 0:
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, string(e.data), 0
+			e.data = nil
+		case e.hardlink != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, e.hardlink, 0
+			e.data = nil
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(e.data); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// paxXattr prefixes the PAX records that hold extended attributes, as GNU
+// tar and star write them.
+const paxXattr = "SCHILY.xattr."
+
+// tarMode returns the tar header mode bits for mode.
+func tarMode(mode fs.FileMode) int64 {
+	bits := int64(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		bits |= 0o4000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		bits |= 0o2000
+	}
+	if mode&fs.ModeSticky != 0 {
+		bits |= 0o1000
+	}
+	return bits
+}
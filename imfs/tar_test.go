@@ -0,0 +1,189 @@
+package imfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTarRoundTrip(t *testing.T) {
+	fsys := NewFS()
+	fsys.MkdirAll("/src/docs/empty")
+	fsys.WriteFile("/src/docs/note.txt", []byte("Hello, World!"))
+	fsys.WriteFile("/src/top.bin", []byte{0, 1, 2, 255})
+	mtime := time.Date(2001, 2, 3, 4, 5, 6, 789, time.UTC)
+	fsys.Chtimes("/src/docs/note.txt", mtime)
+	fsys.Chtimes("/src/docs", mtime.Add(time.Hour))
+
+	var buf bytes.Buffer
+	assertEqual(t, nil, fsys.ExportTar(&buf, "/src"), "Expected ExportTar to succeed")
+
+	// Test entry names are relative to the parent of the exported path
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	for hdr, err := tr.Next(); err == nil; hdr, err = tr.Next() {
+		names = append(names, hdr.Name)
+	}
+	assertEqual(t, "[src/ src/docs/ src/docs/empty/ src/docs/note.txt src/top.bin]", fmt.Sprint(names), "Expected sorted entries under src/")
+
+	// Test importing restores content, hierarchy and modification times
+	restored := NewFS()
+	assertEqual(t, nil, restored.ImportTar(bytes.NewReader(buf.Bytes()), "/dest"), "Expected ImportTar to succeed")
+	content, err := restored.ReadFile("dest/src/docs/note.txt")
+	assertEqual(t, nil, err, "Expected imported file to exist")
+	assertEqual(t, "Hello, World!", string(content), "Expected content to be preserved")
+	content, _ = restored.ReadFile("dest/src/top.bin")
+	assertEqual(t, string([]byte{0, 1, 2, 255}), string(content), "Expected binary content to be preserved")
+	info, err := restored.Stat("dest/src/docs/empty")
+	assertEqual(t, nil, err, "Expected empty directory to be preserved")
+	assertEqual(t, true, info.IsDir(), "Expected empty to be a directory")
+	info, _ = restored.Stat("dest/src/docs/note.txt")
+	assertEqual(t, true, info.ModTime().Equal(mtime), "Expected file modification time to be preserved")
+	info, _ = restored.Stat("dest/src/docs")
+	assertEqual(t, true, info.ModTime().Equal(mtime.Add(time.Hour)), "Expected directory modification time to be preserved")
+
+	// Test exporting the root gives its contents without a prefix
+	buf.Reset()
+	restored.ExportTar(&buf, "/")
+	hdr, _ := tar.NewReader(&buf).Next()
+	assertEqual(t, "dest/", hdr.Name, "Expected root export to list its children")
+}
+
+func TestTarImportRules(t *testing.T) {
+	archive := func(hdrs ...*tar.Header) *bytes.Reader {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, hdr := range hdrs {
+			tw.WriteHeader(hdr)
+			tw.Write(make([]byte, hdr.Size))
+		}
+		tw.Close()
+		return bytes.NewReader(buf.Bytes())
+	}
+
+	// Test files are extracted into directories the archive does not list
+	fsys := NewFS()
+	err := fsys.ImportTar(archive(&tar.Header{Name: "a/b/file", Typeflag: tar.TypeReg, Size: 3}), "/")
+	assertEqual(t, nil, err, "Expected missing parent directories to be created")
+	content, _ := fsys.ReadFile("a/b/file")
+	assertEqual(t, 3, len(content), "Expected file content")
+
+	// Test existing files are overwritten
+	fsys.WriteFile("/a/b/file", []byte("longer content"))
+	fsys.ImportTar(archive(&tar.Header{Name: "a/b/file", Typeflag: tar.TypeReg, Size: 1}), "/")
+	content, _ = fsys.ReadFile("a/b/file")
+	assertEqual(t, 1, len(content), "Expected import to replace the file")
+
+	// Test extracting over a hard link leaves the other names alone
+	fsys.Link("/a/b/file", "/other")
+	fsys.WriteFile("/other", []byte("kept"))
+	fsys.ImportTar(archive(&tar.Header{Name: "a/b/file", Typeflag: tar.TypeReg, Size: 2}), "/")
+	content, _ = fsys.ReadFile("other")
+	assertEqual(t, "kept", string(content), "Expected the other hard link to keep its content")
+	f, _ := fsys.Resolve("/other")
+	assertEqual(t, 1, f.Nlink(), "Expected the extracted file to get a fresh inode")
+
+	// Test extracting over a symbolic link replaces the link itself
+	fsys.Symlink("/other", "/a/b/link")
+	fsys.ImportTar(archive(&tar.Header{Name: "a/b/link", Typeflag: tar.TypeReg, Size: 5}), "/")
+	content, _ = fsys.ReadFile("other")
+	assertEqual(t, "kept", string(content), "Expected nothing to be written through the link")
+	f, _ = fsys.Resolve("/a/b/link")
+	assertEqual(t, false, f.IsSymlink, "Expected the link to be replaced by a file")
+	assertEqual(t, int64(5), f.Size, "Expected the extracted content")
+
+	// Test entries may not escape the destination
+	err = fsys.ImportTar(archive(&tar.Header{Name: "../evil", Typeflag: tar.TypeReg}), "/a")
+	assertEqual(t, true, errors.Is(err, fs.ErrInvalid), "Expected fs.ErrInvalid for an entry outside dest")
+	_, err = fsys.Stat("evil")
+	assertEqual(t, true, errors.Is(err, fs.ErrNotExist), "Expected nothing to be written outside dest")
+
+	// Test unsupported entry types are rejected before anything is written
+	err = fsys.ImportTar(archive(
+		&tar.Header{Name: "first", Typeflag: tar.TypeReg},
+		&tar.Header{Name: "fifo", Typeflag: tar.TypeFifo},
+	), "/")
+	assertEqual(t, true, errors.Is(err, errors.ErrUnsupported), "Expected errors.ErrUnsupported for a fifo")
+	_, err = fsys.Stat("first")
+	assertEqual(t, true, errors.Is(err, fs.ErrNotExist), "Expected a rejected archive not to change the tree")
+
+	// Test a file cannot replace a directory
+	err = fsys.ImportTar(archive(&tar.Header{Name: "a", Typeflag: tar.TypeReg}), "/")
+	assertEqual(t, true, errors.Is(err, ErrIsDir), "Expected ErrIsDir extracting a file over a directory")
+}
+
+func TestTarHardLinks(t *testing.T) {
+	fsys := NewFS()
+	fsys.MkdirAll("/src/sub")
+	fsys.WriteFile("/src/a", []byte("shared"))
+	fsys.Link("/src/a", "/src/b")
+	fsys.Link("/src/a", "/src/sub/c")
+
+	// Test later names for a file are written as hard links to the first
+	var buf bytes.Buffer
+	assertEqual(t, nil, fsys.ExportTar(&buf, "/src"), "Expected ExportTar to succeed")
+	var links []string
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	for hdr, err := tr.Next(); err == nil; hdr, err = tr.Next() {
+		if hdr.Typeflag == tar.TypeLink {
+			links = append(links, hdr.Name+"->"+hdr.Linkname)
+		}
+	}
+	assertEqual(t, "[src/b->src/a src/sub/c->src/a]", fmt.Sprint(links), "Expected a hard link entry for each further name")
+
+	// Test importing restores the links
+	restored := NewFS()
+	assertEqual(t, nil, restored.ImportTar(bytes.NewReader(buf.Bytes()), "/dest"), "Expected ImportTar to succeed")
+	a, _ := restored.Resolve("/dest/src/a")
+	c, _ := restored.Resolve("/dest/src/sub/c")
+	assertEqual(t, 3, a.Nlink(), "Expected the file to keep its three names")
+	assertEqual(t, a.Ino, c.Ino, "Expected the names to share an inode")
+	restored.WriteFile("/dest/src/sub/c", []byte("changed"))
+	content, _ := restored.ReadFile("dest/src/b")
+	assertEqual(t, "changed", string(content), "Expected the names to share the content")
+
+	// Test a hard link must be to a file earlier in the archive
+	var bad bytes.Buffer
+	tw := tar.NewWriter(&bad)
+	tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeLink, Linkname: "src/a"})
+	tw.Close()
+	err := restored.ImportTar(&bad, "/dest")
+	assertEqual(t, true, errors.Is(err, fs.ErrNotExist), "Expected fs.ErrNotExist for a link to a file outside the archive")
+	_, err = restored.Stat("dest/link")
+	assertEqual(t, true, errors.Is(err, fs.ErrNotExist), "Expected nothing to be linked")
+}
+
+func TestTarShell(t *testing.T) {
+	shell := NewShell()
+	shell.Mkdir("/project/src", true)
+	shell.RedirectWrite("/project/src/main.go", "package main", false)
+
+	// Test creating and extracting an archive inside the tree
+	shell.Cd("/project")
+	assertEqual(t, nil, shell.TarCreate("backup.tar", "src"), "Expected tar -cf to succeed")
+	shell.Mkdir("/restore", false)
+	assertEqual(t, nil, shell.TarExtract("/project/backup.tar", "/restore"), "Expected tar -xf to succeed")
+	content, err := shell.Cat("/restore/src/main.go")
+	assertEqual(t, nil, err, "Expected extracted file to exist")
+	assertEqual(t, "package main", content, "Expected extracted content")
+
+	// Test archives on the host
+	host := filepath.Join(t.TempDir(), "out.tar")
+	assertEqual(t, nil, shell.TarCreate("host:"+host, "."), "Expected tar -cf to a host path to succeed")
+	_, err = os.Stat(host)
+	assertEqual(t, nil, err, "Expected archive on the host")
+	other := NewShell()
+	assertEqual(t, nil, other.TarExtract("host:"+host, "."), "Expected tar -xf from a host path to succeed")
+	content, _ = other.Cat("project/src/main.go")
+	assertEqual(t, "package main", content, "Expected content to survive the host round trip")
+
+	// Test a missing archive
+	err = shell.TarExtract("missing.tar", ".")
+	assertEqual(t, true, errors.Is(err, fs.ErrNotExist), "Expected fs.ErrNotExist for a missing archive")
+}
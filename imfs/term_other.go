@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package imfs
+
+import "errors"
+
+// makeRaw reports that raw mode is not supported, so that the terminal
+// is left to edit lines itself.
+func makeRaw(fd int) (func() error, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,282 @@
+package imfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Errors returned when looking up accounts.
+var (
+	ErrUnknownUser  = errors.New("unknown user")
+	ErrUnknownGroup = errors.New("unknown group")
+	ErrBadAccounts  = errors.New("malformed account database")
+)
+
+// The account database lives in the tree in the format of the Unix files
+// of the same names.
+const (
+	PasswdFile = "/etc/passwd"
+	GroupFile  = "/etc/group"
+)
+
+// A User is an entry in the user database, as in a line of /etc/passwd.
+type User struct {
+	Name    string
+	UID     int
+	GID     int // primary group
+	Comment string
+	Home    string
+	Shell   string
+}
+
+// A Group is an entry in the group database, as in a line of /etc/group.
+type Group struct {
+	Name    string
+	GID     int
+	Members []string // users for whom this is a supplementary group
+}
+
+// Accounts is an in-memory user and group database. It is a plain value:
+// the FS keeps its database in PasswdFile and GroupFile, which Accounts and
+// SaveAccounts read and write.
+type Accounts struct {
+	Users  []User
+	Groups []Group
+}
+
+// wheelGroup is the group whose members may use su and sudo.
+const wheelGroup = "wheel"
+
+// NewAccounts returns a database holding only the superuser, root, who is
+// a member of the root and wheel groups.
+func NewAccounts() *Accounts {
+	return &Accounts{
+		Users: []User{{Name: "root", Comment: "root", Home: "/root", Shell: "/bin/sh"}},
+		Groups: []Group{
+			{Name: "root"},
+			{Name: wheelGroup, GID: 10, Members: []string{"root"}},
+		},
+	}
+}
+
+// LookupUser returns the user with the given name.
+func (a *Accounts) LookupUser(name string) (User, error) {
+	for _, u := range a.Users {
+		if u.Name == name {
+			return u, nil
+		}
+	}
+	return User{}, ErrUnknownUser
+}
+
+// LookupUID returns the user with the given ID.
+func (a *Accounts) LookupUID(uid int) (User, error) {
+	for _, u := range a.Users {
+		if u.UID == uid {
+			return u, nil
+		}
+	}
+	return User{}, ErrUnknownUser
+}
+
+// LookupGroup returns the group with the given name.
+func (a *Accounts) LookupGroup(name string) (Group, error) {
+	for _, g := range a.Groups {
+		if g.Name == name {
+			return g, nil
+		}
+	}
+	return Group{}, ErrUnknownGroup
+}
+
+// LookupGID returns the group with the given ID.
+func (a *Accounts) LookupGID(gid int) (Group, error) {
+	for _, g := range a.Groups {
+		if g.GID == gid {
+			return g, nil
+		}
+	}
+	return Group{}, ErrUnknownGroup
+}
+
+// AddUser adds u to the database. Its name and ID must be unused, and its
+// primary group must exist.
+func (a *Accounts) AddUser(u User) error {
+	if !validAccountName(u.Name) || u.UID < 0 {
+		return fs.ErrInvalid
+	}
+	if _, err := a.LookupUser(u.Name); err == nil {
+		return fs.ErrExist
+	}
+	if _, err := a.LookupUID(u.UID); err == nil {
+		return fs.ErrExist
+	}
+	if _, err := a.LookupGID(u.GID); err != nil {
+		return err
+	}
+	a.Users = append(a.Users, u)
+	return nil
+}
+
+// AddGroup adds g to the database. Its name and ID must be unused.
+func (a *Accounts) AddGroup(g Group) error {
+	if !validAccountName(g.Name) || g.GID < 0 {
+		return fs.ErrInvalid
+	}
+	if _, err := a.LookupGroup(g.Name); err == nil {
+		return fs.ErrExist
+	}
+	if _, err := a.LookupGID(g.GID); err == nil {
+		return fs.ErrExist
+	}
+	a.Groups = append(a.Groups, g)
+	return nil
+}
+
+// NextUID returns the lowest unused user ID from 1000 up, where the IDs of
+// ordinary users start.
+func (a *Accounts) NextUID() int {
+	uid := 1000
+	for _, err := a.LookupUID(uid); err == nil; _, err = a.LookupUID(uid) {
+		uid++
+	}
+	return uid
+}
+
+// NextGID returns the lowest unused group ID from 1000 up.
+func (a *Accounts) NextGID() int {
+	gid := 1000
+	for _, err := a.LookupGID(gid); err == nil; _, err = a.LookupGID(gid) {
+		gid++
+	}
+	return gid
+}
+
+// Cred returns the credentials of u: its primary group, and every group
+// that lists it as a member.
+func (a *Accounts) Cred(u User) Cred {
+	cred := Cred{UID: u.UID, GID: u.GID}
+	for _, g := range a.Groups {
+		if g.GID != u.GID && slices.Contains(g.Members, u.Name) {
+			cred.Groups = append(cred.Groups, g.GID)
+		}
+	}
+	return cred
+}
+
+// validAccountName reports whether name can be written to the database.
+func validAccountName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, ":,\n \t")
+}
+
+// Accounts reads the account database from PasswdFile and GroupFile. A
+// missing file leaves the corresponding part of NewAccounts in place.
+func (fsys *FS) Accounts() (*Accounts, error) {
+	a := NewAccounts()
+	data, err := fsys.ReadFile(PasswdFile[1:])
+	switch {
+	case err == nil:
+		if a.Users, err = parsePasswd(data); err != nil {
+			return nil, &fs.PathError{Op: "accounts", Path: PasswdFile, Err: err}
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+	data, err = fsys.ReadFile(GroupFile[1:])
+	switch {
+	case err == nil:
+		if a.Groups, err = parseGroup(data); err != nil {
+			return nil, &fs.PathError{Op: "accounts", Path: GroupFile, Err: err}
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+	return a, nil
+}
+
+// SaveAccounts writes a to PasswdFile and GroupFile, creating /etc if
+// needed.
+func (fsys *FS) SaveAccounts(a *Accounts) error {
+	if err := fsys.MkdirAll("/etc"); err != nil {
+		return err
+	}
+	var passwd, group bytes.Buffer
+	for _, u := range a.Users {
+		fmt.Fprintf(&passwd, "%s:x:%d:%d:%s:%s:%s\n", u.Name, u.UID, u.GID, u.Comment, u.Home, u.Shell)
+	}
+	for _, g := range a.Groups {
+		fmt.Fprintf(&group, "%s:x:%d:%s\n", g.Name, g.GID, strings.Join(g.Members, ","))
+	}
+	if err := fsys.WriteFile(PasswdFile, passwd.Bytes()); err != nil {
+		return err
+	}
+	return fsys.WriteFile(GroupFile, group.Bytes())
+}
+
+// accountLines returns the colon-separated fields of each line of data,
+// skipping blank lines and comments. Every line must have n fields.
+func accountLines(data []byte, n int) ([][]string, error) {
+	var lines [][]string
+	for line := range strings.SplitSeq(string(data), "\n") {
+		if line = strings.TrimSpace(line); line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) != n || fields[0] == "" {
+			return nil, ErrBadAccounts
+		}
+		lines = append(lines, fields)
+	}
+	return lines, nil
+}
+
+// parseID parses a numeric user or group ID.
+func parseID(id string) (int, error) {
+	n, err := strconv.Atoi(id)
+	if err != nil || n < 0 {
+		return 0, fs.ErrInvalid
+	}
+	return n, nil
+}
+
+func parsePasswd(data []byte) ([]User, error) {
+	lines, err := accountLines(data, 7)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]User, 0, len(lines))
+	for _, f := range lines {
+		uid, err1 := parseID(f[2])
+		gid, err2 := parseID(f[3])
+		if err1 != nil || err2 != nil {
+			return nil, ErrBadAccounts
+		}
+		users = append(users, User{Name: f[0], UID: uid, GID: gid, Comment: f[4], Home: f[5], Shell: f[6]})
+	}
+	return users, nil
+}
+
+func parseGroup(data []byte) ([]Group, error) {
+	lines, err := accountLines(data, 4)
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]Group, 0, len(lines))
+	for _, f := range lines {
+		gid, err := parseID(f[2])
+		if err != nil {
+			return nil, ErrBadAccounts
+		}
+		g := Group{Name: f[0], GID: gid}
+		if f[3] != "" {
+			g.Members = strings.Split(f[3], ",")
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
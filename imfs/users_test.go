@@ -0,0 +1,105 @@
+package imfs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+)
+
+func TestAccounts(t *testing.T) {
+	fsys := NewFS()
+
+	// Test the default database when the tree has none
+	accounts, err := fsys.Accounts()
+	assertEqual(t, nil, err, "Expected Accounts to succeed without /etc/passwd")
+	root, _ := accounts.LookupUID(0)
+	assertEqual(t, "root", root.Name, "Expected the default database to hold root")
+
+	// Test adding entries and saving them into the tree
+	assertEqual(t, nil, accounts.AddGroup(Group{Name: "staff", GID: 50}), "Expected AddGroup to succeed")
+	assertEqual(t, nil, accounts.AddUser(User{Name: "alice", UID: 1000, GID: 50, Home: "/home/alice"}), "Expected AddUser to succeed")
+	assertEqual(t, true, errors.Is(accounts.AddUser(User{Name: "alice", UID: 1001, GID: 50}), fs.ErrExist), "Expected fs.ErrExist for a duplicate name")
+	assertEqual(t, true, errors.Is(accounts.AddUser(User{Name: "bob", UID: 1000, GID: 50}), fs.ErrExist), "Expected fs.ErrExist for a duplicate ID")
+	assertEqual(t, true, errors.Is(accounts.AddUser(User{Name: "bob", UID: 1001, GID: 99}), ErrUnknownGroup), "Expected ErrUnknownGroup for a missing group")
+	assertEqual(t, true, errors.Is(accounts.AddUser(User{Name: "a:b", UID: 1001}), fs.ErrInvalid), "Expected fs.ErrInvalid for a name with a colon")
+	assertEqual(t, nil, fsys.SaveAccounts(accounts), "Expected SaveAccounts to succeed")
+	passwd, _ := fsys.ReadFile("etc/passwd")
+	assertEqual(t, "root:x:0:0:root:/root:/bin/sh\nalice:x:1000:50::/home/alice:\n", string(passwd), "Expected /etc/passwd in the Unix format")
+
+	// Test reading hand-edited files back, with supplementary groups
+	fsys.AppendFile("/etc/group", []byte("# comment\ndev:x:60:alice,bob\n"))
+	accounts, err = fsys.Accounts()
+	assertEqual(t, nil, err, "Expected Accounts to read the saved files")
+	alice, _ := accounts.LookupUser("alice")
+	assertEqual(t, "[60]", fmt.Sprint(accounts.Cred(alice).Groups), "Expected supplementary groups from /etc/group")
+	assertEqual(t, 1001, accounts.NextUID(), "Expected the next free user ID")
+
+	// Test a malformed file
+	fsys.WriteFile("/etc/passwd", []byte("broken line\n"))
+	_, err = fsys.Accounts()
+	assertEqual(t, true, errors.Is(err, ErrBadAccounts), "Expected ErrBadAccounts for a malformed file")
+}
+
+func TestUserShell(t *testing.T) {
+	shell := NewShell()
+
+	// Test useradd creates the user, a private group and a home directory
+	assertEqual(t, nil, shell.Groupadd("dev", -1), "Expected groupadd to succeed")
+	assertEqual(t, nil, shell.Useradd("alice", -1, ""), "Expected useradd to succeed")
+	assertEqual(t, nil, shell.Useradd("bob", 2000, "dev"), "Expected useradd with an ID and group to succeed")
+	home, err := shell.Resolve("/home/alice")
+	assertEqual(t, nil, err, "Expected a home directory under /home")
+	assertEqual(t, 1000, home.UID, "Expected the home directory to belong to the user")
+	assertEqual(t, 1001, home.GID, "Expected the user's private group to skip the taken ID")
+	bob, _ := shell.Resolve("/home/bob")
+	assertEqual(t, 1000, bob.GID, "Expected bob's primary group to be dev")
+	err = shell.Useradd("alice", -1, "")
+	assertEqual(t, true, errors.Is(err, fs.ErrExist), "Expected fs.ErrExist adding a user twice")
+
+	// Test chown by name
+	shell.Touch("/shared")
+	assertEqual(t, nil, shell.Chown("alice:dev", "/shared"), "Expected chown by name to succeed")
+	f, _ := shell.Resolve("/shared")
+	assertEqual(t, 1000, f.UID, "Expected the named owner")
+	assertEqual(t, 1000, f.GID, "Expected the named group")
+	err = shell.Chown("nobody", "/shared")
+	assertEqual(t, true, errors.Is(err, ErrUnknownUser), "Expected ErrUnknownUser for an unknown name")
+
+	// Test su changes the identity until exit
+	assertEqual(t, nil, shell.Su("alice"), "Expected root to su to alice")
+	name, _ := shell.Whoami()
+	assertEqual(t, "alice", name, "Expected whoami to report alice")
+	assertEqual(t, "uid=1000(alice) gid=1001(alice) groups=1001(alice)", shell.ID(), "Expected id to describe alice")
+	err = shell.Touch("/etc/nope")
+	assertEqual(t, true, errors.Is(err, fs.ErrPermission), "Expected alice not to write /etc")
+	assertEqual(t, nil, shell.Touch("/home/alice/mine"), "Expected alice to write her home directory")
+
+	// Test only root and wheel members may su or sudo
+	err = shell.Su("bob")
+	assertEqual(t, true, errors.Is(err, fs.ErrPermission), "Expected fs.ErrPermission for su outside wheel")
+	err = shell.Sudo(func() {})
+	assertEqual(t, true, errors.Is(err, fs.ErrPermission), "Expected fs.ErrPermission for sudo outside wheel")
+	assertEqual(t, true, shell.Exit(), "Expected exit to end su")
+	name, _ = shell.Whoami()
+	assertEqual(t, "root", name, "Expected exit to return to root")
+	assertEqual(t, false, shell.Exit(), "Expected exit without su to exit the shell")
+
+	// Test sudo runs as root and then restores the identity
+	accounts, _ := shell.Accounts()
+	for i, g := range accounts.Groups {
+		if g.Name == "wheel" {
+			accounts.Groups[i].Members = append(g.Members, "alice")
+		}
+	}
+	shell.SaveAccounts(accounts)
+	shell.Su("alice")
+	err = shell.Sudo(func() {
+		name, _ = shell.Whoami()
+		shell.Su("bob")
+	})
+	assertEqual(t, nil, err, "Expected a wheel member to sudo")
+	assertEqual(t, "root", name, "Expected sudo to act for root")
+	name, _ = shell.Whoami()
+	assertEqual(t, "alice", name, "Expected sudo to restore the identity")
+}
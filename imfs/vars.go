@@ -0,0 +1,354 @@
+package imfs
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrBadName is returned for a variable name that is not a letter or
+// underscore followed by letters, digits and underscores.
+var ErrBadName = errors.New("not a valid identifier")
+
+// Variables belong to the shell. Those that are exported make up its
+// environment, which env prints and which starts out with HOME, the home
+// directory of the shell's user (or / if it has none in the tree), and
+// PWD. Cd keeps PWD and OLDPWD up to date.
+
+// initVars sets up the variables of a new shell.
+func (s *Shell) initVars() {
+	s.vars = map[string]string{"HOME": "/", "PWD": s.Pwd()}
+	s.exported = map[string]bool{"HOME": true, "PWD": true, "OLDPWD": true}
+	accounts, err := s.Accounts()
+	if err != nil {
+		return
+	}
+	u, err := accounts.LookupUID(s.FS.Cred().UID)
+	if err != nil {
+		return
+	}
+	if f, err := s.FS.Resolve(u.Home); err == nil && f.IsDirectory {
+		s.vars["HOME"] = u.Home
+	}
+}
+
+// Var returns the value of the shell variable name and whether it is set.
+func (s *Shell) Var(name string) (string, bool) {
+	value, ok := s.vars[name]
+	return value, ok
+}
+
+// SetVar sets the shell variable name to value.
+func (s *Shell) SetVar(name, value string) error {
+	if !validVarName(name) {
+		return &fs.PathError{Op: "set", Path: name, Err: ErrBadName}
+	}
+	s.vars[name] = value
+	return nil
+}
+
+// Unset removes the shell variable name from the shell and its
+// environment.
+func (s *Shell) Unset(name string) error {
+	if !validVarName(name) {
+		return &fs.PathError{Op: "unset", Path: name, Err: ErrBadName}
+	}
+	delete(s.vars, name)
+	delete(s.exported, name)
+	return nil
+}
+
+// Export adds the shell variable name to the environment, now if it is set
+// or else once it is.
+func (s *Shell) Export(name string) error {
+	if !validVarName(name) {
+		return &fs.PathError{Op: "export", Path: name, Err: ErrBadName}
+	}
+	s.exported[name] = true
+	return nil
+}
+
+// Environ returns the environment as NAME=value strings sorted by name.
+func (s *Shell) Environ() []string {
+	var env []string
+	for _, name := range slices.Sorted(maps.Keys(s.vars)) {
+		if s.exported[name] {
+			env = append(env, name+"="+s.vars[name])
+		}
+	}
+	return env
+}
+
+func validVarName(name string) bool {
+	return name != "" && nameLen(name) == len(name)
+}
+
+// isAssignment reports whether arg has the form NAME=value.
+func isAssignment(arg string) bool {
+	n := nameLen(arg)
+	return n > 0 && n < len(arg) && arg[n] == '='
+}
+
+// withEnv runs run with the NAME=value assignments of env made and
+// exported, as for a command they precede, and then undoes them.
+func (s *Shell) withEnv(env []string, run func()) {
+	type saved struct {
+		value         string
+		set, exported bool
+	}
+	old := make(map[string]saved)
+	for _, assignment := range env {
+		name, value, _ := strings.Cut(assignment, "=")
+		if _, ok := old[name]; !ok {
+			v, set := s.vars[name]
+			old[name] = saved{v, set, s.exported[name]}
+		}
+		s.vars[name] = value
+		s.exported[name] = true
+	}
+	defer func() {
+		for name, o := range old {
+			if o.set {
+				s.vars[name] = o.value
+			} else {
+				delete(s.vars, name)
+			}
+			s.exported[name] = o.exported
+		}
+	}()
+	run()
+}
+
+// quote returns s quoted, if need be, so that the shell reads it back as
+// one word standing for itself.
+func quote(s string) string {
+	safe := s != ""
+	for i := 0; i < len(s) && safe; i++ {
+		c := s[i]
+		safe = c == '_' || c == '-' || c == '.' || c == '/' || c == ':' || c == '=' || c == '+' || c == '@' || c == '%' || c == ',' ||
+			'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+	}
+	if safe {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// expand turns the words of a command into its arguments. Each word is
+// expanded as in a POSIX shell: a leading ~ becomes $HOME, substitutions
+// are made, the results of those outside double quotes are split into
+// fields at blanks, and each field with unquoted wildcards or braces is
+// brace expanded and replaced by the paths its patterns match. A pattern
+// that matches nothing is kept as it is.
+func (s *Shell) expand(words []token, stdio Stdio) []string {
+	var argv []string
+	for _, w := range words {
+		argv = append(argv, s.expandWord(w, stdio)...)
+	}
+	return argv
+}
+
+// expandWord returns the fields that the word w expands to.
+func (s *Shell) expandWord(w token, stdio Stdio) []string {
+	parts := s.tilde(w.parts)
+	if !expands(parts) && len(parts) == len(w.parts) {
+		if w.pattern == "" {
+			return []string{w.text}
+		}
+		return s.glob(w.pattern)
+	}
+
+	// Each field is built up as a pattern, with the characters that are
+	// not to be taken as wildcards or braces escaped.
+	type field struct {
+		pattern strings.Builder
+		meta    bool
+	}
+	var fields []*field
+	var cur *field
+	add := func(pattern string, meta bool) {
+		if cur == nil {
+			cur = new(field)
+			fields = append(fields, cur)
+		}
+		cur.pattern.WriteString(pattern)
+		cur.meta = cur.meta || meta
+	}
+	for _, p := range parts {
+		if p.quoted && p.kind == partParam && p.text == "@" && p.op == "" {
+			// "$@" is a field for each positional parameter.
+			for i, param := range s.params {
+				if i > 0 {
+					cur = nil
+				}
+				add(escapeGlob(param), false)
+			}
+			continue
+		}
+		value := s.value(p, stdio)
+		switch {
+		case p.quoted:
+			add(escapeGlob(value), false)
+		case p.kind == partLiteral:
+			add(value, strings.ContainsAny(value, "*?[{"))
+		default:
+			// Substituted text is split at blanks. Wildcards in it are
+			// expanded, but braces are not.
+			for i := 0; i < len(value); {
+				if isBlank(value[i]) {
+					cur = nil
+					i++
+					continue
+				}
+				j := i
+				for j < len(value) && !isBlank(value[j]) {
+					j++
+				}
+				add(braceEscaper.Replace(value[i:j]), strings.ContainsAny(value[i:j], "*?["))
+				i = j
+			}
+		}
+	}
+
+	if len(s.params) == 0 && onlyParams(parts) {
+		// With no positional parameters, "$@" is no field at all.
+		return nil
+	}
+	var argv []string
+	for _, f := range fields {
+		if f.meta {
+			argv = append(argv, s.glob(f.pattern.String())...)
+		} else {
+			argv = append(argv, unescape(f.pattern.String()))
+		}
+	}
+	return argv
+}
+
+// onlyParams reports whether parts are "$@" and nothing else but empty
+// quotes.
+func onlyParams(parts []part) bool {
+	found := false
+	for _, p := range parts {
+		switch {
+		case p.kind == partParam && p.text == "@" && p.quoted && p.op == "":
+			found = true
+		case p.kind != partLiteral || p.text != "":
+			return false
+		}
+	}
+	return found
+}
+
+func isBlank(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+// braceEscaper escapes the characters of substituted text that would
+// otherwise be taken for brace expansion or escapes.
+var braceEscaper = strings.NewReplacer("{", `\{`, "}", `\}`, ",", `\,`, `\`, `\\`)
+
+// glob brace expands pattern and returns the paths its patterns match,
+// keeping any pattern that matches nothing as it is.
+func (s *Shell) glob(pattern string) []string {
+	var argv []string
+	for _, pattern := range expandBraces(pattern) {
+		matches, err := s.Glob(pattern)
+		if err != nil || len(matches) == 0 {
+			matches = []string{unescape(pattern)}
+		}
+		argv = append(argv, matches...)
+	}
+	return argv
+}
+
+// tilde returns parts with a ~ that starts them, alone or before a slash,
+// replaced by the home directory.
+func (s *Shell) tilde(parts []part) []part {
+	if len(parts) == 0 || parts[0].kind != partLiteral || parts[0].quoted {
+		return parts
+	}
+	first := parts[0].text
+	home, ok := s.vars["HOME"]
+	if !ok || first != "~" && !strings.HasPrefix(first, "~/") {
+		return parts
+	}
+	expanded := []part{{text: home, quoted: true}, {text: first[1:]}}
+	return append(expanded, parts[1:]...)
+}
+
+// expandString returns the text parts expand to as a single string, with
+// neither splitting nor globbing, as for the value of an assignment.
+func (s *Shell) expandString(parts []part, stdio Stdio) string {
+	var b strings.Builder
+	for _, p := range parts {
+		b.WriteString(s.value(p, stdio))
+	}
+	return b.String()
+}
+
+// value returns the text of p, making the substitution it stands for.
+func (s *Shell) value(p part, stdio Stdio) string {
+	switch p.kind {
+	case partLiteral:
+		return p.text
+	case partCommand:
+		return s.substitute(p.text, stdio)
+	}
+	value, set := s.param(p.text)
+	if p.op == "-" && !set || p.op == ":-" && value == "" {
+		return s.expandString(p.alt, stdio)
+	}
+	return value
+}
+
+// param returns the value of the parameter name and whether it is set:
+// a variable, a positional parameter, $? for the last status, $# for the
+// number of positional parameters, or $@ and $* for all of them.
+func (s *Shell) param(name string) (string, bool) {
+	switch name {
+	case "?":
+		return strconv.Itoa(s.status), true
+	case "#":
+		return strconv.Itoa(len(s.params)), true
+	case "@", "*":
+		return strings.Join(s.params, " "), len(s.params) > 0
+	case "0":
+		return shellName, true
+	}
+	if n, err := strconv.Atoi(name); err == nil {
+		if n > len(s.params) {
+			return "", false
+		}
+		return s.params[n-1], true
+	}
+	value, set := s.vars[name]
+	return value, set
+}
+
+// substitute runs command in a subshell and returns its output without
+// trailing newlines.
+func (s *Shell) substitute(command string, stdio Stdio) string {
+	var out bytes.Buffer
+	s.subshell().execute(command, Stdio{In: stdio.In, Out: &out, Err: stdio.Err})
+	return strings.TrimRight(out.String(), "\n")
+}
+
+// subshell returns a copy of s sharing its tree, whose changes to the
+// working directory, variables, identity and options do not reach s.
+func (s *Shell) subshell() *Shell {
+	sub := *s
+	view := *s.FS
+	sub.FS = &view
+	sub.outer = slices.Clone(s.outer)
+	sub.vars = maps.Clone(s.vars)
+	sub.exported = maps.Clone(s.exported)
+	sub.aliases = maps.Clone(s.aliases)
+	sub.functions = maps.Clone(s.functions)
+	sub.commands = maps.Clone(s.commands)
+	return &sub
+}
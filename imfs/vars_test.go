@@ -0,0 +1,97 @@
+package imfs
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTokenizeSubstitutions(t *testing.T) {
+	tokens, err := tokenize(`echo $X "a $X" '$X' \$X ${Y:-"d e"} $(echo ")") $? $ a$`)
+	assertEqual(t, nil, err, "Expected the line to parse")
+	assertEqual(t, 10, len(tokens), "Expected substitutions to stay within their words")
+	assertEqual(t, true, expands(tokens[1].parts), "Expected $X to be a substitution")
+	assertEqual(t, true, tokens[2].parts[1].quoted, "Expected a substitution inside double quotes to be quoted")
+	assertEqual(t, false, expands(tokens[3].parts) || expands(tokens[4].parts), "Expected quoted and escaped $ not to be")
+	assertEqual(t, ":-", tokens[5].parts[0].op, "Expected a default value")
+	assertEqual(t, `echo ")"`, tokens[6].parts[0].text, "Expected the command to run to its closing parenthesis")
+	assertEqual(t, "$ a$", tokens[8].text+" "+tokens[9].text, "Expected a lone $ to stand for itself")
+
+	// Test unfinished and malformed substitutions
+	_, err = tokenize("echo $(ls")
+	assertEqual(t, true, incomplete(err), "Expected an unclosed command substitution to be incomplete")
+	_, err = tokenize("echo ${X")
+	assertEqual(t, true, incomplete(err), "Expected an unclosed parameter substitution to be incomplete")
+	var syntaxErr *SyntaxError
+	_, err = tokenize("echo ${1X}")
+	assertEqual(t, true, errors.As(err, &syntaxErr) && !incomplete(err), "Expected a SyntaxError for a bad substitution")
+}
+
+func TestVariables(t *testing.T) {
+	shell := NewShell()
+
+	// Test assignments and quoting
+	out, _ := runLine(shell, `X=hello; echo $X "$X" '$X' \$X`)
+	assertEqual(t, "hello hello $X $X\n", out, "Expected $X to expand outside single quotes")
+	out, _ = runLine(shell, `echo ${U:-default} ${U-other}; E=; echo "${E-unused}" ${E:-empty}`)
+	assertEqual(t, "default other\n empty\n", out, "Expected defaults for unset and empty variables")
+	out, _ = runLine(shell, "false; echo $?; echo $?")
+	assertEqual(t, "1\n0\n", out, "Expected $? to be the last status")
+
+	// Test unquoted substitutions are split into fields and globbed
+	runLine(shell, `F="a b"; touch $F "$F"`)
+	out, _ = runLine(shell, `G='a*'; B='{x,y}'; echo $G $B; echo "$G"`)
+	assertEqual(t, "a a b {x,y}\na*\n", out, "Expected wildcards but not braces in substitutions to expand")
+	_, err := shell.Resolve("a b")
+	assertEqual(t, nil, err, "Expected a quoted substitution to stay one word")
+	_, errOut := runLine(shell, "echo x > $F")
+	assertEqual(t, "imfs: $F: ambiguous redirect\n", errOut, "Expected a redirection to need one word")
+
+	// Test the environment holds exported variables only
+	out, _ = runLine(shell, "export A=1 X; B=2; C=3 env")
+	assertEqual(t, "A=1\nC=3\nHOME=/\nPWD=/\nX=hello\n", out, "Expected env to list the environment")
+	out, _ = runLine(shell, "echo ${C-unset}; unset A X; env B=4 env")
+	assertEqual(t, "unset\nB=4\nHOME=/\nPWD=/\n", out, "Expected assignments before a command to last for the command")
+	value, _ := shell.Var("B")
+	assertEqual(t, "2", value, "Expected env not to change shell variables")
+	_, errOut = runLine(shell, "export 1x")
+	assertEqual(t, "export: 1x: not a valid identifier\n", errOut, "Expected a bad name to be refused")
+}
+
+func TestVariablesCd(t *testing.T) {
+	shell := NewShell()
+	shell.Useradd("alice", 1000, "")
+	shell.Mkdir("tmp", false)
+	alice := NewShellFS(shell.FS.WithCred(Cred{UID: 1000, GID: 1000}))
+
+	// Test HOME comes from the user's entry, if the directory exists
+	home, _ := shell.Var("HOME")
+	assertEqual(t, "/", home, "Expected HOME to be / without a home directory")
+	home, _ = alice.Var("HOME")
+	assertEqual(t, "/home/alice", home, "Expected HOME to be the user's home directory")
+
+	// Test cd keeps PWD and OLDPWD, and goes home and back
+	out, _ := runLine(alice, "cd /tmp; cd; echo $PWD $OLDPWD; cd /tmp; cd ~/; pwd; cd -; echo ~")
+	assertEqual(t, "/home/alice /tmp\n/home/alice\n/tmp\n/home/alice\n", out, "Expected cd, cd ~ and cd - to follow the variables")
+	_, errOut := runLine(alice, "unset HOME; cd")
+	assertEqual(t, "cd: HOME not set\n", errOut, "Expected cd to need HOME")
+}
+
+func TestCommandSubstitution(t *testing.T) {
+	shell := NewShell()
+	shell.RedirectWrite("list", "one\ntwo\n", false)
+
+	// Test output replaces the substitution, split unless quoted
+	out, _ := runLine(shell, `echo $(cat list) "$(cat list)" x$(echo)y`)
+	assertEqual(t, "one two one\ntwo xy\n", out, "Expected the output without trailing newlines")
+	out, _ = runLine(shell, `touch $(grep o list | grep -v n); echo $(echo $(echo nested))`)
+	assertEqual(t, "nested\n", out, "Expected substitutions to nest")
+	_, err := shell.Resolve("two")
+	assertEqual(t, nil, err, "Expected a pipeline in a substitution")
+
+	// Test the substitution runs in a subshell
+	shell.Mkdir("tmp", false)
+	out, _ = runLine(shell, `X=outer; echo $(cd tmp; X=inner; pwd; exit 3) $? $X; pwd`)
+	assertEqual(t, "/tmp 0 outer\n/\n", out, "Expected changes in a substitution to stay there")
+	assertEqual(t, false, strings.Contains(out, "inner"), "Expected the subshell's variables to be its own")
+}
@@ -0,0 +1,188 @@
+package imfs
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Errors returned by the extended attribute methods, wrapped in an
+// *fs.PathError.
+var (
+	ErrNoAttr       = errors.New("no such attribute")
+	ErrAttrTooLarge = errors.New("attribute too large")
+)
+
+// Limits on extended attributes. As on Linux, a name counts its namespace
+// prefix, and a file's names and values together must fit in
+// MaxXattrTotal.
+const (
+	MaxXattrName  = 255
+	MaxXattrValue = 64 << 10
+	MaxXattrTotal = 256 << 10
+)
+
+// Extended attribute names start with a namespace, which decides who may
+// use them:
+//
+//	user.      anyone, on files and directories they may read (to get) or
+//	           write (to set and remove)
+//	trusted.   only the superuser; others do not see them at all
+//	security.  anyone may get them, but only the owner and the superuser
+//	           set and remove them
+//
+// Names in other namespaces give errors.ErrUnsupported.
+const (
+	xattrUser     = "user."
+	xattrTrusted  = "trusted."
+	xattrSecurity = "security."
+)
+
+// checkXattr checks that attr is a well-formed name that the user of fsys
+// may get from f or, if write is set, set on or remove from f.
+func (fsys *FS) checkXattr(op string, f *File, attr string, write bool) error {
+	switch {
+	case len(attr) > MaxXattrName:
+		return &fs.PathError{Op: op, Path: f.path(), Err: ErrAttrTooLarge}
+	case strings.Contains(attr, "\x00"):
+		return &fs.PathError{Op: op, Path: f.path(), Err: fs.ErrInvalid}
+	}
+	var allowed bool
+	switch {
+	case strings.HasPrefix(attr, xattrUser) && len(attr) > len(xattrUser):
+		if write {
+			return fsys.permit(op, f, accessWrite)
+		}
+		return fsys.permit(op, f, accessRead)
+	case strings.HasPrefix(attr, xattrTrusted) && len(attr) > len(xattrTrusted):
+		allowed = fsys.cred.UID == 0
+	case strings.HasPrefix(attr, xattrSecurity) && len(attr) > len(xattrSecurity):
+		allowed = !write || fsys.owns(f)
+	default:
+		return &fs.PathError{Op: op, Path: f.path(), Err: errors.ErrUnsupported}
+	}
+	if !allowed {
+		return &fs.PathError{Op: op, Path: f.path(), Err: fs.ErrPermission}
+	}
+	return nil
+}
+
+// SetXattr sets the extended attribute attr of the named file to value,
+// creating the attribute if it does not exist.
+func (fsys *FS) SetXattr(name, attr string, value []byte) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	f, err := fsys.lookup("setxattr", name)
+	if err != nil {
+		return err
+	}
+	if err := fsys.checkXattr("setxattr", f, attr, true); err != nil {
+		return pathError("setxattr", name, err)
+	}
+	return pathError("setxattr", name, fsys.setXattr(f, attr, value))
+}
+
+func (fsys *FS) setXattr(f *File, attr string, value []byte) error {
+	if err := f.writable("setxattr"); err != nil {
+		return err
+	}
+	total := len(attr) + len(value)
+	for name, v := range f.Xattrs {
+		if name != attr {
+			total += len(name) + len(v)
+		}
+	}
+	if len(value) > MaxXattrValue || total > MaxXattrTotal {
+		return &fs.PathError{Op: "setxattr", Path: f.path(), Err: ErrAttrTooLarge}
+	}
+	path, _ := fsys.pathOf(f)
+	if err := fsys.log(record{op: opSetXattr, path: path, dest: attr, data: value}); err != nil {
+		return err
+	}
+	f.setXattr(attr, bytes.Clone(value))
+	return nil
+}
+
+func (n *Inode) setXattr(attr string, value []byte) {
+	if n.Xattrs == nil {
+		n.Xattrs = make(map[string][]byte)
+	}
+	n.Xattrs[attr] = value
+}
+
+// GetXattr returns the value of the extended attribute attr of the named
+// file, or ErrNoAttr if it has none.
+func (fsys *FS) GetXattr(name, attr string) ([]byte, error) {
+	fsys.mu.RLock()
+	defer fsys.mu.RUnlock()
+
+	f, err := fsys.lookup("getxattr", name)
+	if err != nil {
+		return nil, err
+	}
+	if err := fsys.checkXattr("getxattr", f, attr, false); err != nil {
+		return nil, pathError("getxattr", name, err)
+	}
+	value, ok := f.Xattrs[attr]
+	if !ok {
+		return nil, &fs.PathError{Op: "getxattr", Path: name, Err: ErrNoAttr}
+	}
+	return bytes.Clone(value), nil
+}
+
+// ListXattr returns the names of the extended attributes of the named file
+// in sorted order, leaving out those its user may not see.
+func (fsys *FS) ListXattr(name string) ([]string, error) {
+	fsys.mu.RLock()
+	defer fsys.mu.RUnlock()
+
+	f, err := fsys.lookup("listxattr", name)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Sorted(maps.Keys(fsys.visibleXattrs(f))), nil
+}
+
+// visibleXattrs returns the extended attributes of f that the user of fsys
+// may see, which are all of them but trusted ones for anyone but the
+// superuser. The caller must hold the FS lock.
+func (fsys *FS) visibleXattrs(f *File) map[string][]byte {
+	attrs := make(map[string][]byte, len(f.Xattrs))
+	for name, value := range f.Xattrs {
+		if fsys.cred.UID == 0 || !strings.HasPrefix(name, xattrTrusted) {
+			attrs[name] = value
+		}
+	}
+	return attrs
+}
+
+// RemoveXattr removes the extended attribute attr from the named file, or
+// returns ErrNoAttr if it has none.
+func (fsys *FS) RemoveXattr(name, attr string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	f, err := fsys.lookup("removexattr", name)
+	if err != nil {
+		return err
+	}
+	if err := fsys.checkXattr("removexattr", f, attr, true); err != nil {
+		return pathError("removexattr", name, err)
+	}
+	if _, ok := f.Xattrs[attr]; !ok {
+		return &fs.PathError{Op: "removexattr", Path: name, Err: ErrNoAttr}
+	}
+	if err := f.writable("removexattr"); err != nil {
+		return err
+	}
+	path, _ := fsys.pathOf(f)
+	if err := fsys.log(record{op: opRemoveXattr, path: path, dest: attr}); err != nil {
+		return err
+	}
+	delete(f.Xattrs, attr)
+	return nil
+}
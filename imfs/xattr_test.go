@@ -0,0 +1,156 @@
+package imfs
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func TestXattr(t *testing.T) {
+	fsys := NewFS()
+	fsys.WriteFile("/file", nil)
+
+	// Test set, get, list and remove
+	assertEqual(t, nil, fsys.SetXattr("/file", "user.color", []byte("blue")), "Expected SetXattr to succeed")
+	fsys.SetXattr("/file", "security.label", []byte("top"))
+	value, err := fsys.GetXattr("/file", "user.color")
+	assertEqual(t, nil, err, "Expected GetXattr to succeed")
+	assertEqual(t, "blue", string(value), "Expected the value set")
+	names, _ := fsys.ListXattr("/file")
+	assertEqual(t, "security.label,user.color", strings.Join(names, ","), "Expected ListXattr to list the names in order")
+	assertEqual(t, nil, fsys.RemoveXattr("/file", "user.color"), "Expected RemoveXattr to succeed")
+	_, err = fsys.GetXattr("/file", "user.color")
+	assertEqual(t, true, errors.Is(err, ErrNoAttr), "Expected ErrNoAttr after RemoveXattr")
+	err = fsys.RemoveXattr("/file", "user.color")
+	assertEqual(t, true, errors.Is(err, ErrNoAttr), "Expected ErrNoAttr removing a missing attribute")
+
+	// Test unknown namespaces and malformed names
+	err = fsys.SetXattr("/file", "system.acl", nil)
+	assertEqual(t, true, errors.Is(err, errors.ErrUnsupported), "Expected errors.ErrUnsupported for another namespace")
+	err = fsys.SetXattr("/file", "user.", nil)
+	assertEqual(t, true, errors.Is(err, errors.ErrUnsupported), "Expected errors.ErrUnsupported for a bare namespace")
+
+	// Test size limits
+	err = fsys.SetXattr("/file", "user."+strings.Repeat("n", MaxXattrName), nil)
+	assertEqual(t, true, errors.Is(err, ErrAttrTooLarge), "Expected ErrAttrTooLarge for a long name")
+	err = fsys.SetXattr("/file", "user.big", make([]byte, MaxXattrValue+1))
+	assertEqual(t, true, errors.Is(err, ErrAttrTooLarge), "Expected ErrAttrTooLarge for a large value")
+	for _, name := range []string{"user.a", "user.b", "user.c"} {
+		fsys.SetXattr("/file", name, make([]byte, MaxXattrValue))
+	}
+	err = fsys.SetXattr("/file", "user.d", make([]byte, MaxXattrValue))
+	assertEqual(t, true, errors.Is(err, ErrAttrTooLarge), "Expected ErrAttrTooLarge past the total limit")
+	assertEqual(t, nil, fsys.SetXattr("/file", "user.a", []byte("small")), "Expected replacing a value to count only the new one")
+}
+
+func TestXattrNamespaces(t *testing.T) {
+	root := NewFS()
+	root.WriteFile("/file", nil)
+	root.Chown("/file", 1000, 1000)
+	root.Chmod("/file", 0o644)
+	root.SetXattr("/file", "trusted.secret", []byte("x"))
+	alice := root.WithCred(Cred{UID: 1000, GID: 1000})
+	bob := root.WithCred(Cred{UID: 1001, GID: 1001})
+
+	// Test user attributes follow the permission bits
+	assertEqual(t, nil, alice.SetXattr("/file", "user.tag", []byte("a")), "Expected the owner to set a user attribute")
+	_, err := bob.GetXattr("/file", "user.tag")
+	assertEqual(t, nil, err, "Expected a reader to get a user attribute")
+	err = bob.SetXattr("/file", "user.tag", nil)
+	assertEqual(t, true, errors.Is(err, fs.ErrPermission), "Expected fs.ErrPermission setting without write permission")
+
+	// Test trusted attributes are for the superuser only
+	_, err = alice.GetXattr("/file", "trusted.secret")
+	assertEqual(t, true, errors.Is(err, fs.ErrPermission), "Expected fs.ErrPermission getting a trusted attribute")
+	names, _ := alice.ListXattr("/file")
+	assertEqual(t, "user.tag", strings.Join(names, ","), "Expected trusted attributes to be hidden from others")
+
+	// Test security attributes are set by the owner and read by anyone
+	assertEqual(t, nil, alice.SetXattr("/file", "security.label", []byte("l")), "Expected the owner to set a security attribute")
+	_, err = bob.GetXattr("/file", "security.label")
+	assertEqual(t, nil, err, "Expected anyone to get a security attribute")
+	root.Chmod("/file", 0o666)
+	err = bob.SetXattr("/file", "security.label", nil)
+	assertEqual(t, true, errors.Is(err, fs.ErrPermission), "Expected fs.ErrPermission setting another user's security attribute")
+}
+
+func TestXattrPersistence(t *testing.T) {
+	fsys := NewFS()
+	fsys.MkdirAll("/dir")
+	fsys.WriteFile("/dir/file", []byte("data"))
+	fsys.SetXattr("/dir/file", "user.bin", []byte{0, 1, 2})
+	fsys.SetXattr("/dir", "trusted.t", []byte("dir"))
+
+	// Test a snapshot keeps attributes
+	var buf bytes.Buffer
+	fsys.Save(&buf)
+	loaded := NewFS()
+	assertEqual(t, nil, loaded.Load(&buf), "Expected Load to succeed")
+	value, _ := loaded.GetXattr("/dir/file", "user.bin")
+	assertEqual(t, "\x00\x01\x02", string(value), "Expected the snapshot to keep a file's attribute")
+	value, _ = loaded.GetXattr("/dir", "trusted.t")
+	assertEqual(t, "dir", string(value), "Expected the snapshot to keep a directory's attribute")
+
+	// Test the journal replays setting and removing attributes
+	state := t.TempDir() + "/state"
+	journaled := NewFS()
+	journaled.OpenJournal(state, DefaultJournalLimit)
+	journaled.WriteFile("/file", nil)
+	journaled.SetXattr("/file", "user.kept", []byte("k"))
+	journaled.SetXattr("/file", "user.gone", []byte("g"))
+	journaled.RemoveXattr("/file", "user.gone")
+	journaled.journal.file.Close()
+
+	recovered := NewFS()
+	assertEqual(t, nil, recovered.OpenJournal(state, DefaultJournalLimit), "Expected recovery to succeed")
+	defer recovered.CloseJournal()
+	names, _ := recovered.ListXattr("/file")
+	assertEqual(t, "user.kept", strings.Join(names, ","), "Expected the journal to replay attributes")
+
+	// Test tar archives keep attributes in PAX records
+	var archive bytes.Buffer
+	fsys.ExportTar(&archive, "/dir")
+	other := NewFS()
+	assertEqual(t, nil, other.ImportTar(bytes.NewReader(archive.Bytes()), "/"), "Expected ImportTar to succeed")
+	value, _ = other.GetXattr("/dir/file", "user.bin")
+	assertEqual(t, "\x00\x01\x02", string(value), "Expected the attribute to survive a tar round trip")
+	value, _ = other.GetXattr("/dir", "trusted.t")
+	assertEqual(t, "dir", string(value), "Expected the superuser to restore trusted attributes")
+
+	// Test other users extract only the attributes they may set
+	other.Mkdir("/home")
+	other.Chmod("/home", 0o777)
+	alice := other.WithCred(Cred{UID: 1000, GID: 1000})
+	assertEqual(t, nil, alice.ImportTar(&archive, "/home"), "Expected ImportTar by another user to succeed")
+	value, _ = alice.GetXattr("/home/dir/file", "user.bin")
+	assertEqual(t, "\x00\x01\x02", string(value), "Expected user attributes to be restored")
+	_, err := other.GetXattr("/home/dir", "trusted.t")
+	assertEqual(t, true, errors.Is(err, ErrNoAttr), "Expected trusted attributes to be left out")
+}
+
+func TestXattrShell(t *testing.T) {
+	shell := NewShell()
+	shell.Touch("file")
+
+	// Test setfattr values in text, hex and base64
+	assertEqual(t, nil, shell.Setfattr("file", "user.text", `"two words"`), "Expected setfattr to succeed")
+	shell.Setfattr("file", "user.hex", "0x00ff")
+	shell.Setfattr("file", "user.b64", "0saGk=")
+	value, _ := shell.GetXattr("/file", "user.b64")
+	assertEqual(t, "hi", string(value), "Expected a base64 value to be decoded")
+	err := shell.Setfattr("file", "user.bad", "0xzz")
+	assertEqual(t, true, errors.Is(err, fs.ErrInvalid), "Expected fs.ErrInvalid for a bad hex value")
+
+	// Test getfattr lists names, and dumps values as text or hex
+	out, _ := shell.Getfattr("file", "", false)
+	assertEqual(t, "# file: file\nuser.b64\nuser.hex\nuser.text\n\n", out, "Expected getfattr to list names")
+	out, _ = shell.Getfattr("file", "", true)
+	assertEqual(t, "# file: file\nuser.b64=\"hi\"\nuser.hex=0x00ff\nuser.text=\"two words\"\n\n", out, "Expected getfattr -d to dump values")
+
+	// Test setfattr -x removes an attribute
+	assertEqual(t, nil, shell.Removefattr("file", "user.hex"), "Expected setfattr -x to succeed")
+	_, err = shell.Getfattr("file", "user.hex", false)
+	assertEqual(t, true, errors.Is(err, ErrNoAttr), "Expected getfattr -n of a removed attribute to fail")
+}
@@ -0,0 +1,186 @@
+package imfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// readZip returns the entries of the zip archive in r, which is size bytes
+// long. Errors are reported against op, and against where when they
+// concern the archive as a whole.
+func readZip(op, where string, r io.ReaderAt, size int64) ([]archiveEntry, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: where, Err: err}
+	}
+	var entries []archiveEntry
+	for _, zf := range zr.File {
+		name, err := archiveName(zf.Name)
+		if err != nil {
+			return nil, &fs.PathError{Op: op, Path: zf.Name, Err: err}
+		}
+		e := archiveEntry{name: name, mode: zf.Mode(), uid: -1, gid: -1, mtime: zf.Modified}
+		switch {
+		case e.mode.IsDir():
+			if name == "" {
+				continue
+			}
+		case e.mode.IsRegular(), e.mode&fs.ModeSymlink != 0:
+			// The contents of a link entry are its target.
+			if name == "" {
+				return nil, &fs.PathError{Op: op, Path: zf.Name, Err: fs.ErrInvalid}
+			}
+			rc, err := zf.Open()
+			if err == nil {
+				e.data, err = io.ReadAll(rc)
+				rc.Close()
+			}
+			if err != nil {
+				return nil, &fs.PathError{Op: op, Path: zf.Name, Err: err}
+			}
+		default:
+			return nil, &fs.PathError{Op: op, Path: zf.Name, Err: errors.ErrUnsupported}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// ImportZip extracts the zip archive in r, which is size bytes long, into
+// the directory dest. It follows the same rules as ImportTar.
+func (fsys *FS) ImportZip(r io.ReaderAt, size int64, dest string) error {
+	entries, err := readZip("import", dest, r, size)
+	if err != nil {
+		return err
+	}
+	return fsys.importEntries("import", dest, entries)
+}
+
+// ExportZip writes src and everything below it to w as a zip archive,
+// naming entries as ExportTar does. Zip archives keep modification times
+// to the second.
+func (fsys *FS) ExportZip(w io.Writer, src string) error {
+	entries, err := fsys.exportEntries("export", src)
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate, Modified: e.mtime}
+		hdr.SetMode(e.mode)
+		if e.mode.IsDir() {
+			hdr.Name += "/"
+			hdr.Method = zip.Store
+		}
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(e.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// Mount makes the zip archive held in the file name browsable as a
+// read-only directory, so that name/inner/file refers to an entry in the
+// archive while name itself is still the archive file. The archive is read
+// when it is mounted, so later changes to the file are not seen until it
+// is mounted again. Mounted contents are left out of snapshots, the
+// journal and exported archives. Since mounting changes what everyone sees
+// at name, only the owner of the archive or the superuser may mount it,
+// and they must be able to read it.
+func (fsys *FS) Mount(name string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	f, err := fsys.lookup("mount", name)
+	if err != nil {
+		return err
+	}
+	if f.IsDirectory {
+		return &fs.PathError{Op: "mount", Path: name, Err: ErrIsDir}
+	}
+	if f.mount != nil {
+		return &fs.PathError{Op: "mount", Path: name, Err: fs.ErrExist}
+	}
+	if err := fsys.permit("mount", f, accessRead); err != nil {
+		return err
+	}
+	if !fsys.owns(f) {
+		return &fs.PathError{Op: "mount", Path: name, Err: fs.ErrPermission}
+	}
+	entries, err := readZip("mount", name, bytes.NewReader(f.Content), int64(len(f.Content)))
+	if err != nil {
+		return err
+	}
+
+	// Mounted files belong to the owner of the archive, and nobody may
+	// write to them.
+	node := func(name string, isDirectory bool, mtime time.Time) *File {
+		n := newFile(name, isDirectory, mtime)
+		n.Mode, n.UID, n.GID = 0o555, f.UID, f.GID
+		n.Ino = fsys.nextIno()
+		n.readOnly = true
+		return n
+	}
+	root := node(f.Name, true, f.ModifiedAt)
+	root.Parent = f.Parent
+	for _, e := range entries {
+		components := strings.Split(e.name, "/")
+		dir := root
+		for i, component := range components {
+			last := i == len(components)-1
+			next := dir.child(component)
+			if next == nil {
+				next = node(component, !last || e.mode.IsDir(), e.mtime)
+				next.Parent = dir
+				dir.Children = append(dir.Children, next)
+			}
+			if (!last || e.mode.IsDir()) != next.IsDirectory {
+				return &fs.PathError{Op: "mount", Path: e.name, Err: ErrNotDir}
+			}
+			dir = next
+		}
+		dir.ModifiedAt = e.mtime
+		dir.Mode = e.mode & modeBits &^ 0o222
+		dir.Size = int64(len(e.data))
+		if e.mode&fs.ModeSymlink != 0 {
+			// Mounted links are followed like any other, so an absolute
+			// target leads out of the archive.
+			dir.IsSymlink, dir.Target = true, string(e.data)
+		} else {
+			dir.Content = e.data
+		}
+	}
+	f.mount = root
+	return nil
+}
+
+// Unmount detaches the archive mounted on the file name. As with Mount,
+// only the owner of the archive or the superuser may do so. Shells whose
+// working directory was inside it are left in a detached directory.
+func (fsys *FS) Unmount(name string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	f, err := fsys.lookup("unmount", strings.TrimRight(name, "/"))
+	if err != nil {
+		return err
+	}
+	if f.mount == nil {
+		return &fs.PathError{Op: "unmount", Path: name, Err: fs.ErrInvalid}
+	}
+	if !fsys.owns(f) {
+		return &fs.PathError{Op: "unmount", Path: name, Err: fs.ErrPermission}
+	}
+	f.mount = nil
+	return nil
+}
@@ -0,0 +1,142 @@
+package imfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestZipRoundTrip(t *testing.T) {
+	fsys := NewFS()
+	fsys.MkdirAll("/src/docs/empty")
+	fsys.WriteFile("/src/docs/note.txt", []byte("Hello, World!"))
+	mtime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	fsys.Chtimes("/src/docs/note.txt", mtime)
+
+	var buf bytes.Buffer
+	assertEqual(t, nil, fsys.ExportZip(&buf, "/src"), "Expected ExportZip to succeed")
+
+	// Test importing restores content, hierarchy and modification times
+	restored := NewFS()
+	err := restored.ImportZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()), "/dest")
+	assertEqual(t, nil, err, "Expected ImportZip to succeed")
+	content, err := restored.ReadFile("dest/src/docs/note.txt")
+	assertEqual(t, nil, err, "Expected imported file to exist")
+	assertEqual(t, "Hello, World!", string(content), "Expected content to be preserved")
+	info, err := restored.Stat("dest/src/docs/empty")
+	assertEqual(t, nil, err, "Expected empty directory to be preserved")
+	assertEqual(t, true, info.IsDir(), "Expected empty to be a directory")
+	info, _ = restored.Stat("dest/src/docs/note.txt")
+	assertEqual(t, true, info.ModTime().Equal(mtime), "Expected modification time to be preserved")
+
+	// Test entries may not escape the destination
+	buf.Reset()
+	zw := zip.NewWriter(&buf)
+	zw.Create("../evil")
+	zw.Close()
+	err = restored.ImportZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()), "/dest")
+	assertEqual(t, true, errors.Is(err, fs.ErrInvalid), "Expected fs.ErrInvalid for an entry outside dest")
+
+	// Test data that is not a zip archive is rejected
+	err = restored.ImportZip(bytes.NewReader([]byte("not a zip")), 9, "/dest")
+	assertEqual(t, true, errors.Is(err, zip.ErrFormat), "Expected zip.ErrFormat for a bad archive")
+}
+
+func TestZipMount(t *testing.T) {
+	shell := NewShell()
+	shell.Mkdir("/build/bundle/inner", true)
+	shell.RedirectWrite("/build/bundle/inner/readme.txt", "inside the zip", false)
+	shell.RedirectWrite("/build/bundle/top.txt", "top", false)
+	assertEqual(t, nil, shell.Zip("/bundle.zip", "/build/bundle/"), "Expected zip to succeed")
+	shell.Remove("/build", true)
+
+	// Test cd into a mounted archive
+	err := shell.Cd("bundle.zip/inner")
+	assertEqual(t, true, errors.Is(err, ErrNotDir), "Expected ErrNotDir before mounting")
+	assertEqual(t, nil, shell.Mount("bundle.zip"), "Expected mount to succeed")
+	assertEqual(t, nil, shell.Cd("bundle.zip/bundle/inner"), "Expected cd into the mounted archive")
+	assertEqual(t, "/bundle.zip/bundle/inner", shell.Pwd(), "Expected pwd to show the path through the archive")
+	content, err := shell.Cat("readme.txt")
+	assertEqual(t, nil, err, "Expected to read a file in the archive")
+	assertEqual(t, "inside the zip", content, "Expected archive content")
+	assertEqual(t, 1, len(shell.Ls()), "Expected ls to list the archive directory")
+	shell.Cd("../../..")
+	assertEqual(t, "/", shell.Pwd(), "Expected .. to leave the archive")
+	assertEqual(t, nil, shell.Cd("bundle.zip"), "Expected cd onto the archive file itself")
+	assertEqual(t, "/bundle.zip", shell.Pwd(), "Expected to be at the archive root")
+	shell.Cd("/")
+
+	// Test the archive file itself is unchanged
+	info, err := shell.FS.Stat("bundle.zip")
+	assertEqual(t, nil, err, "Expected to stat the archive file")
+	assertEqual(t, false, info.IsDir(), "Expected the archive to remain a file")
+
+	// Test the mounted contents are read-only
+	err = shell.RedirectWrite("bundle.zip/bundle/top.txt", "changed", false)
+	assertEqual(t, true, errors.Is(err, ErrReadOnly), "Expected ErrReadOnly writing into the archive")
+	err = shell.Touch("bundle.zip/new")
+	assertEqual(t, true, errors.Is(err, ErrReadOnly), "Expected ErrReadOnly creating in the archive")
+	err = shell.Mkdir("bundle.zip/bundle/inner/sub/dir", true)
+	assertEqual(t, true, errors.Is(err, ErrReadOnly), "Expected ErrReadOnly making directories in the archive")
+	err = shell.Remove("bundle.zip/bundle/top.txt", false)
+	assertEqual(t, true, errors.Is(err, ErrReadOnly), "Expected ErrReadOnly removing from the archive")
+	err = shell.Move("bundle.zip/bundle/top.txt", "/top.txt")
+	assertEqual(t, true, errors.Is(err, ErrReadOnly), "Expected ErrReadOnly moving out of the archive")
+	_, err = shell.FS.OpenFile("/bundle.zip/bundle/top.txt", os.O_RDWR, 0)
+	assertEqual(t, true, errors.Is(err, ErrReadOnly), "Expected ErrReadOnly opening for writing")
+	info, _ = shell.FS.Stat("bundle.zip/bundle/top.txt")
+	assertEqual(t, fs.FileMode(0o444), info.Mode(), "Expected read-only permissions")
+
+	// Test copies out of the archive are ordinary files
+	assertEqual(t, nil, shell.Copy("bundle.zip/bundle", "/copy"), "Expected copy out of the archive to succeed")
+	assertEqual(t, nil, shell.RedirectWrite("/copy/top.txt", "changed", false), "Expected the copy to be writable")
+
+	// Test renaming the archive carries the mount along
+	shell.Mkdir("/moved", false)
+	shell.Move("/bundle.zip", "/moved")
+	shell.Cd("/moved/bundle.zip/bundle")
+	shell.Cd("../..")
+	assertEqual(t, "/moved", shell.Pwd(), "Expected .. to follow the renamed archive")
+
+	// Test unmounting
+	assertEqual(t, nil, shell.Unmount("bundle.zip"), "Expected umount to succeed")
+	err = shell.Cd("bundle.zip/bundle")
+	assertEqual(t, true, errors.Is(err, ErrNotDir), "Expected ErrNotDir after unmounting")
+	err = shell.Unmount("bundle.zip")
+	assertEqual(t, true, errors.Is(err, fs.ErrInvalid), "Expected fs.ErrInvalid unmounting twice")
+
+	// Test mounting something that is not a zip archive
+	shell.RedirectWrite("plain.txt", "text", false)
+	err = shell.Mount("plain.txt")
+	assertEqual(t, true, errors.Is(err, zip.ErrFormat), "Expected zip.ErrFormat mounting a plain file")
+}
+
+func TestZipMountJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.imfs")
+	fsys := NewFS()
+	fsys.OpenJournal(path, 1<<30)
+	fsys.MkdirAll("/src/dir")
+	fsys.WriteFile("/src/dir/file", []byte("zipped"))
+	var buf bytes.Buffer
+	fsys.ExportZip(&buf, "/src")
+	fsys.WriteFile("/bundle.zip", buf.Bytes())
+	fsys.Mount("/bundle.zip")
+
+	// Test a copy out of a mount is replayed from the journal
+	assertEqual(t, nil, fsys.Copy("/bundle.zip/src", "/out"), "Expected copy out of the mount to succeed")
+	live := saved(fsys)
+	fsys.journal.file.Close()
+	fsys.journal = nil
+
+	reopened := NewFS()
+	assertEqual(t, nil, reopened.OpenJournal(path, 1<<30), "Expected the journal to replay")
+	content, _ := reopened.ReadFile("out/dir/file")
+	assertEqual(t, "zipped", string(content), "Expected the copied file to be replayed")
+	assertEqual(t, true, bytes.Equal(live, saved(reopened)), "Expected replay to rebuild the tree exactly")
+	reopened.CloseJournal()
+}
@@ -1,9 +1,79 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+
 	"imfs/imfs"
 )
 
 func main() {
-	imfs.NewShell().Run()
+	state := flag.String("state", "", "host file to load the tree from on startup and save it to on exit")
+	journal := flag.Bool("journal", false, "record every change in a write-ahead journal beside the -state file")
+	command := flag.String("c", "", "run the given commands instead of reading them from standard input")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: imfs [-state file [-journal]] [-c commands | script]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *journal && *state == "" {
+		fmt.Fprintln(os.Stderr, "imfs: -journal requires -state")
+		os.Exit(2)
+	}
+	if flag.NArg() > 1 || flag.NArg() == 1 && *command != "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// A script is run in place of the commands on standard input, as is
+	// the argument of -c.
+	script, batch := *command, false
+	flag.Visit(func(f *flag.Flag) { batch = batch || f.Name == "c" })
+	if flag.NArg() == 1 {
+		data, err := os.ReadFile(flag.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "imfs:", err)
+			os.Exit(1)
+		}
+		script, batch = string(data), true
+	}
+
+	shell := imfs.NewShell()
+	switch {
+	case *journal:
+		if err := shell.FS.OpenJournal(*state, imfs.DefaultJournalLimit); err != nil {
+			fmt.Fprintln(os.Stderr, "imfs:", err)
+			os.Exit(1)
+		}
+	case *state != "":
+		if err := shell.FS.LoadFile(*state); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			fmt.Fprintln(os.Stderr, "imfs:", err)
+			os.Exit(1)
+		}
+	}
+
+	var status int
+	if batch {
+		status = shell.Exec(script, imfs.Stdio{In: os.Stdin, Out: os.Stdout, Err: os.Stderr})
+	} else {
+		status = shell.Run()
+	}
+
+	switch {
+	case *journal:
+		if err := shell.FS.CloseJournal(); err != nil {
+			fmt.Fprintln(os.Stderr, "imfs:", err)
+			os.Exit(1)
+		}
+	case *state != "":
+		if err := shell.FS.SaveFile(*state); err != nil {
+			fmt.Fprintln(os.Stderr, "imfs:", err)
+			os.Exit(1)
+		}
+	}
+	os.Exit(status)
 }